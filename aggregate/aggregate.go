@@ -0,0 +1,229 @@
+package aggregate
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/andriiyaremenko/tinycqs/snapshot"
+	"github.com/google/uuid"
+)
+
+// Aggregate is state of event-sourced domain model.
+type Aggregate interface {
+	// Applies past Event to aggregate state.
+	Apply(event command.Event) error
+}
+
+// *Handler implements command.Handler.
+// Handler loads Aggregate from its stream, applies past Events, decides new Events,
+// appends them to the stream with optimistic concurrency
+// and writes them to command.EventWriter for downstream chaining.
+// On version mismatch *command.ErrEvent caused by *eventstore.ErrConcurrencyConflict is written instead.
+// If Snapshots is set and Aggregate implements snapshot.Snapshotter
+// it is restored from latest snapshot (keyed by stream ID) and only newer Events are applied.
+type Handler struct {
+	// Type of command Event.
+	Type  string
+	Store eventstore.EventStore
+	// Stream ID of aggregate is StreamPrefix followed by aggregate ID.
+	StreamPrefix string
+	// Returns ID of aggregate command Event is addressed to.
+	ID func(event command.Event) (string, error)
+	// Returns new aggregate without any Events applied.
+	New func(id string) Aggregate
+	// Returns Events produced by command Event.
+	Decide func(ctx context.Context, aggregate Aggregate, event command.Event) ([]command.Event, error)
+	// Optional store of aggregate snapshots.
+	Snapshots snapshot.Store
+	// Decides when snapshots are taken.
+	SnapshotPolicy snapshot.Policy
+	// Optional callback receiving errors of snapshots taken by SnapshotPolicy.
+	// Snapshot errors do not fail command, since its Events are already stored.
+	OnSnapshotError func(id string, err error)
+	NWorkers        int
+}
+
+// Returns Type.
+func (h *Handler) EventType() string {
+	return h.Type
+}
+
+func (h *Handler) Workers() int {
+	return h.NWorkers
+}
+
+// Returns stream ID of aggregate with id.
+func (h *Handler) StreamID(id string) string {
+	return h.StreamPrefix + id
+}
+
+// Returns aggregate with id and its version.
+func (h *Handler) Load(ctx context.Context, id string) (Aggregate, int64, error) {
+	aggregate := h.New(id)
+	if snapshotter, ok := h.snapshotter(aggregate); ok {
+		version, err := h.snapshots().Load(ctx, h.StreamID(id), h.StreamID(id), snapshotter)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		return aggregate, version, nil
+	}
+
+	records, err := h.Store.ReadStream(ctx, h.StreamID(id), 1, eventstore.Forward, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := apply(aggregate, eventstore.NoStream, records)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return aggregate, version, nil
+}
+
+func (h *Handler) Handle(ctx context.Context, w command.EventWriter, event command.Event) {
+	defer w.Done()
+
+	id, err := h.ID(event)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	aggregate, version, err := h.Load(ctx, id)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	h.decide(ctx, w, event, id, aggregate, version)
+}
+
+// decides new Events, appends them to aggregate stream and writes them to w.
+func (h *Handler) decide(ctx context.Context, w command.EventWriter, event command.Event,
+	id string, aggregate Aggregate, version int64) {
+	events, err := h.Decide(ctx, aggregate, event)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	events = caused(event, events)
+	records, err := h.Store.Append(ctx, h.StreamID(id), version, events...)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	if err := h.snapshot(ctx, id, aggregate, version, records); err != nil && h.OnSnapshotError != nil {
+		h.OnSnapshotError(id, err)
+	}
+
+	for _, e := range events {
+		w.Write(e)
+	}
+}
+
+// Takes snapshot of aggregate with id and its version.
+// Returns error if Snapshots is not set or Aggregate does not implement snapshot.Snapshotter.
+func (h *Handler) Snapshot(ctx context.Context, id string) error {
+	aggregate, version, err := h.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	snapshotter, ok := h.snapshotter(aggregate)
+	if !ok {
+		return ErrSnapshotsNotSupported
+	}
+
+	return h.snapshots().Snapshot(ctx, h.StreamID(id), version, snapshotter)
+}
+
+// takes snapshot of aggregate if SnapshotPolicy requires it after records were appended.
+func (h *Handler) snapshot(ctx context.Context, id string, aggregate Aggregate,
+	version int64, records []eventstore.Record) error {
+	snapshotter, ok := h.snapshotter(aggregate)
+	if !ok || len(records) == 0 {
+		return nil
+	}
+
+	current := records[len(records)-1].Version
+	if !h.SnapshotPolicy.ShouldSnapshot(version, current) {
+		return nil
+	}
+
+	if _, err := apply(aggregate, version, records); err != nil {
+		return err
+	}
+
+	return h.snapshots().Snapshot(ctx, h.StreamID(id), current, snapshotter)
+}
+
+// returns aggregate as snapshot.Snapshotter if snapshots are enabled.
+func (h *Handler) snapshotter(aggregate Aggregate) (snapshot.Snapshotter, bool) {
+	if h.Snapshots == nil {
+		return nil, false
+	}
+
+	snapshotter, ok := aggregate.(snapshot.Snapshotter)
+
+	return snapshotter, ok
+}
+
+func (h *Handler) snapshots() *snapshot.Repository {
+	return &snapshot.Repository{Snapshots: h.Snapshots, Events: h.Store, Policy: h.SnapshotPolicy}
+}
+
+// Returned by Handler.Snapshot if snapshots are not enabled for Aggregate.
+var ErrSnapshotsNotSupported = errors.New("aggregate snapshots are not supported")
+
+// Reports whether err is caused by *eventstore.ErrConcurrencyConflict.
+// Can be used as command.RetryPolicy.Retryable to retry conflicting commands.
+func IsConcurrencyConflict(err error) bool {
+	return errors.As(err, new(*eventstore.ErrConcurrencyConflict))
+}
+
+// applies records to aggregate and returns its new version.
+func apply(aggregate Aggregate, version int64, records []eventstore.Record) (int64, error) {
+	for _, r := range records {
+		if err := aggregate.Apply(r.Event()); err != nil {
+			return version, err
+		}
+
+		version = r.Version
+	}
+
+	return version, nil
+}
+
+// adds metadata caused by event to events,
+// so they are stored and chained with the same metadata.
+func caused(event command.Event, events []command.Event) []command.Event {
+	withMetadata := command.AsEventWithMetadata(event)
+	if withMetadata == nil || withMetadata.Metadata() == nil {
+		return events
+	}
+
+	result := make([]command.Event, 0, len(events))
+	for _, e := range events {
+		if command.AsEventWithMetadata(e) == nil {
+			e = command.WithMetadata(e, withMetadata.Metadata().New(uuid.New().String()))
+		}
+
+		result = append(result, e)
+	}
+
+	return result
+}
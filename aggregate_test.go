@@ -0,0 +1,142 @@
+package tinycqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/aggregate"
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	t.Run("Aggregate handler should decide and store events", testAggregateShouldDecideAndStoreEvents)
+	t.Run("Aggregate handler should report concurrency conflict", testAggregateShouldReportConflict)
+}
+
+type testAccount struct {
+	id      string
+	balance int
+	applied int
+}
+
+func (a *testAccount) Apply(event command.Event) error {
+	amount, err := strconv.Atoi(string(event.Payload()))
+	if err != nil {
+		return err
+	}
+
+	switch event.EventType() {
+	case "deposited":
+		a.balance += amount
+	case "withdrawn":
+		a.balance -= amount
+	}
+
+	a.applied++
+
+	return nil
+}
+
+// returns a itself, so aggregates embedding testAccount can be used with withdraw.
+func (a *testAccount) account() *testAccount {
+	return a
+}
+
+type testAccountCommand struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+func testAccountHandler(store eventstore.EventStore, eventType string,
+	decide func(context.Context, aggregate.Aggregate, command.Event) ([]command.Event, error)) *aggregate.Handler {
+	return &aggregate.Handler{
+		Type:         eventType,
+		Store:        store,
+		StreamPrefix: "account-",
+		ID: func(event command.Event) (string, error) {
+			var cmd testAccountCommand
+			err := json.Unmarshal(event.Payload(), &cmd)
+
+			return cmd.Account, err
+		},
+		New: func(id string) aggregate.Aggregate {
+			return &testAccount{id: id}
+		},
+		Decide: decide}
+}
+
+func withdraw(ctx context.Context, a aggregate.Aggregate, event command.Event) ([]command.Event, error) {
+	var cmd testAccountCommand
+	if err := json.Unmarshal(event.Payload(), &cmd); err != nil {
+		return nil, err
+	}
+
+	if a.(interface{ account() *testAccount }).account().balance < cmd.Amount {
+		return nil, errors.New("insufficient funds")
+	}
+
+	return []command.Event{command.E{Type: "withdrawn", P: []byte(strconv.Itoa(cmd.Amount))}}, nil
+}
+
+func testAggregateShouldDecideAndStoreEvents(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+
+	_, err := store.Append(ctx, "account-1", eventstore.NoStream,
+		command.E{Type: "deposited", P: []byte("100")})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	withdrawnWasCalled := &wasCalledCounter{}
+	c, _ := command.New(
+		testAccountHandler(store, "withdraw", withdraw),
+		command.HandlerFunc("withdrawn", func(ctx context.Context, _ []byte) error {
+			withdrawnWasCalled.increase()
+			return nil
+		}),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "withdraw", P: []byte(`{"account": "1", "amount": 70}`)})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(1, withdrawnWasCalled.getCount(), "decided event should be chained")
+
+	ev = c.Handle(ctx, command.E{Type: "withdraw", P: []byte(`{"account": "1", "amount": 70}`)})
+	assert.Contains(ev.Err().Error(), "insufficient funds", "error should be returned")
+
+	handler := testAccountHandler(store, "withdraw", withdraw)
+	account, version, err := handler.Load(ctx, "1")
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(2), version, "version should equal amount of events")
+	assert.Equal(30, account.(*testAccount).balance, "balance should be restored from events")
+}
+
+func testAggregateShouldReportConflict(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+
+	concurrentWithdraw := func(ctx context.Context, a aggregate.Aggregate, event command.Event) ([]command.Event, error) {
+		if _, err := store.Append(ctx, "account-1", eventstore.AnyVersion,
+			command.E{Type: "deposited", P: []byte("10")}); err != nil {
+			return nil, err
+		}
+
+		return []command.Event{command.E{Type: "withdrawn", P: []byte("1")}}, nil
+	}
+
+	c, _ := command.New(testAccountHandler(store, "withdraw", concurrentWithdraw))
+
+	ev := c.Handle(ctx, command.E{Type: "withdraw", P: []byte(`{"account": "1", "amount": 1}`)})
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+
+	if assert.Len(inner, 1, "one error should be returned") {
+		assert.True(aggregate.IsConcurrencyConflict(inner[0]), "concurrency conflict should be reported")
+	}
+}
@@ -0,0 +1,276 @@
+// Package breaker implements circuit breaker for command and query Handlers.
+package breaker
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State of circuit.
+type State int
+
+const (
+	// Calls pass through and their failures are counted.
+	Closed State = iota
+	// Calls are rejected with *ErrCircuitOpen until CoolDown passes.
+	Open
+	// Limited amount of trial calls pass through to decide whether circuit is closed or open again.
+	HalfOpen
+)
+
+// Returns name of state.
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return strconv.Itoa(int(s))
+	}
+}
+
+// error type returned for calls rejected by open circuit.
+type ErrCircuitOpen struct {
+	// Name of Breaker.
+	Name string
+	// Time circuit becomes half-open.
+	Until time.Time
+}
+
+// Implementation of error.
+func (err *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open until %s", err.Name, err.Until.Format(time.RFC3339))
+}
+
+// Settings of Breaker.
+type Settings struct {
+	// Name of Breaker used in errors and callbacks.
+	Name string
+	// Opens circuit after ConsecutiveFailures calls failed in a row. 0 disables the threshold.
+	ConsecutiveFailures int
+	// Opens circuit once share of failed calls amongst last Window calls reaches FailureRate in range (0, 1].
+	// 0 disables the threshold.
+	FailureRate float64
+	// Amount of last calls FailureRate is measured on. Defaults to 10.
+	Window int
+	// Time circuit stays open before it becomes half-open. Defaults to 1 second.
+	CoolDown time.Duration
+	// Amount of trial calls in half-open state.
+	// Circuit is closed once all of them succeed and opened again once any of them fails. Defaults to 1.
+	HalfOpenCalls int
+	// Reports if error is failure of call.
+	// Every error is failure if IsFailure is nil.
+	IsFailure func(err error) bool
+	// Called after state of circuit changed.
+	OnStateChange func(name string, from, to State)
+}
+
+// Returns Breaker with settings.
+func New(settings Settings) *Breaker {
+	if settings.Window < 1 {
+		settings.Window = 10
+	}
+
+	if settings.CoolDown <= 0 {
+		settings.CoolDown = time.Second
+	}
+
+	if settings.HalfOpenCalls < 1 {
+		settings.HalfOpenCalls = 1
+	}
+
+	return &Breaker{settings: settings, outcomes: make([]bool, 0, settings.Window)}
+}
+
+// Breaker tracks outcomes of calls to a dependency and rejects calls while it is failing.
+// Single Breaker can wrap several Handlers that share the same dependency.
+type Breaker struct {
+	mu sync.Mutex
+
+	settings Settings
+	state    State
+	// incremented on every state change to ignore outcomes of calls started in previous state
+	generation uint64
+	openedAt   time.Time
+
+	consecutive int
+	// outcomes of last calls in closed state, true means failure
+	outcomes []bool
+	next     int
+
+	trials    int
+	succeeded int
+}
+
+// Returns name of Breaker.
+func (b *Breaker) Name() string {
+	return b.settings.Name
+}
+
+// Returns current state of circuit.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && !time.Now().Before(b.openedAt.Add(b.settings.CoolDown)) {
+		return HalfOpen
+	}
+
+	return b.state
+}
+
+// Returns function to report outcome of call or *ErrCircuitOpen if call is rejected.
+// done should be called exactly once with error call finished with.
+func (b *Breaker) Allow() (done func(err error), err error) {
+	b.mu.Lock()
+
+	var changed []transition
+	if b.state == Open {
+		until := b.openedAt.Add(b.settings.CoolDown)
+		if time.Now().Before(until) {
+			b.mu.Unlock()
+
+			return nil, &ErrCircuitOpen{Name: b.settings.Name, Until: until}
+		}
+
+		changed = append(changed, b.setState(HalfOpen))
+	}
+
+	if b.state == HalfOpen {
+		if b.trials >= b.settings.HalfOpenCalls {
+			until := time.Now().Add(b.settings.CoolDown)
+			b.mu.Unlock()
+			b.notify(changed)
+
+			return nil, &ErrCircuitOpen{Name: b.settings.Name, Until: until}
+		}
+
+		b.trials++
+	}
+
+	generation := b.generation
+	b.mu.Unlock()
+	b.notify(changed)
+
+	var once sync.Once
+
+	return func(err error) {
+		once.Do(func() { b.record(generation, b.isFailure(err)) })
+	}, nil
+}
+
+func (b *Breaker) isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if b.settings.IsFailure == nil {
+		return true
+	}
+
+	return b.settings.IsFailure(err)
+}
+
+// records outcome of call started in generation.
+func (b *Breaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+
+	if generation != b.generation {
+		b.mu.Unlock()
+
+		return
+	}
+
+	var changed []transition
+	switch b.state {
+	case Closed:
+		if b.trip(failed) {
+			changed = append(changed, b.setState(Open))
+		}
+	case HalfOpen:
+		if failed {
+			changed = append(changed, b.setState(Open))
+
+			break
+		}
+
+		if b.succeeded++; b.succeeded >= b.settings.HalfOpenCalls {
+			changed = append(changed, b.setState(Closed))
+		}
+	}
+
+	b.mu.Unlock()
+	b.notify(changed)
+}
+
+// counts outcome of call in closed state and reports whether circuit should be opened.
+func (b *Breaker) trip(failed bool) bool {
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	if len(b.outcomes) < b.settings.Window {
+		b.outcomes = append(b.outcomes, failed)
+	} else {
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % b.settings.Window
+	}
+
+	if b.settings.ConsecutiveFailures > 0 && b.consecutive >= b.settings.ConsecutiveFailures {
+		return true
+	}
+
+	if b.settings.FailureRate <= 0 || len(b.outcomes) < b.settings.Window {
+		return false
+	}
+
+	failures := 0
+	for _, f := range b.outcomes {
+		if f {
+			failures++
+		}
+	}
+
+	return float64(failures)/float64(len(b.outcomes)) >= b.settings.FailureRate
+}
+
+type transition struct {
+	from, to State
+}
+
+// changes state of circuit and resets counters.
+func (b *Breaker) setState(state State) transition {
+	t := transition{from: b.state, to: state}
+
+	b.state = state
+	b.generation++
+	b.consecutive = 0
+	b.outcomes = b.outcomes[:0]
+	b.next = 0
+	b.trials = 0
+	b.succeeded = 0
+
+	if state == Open {
+		b.openedAt = time.Now()
+	}
+
+	return t
+}
+
+// calls OnStateChange for every transition.
+func (b *Breaker) notify(changed []transition) {
+	if b.settings.OnStateChange == nil {
+		return
+	}
+
+	for _, t := range changed {
+		b.settings.OnStateChange(b.settings.Name, t.from, t.to)
+	}
+}
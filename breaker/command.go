@@ -0,0 +1,107 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// Handles event rejected by open circuit with err instead of Handler.
+// Fallback should call w.Done.
+type CommandFallback func(ctx context.Context, w command.EventWriter, event command.Event, err error)
+
+// Returns command.Handler that passes Events to h while circuit of b is not open.
+// Call fails if h writes error Event, panics or does not call Done before deadline of ctx.
+// Cancellation of ctx does not fail call, since it is not caused by h.
+// Rejected Events are passed to fallback if it is set,
+// otherwise *command.ErrEvent caused by *ErrCircuitOpen is written.
+func (b *Breaker) Command(h command.Handler, fallback CommandFallback) command.Handler {
+	return &commandHandler{HandlerWrapper: command.HandlerWrapper{Handler: h}, breaker: b, fallback: fallback}
+}
+
+type commandHandler struct {
+	command.HandlerWrapper
+
+	breaker  *Breaker
+	fallback CommandFallback
+}
+
+func (ch *commandHandler) Handle(ctx context.Context, w command.EventWriter, event command.Event) {
+	done, err := ch.breaker.Allow()
+	if err != nil {
+		if ch.fallback != nil {
+			ch.fallback(ctx, w, event, err)
+
+			return
+		}
+
+		w.Write(command.NewErrEvent(event, err))
+		w.Done()
+
+		return
+	}
+
+	bw := &breakerWriter{w: w, done: done, finished: make(chan struct{})}
+	defer func() {
+		if r := recover(); r != nil {
+			bw.finish(&command.ErrHandlerPanicked{Value: r, Event: event})
+
+			panic(r)
+		}
+	}()
+
+	// Handler that ignores ctx may never call Done
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err == context.DeadlineExceeded {
+				bw.finish(err)
+			}
+		case <-bw.finished:
+		}
+	}()
+
+	ch.Handler.Handle(ctx, bw, event)
+}
+
+// reports outcome of call once Handler is done or deadline of ctx passes, whichever happens first.
+type breakerWriter struct {
+	w    command.EventWriter
+	done func(err error)
+
+	mu  sync.Mutex
+	err error
+	// closed once outcome of call is reported
+	finished chan struct{}
+	once     sync.Once
+}
+
+func (bw *breakerWriter) Write(e command.Event) {
+	if err := e.Err(); err != nil {
+		bw.mu.Lock()
+		if bw.err == nil {
+			bw.err = err
+		}
+		bw.mu.Unlock()
+	}
+
+	bw.w.Write(e)
+}
+
+func (bw *breakerWriter) Done() {
+	bw.mu.Lock()
+	err := bw.err
+	bw.mu.Unlock()
+
+	bw.finish(err)
+	bw.w.Done()
+}
+
+// reports outcome of call once.
+func (bw *breakerWriter) finish(err error) {
+	bw.once.Do(func() {
+		bw.done(err)
+		close(bw.finished)
+	})
+}
@@ -0,0 +1,64 @@
+package breaker
+
+import (
+	"context"
+
+	"github.com/andriiyaremenko/tinycqs/query"
+)
+
+// Returns body of query rejected by open circuit with err instead of Handler.
+type QueryFallback func(ctx context.Context, payload []byte, err error) ([]byte, error)
+
+// Returns query.Handler that passes queries to h while circuit of b is not open.
+// Call fails if any of query Results has error.
+// Rejected queries are passed to fallback if it is set,
+// otherwise query.Result with *ErrCircuitOpen error is returned.
+func (b *Breaker) Query(h query.Handler, fallback QueryFallback) query.Handler {
+	return &queryHandler{Handler: h, breaker: b, fallback: fallback}
+}
+
+type queryHandler struct {
+	query.Handler
+
+	breaker  *Breaker
+	fallback QueryFallback
+}
+
+func (qh *queryHandler) Handle(ctx context.Context, w query.ResultWriter, payload []byte) <-chan query.Result {
+	done, err := qh.breaker.Allow()
+	if err != nil {
+		r := w.GetReader()
+
+		go func() {
+			result := query.Q{Name: qh.QueryName(), Error: err}
+			if qh.fallback != nil {
+				result.B, result.Error = qh.fallback(ctx, payload, err)
+			}
+
+			w.Write(result)
+			w.Done()
+		}()
+
+		return r.Read()
+	}
+
+	results := qh.Handler.Handle(ctx, w, payload)
+	out := make(chan query.Result)
+
+	go func() {
+		defer close(out)
+
+		var err error
+		for r := range results {
+			if err == nil {
+				err = r.Err()
+			}
+
+			out <- r
+		}
+
+		done(err)
+	}()
+
+	return out
+}
@@ -0,0 +1,248 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/breaker"
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	t.Run("Breaker should open after consecutive failures", testBreakerShouldOpenAfterConsecutiveFailures)
+	t.Run("Breaker should open when failure rate is reached", testBreakerShouldOpenOnFailureRate)
+	t.Run("Breaker should close after successful trial calls", testBreakerShouldCloseAfterTrialCalls)
+	t.Run("Breaker should use command fallback while open", testBreakerShouldUseCommandFallback)
+	t.Run("Breaker should reject queries while open", testBreakerShouldRejectQueries)
+	t.Run("Breaker should not count calls done after cancellation as failures",
+		testBreakerShouldNotCountCancelledCallsAsFailures)
+	t.Run("Breaker should count calls not done before timeout as failures",
+		testBreakerShouldCountCallsNotDoneInTimeAsFailures)
+}
+
+// records state changes of Breaker.
+type stateChanges struct {
+	mu      sync.Mutex
+	changes []string
+}
+
+func (sc *stateChanges) record(name string, from, to breaker.State) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.changes = append(sc.changes, name+": "+from.String()+" -> "+to.String())
+}
+
+func (sc *stateChanges) get() []string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return append([]string(nil), sc.changes...)
+}
+
+// returns Handler failing while fail returns true and counting its calls.
+func failingHandler(eventType string, counter *wasCalledCounter, fail func() bool) command.Handler {
+	return command.HandlerFunc(eventType, func(context.Context, []byte) error {
+		counter.increase()
+		if fail() {
+			return errors.New("dependency is down")
+		}
+
+		return nil
+	})
+}
+
+func testBreakerShouldOpenAfterConsecutiveFailures(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	changes := &stateChanges{}
+	b := breaker.New(breaker.Settings{
+		Name:                "payments",
+		ConsecutiveFailures: 3,
+		CoolDown:            time.Hour,
+		OnStateChange:       changes.record})
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(b.Command(failingHandler("charge", handlerWasCalled, func() bool { return true }), nil))
+
+	for i := 0; i < 3; i++ {
+		assert.Error(c.Handle(ctx, command.E{Type: "charge"}).Err(), "error should be returned")
+	}
+
+	assert.Equal(breaker.Open, b.State(), "circuit should be open")
+
+	ev := c.Handle(ctx, command.E{Type: "charge"})
+	errOpen := new(breaker.ErrCircuitOpen)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	assert.True(errors.As(inner[0], &errOpen), "ErrCircuitOpen should be returned")
+	assert.Equal("payments", errOpen.Name, "error should name breaker")
+	assert.Equal(3, handlerWasCalled.getCount(), "handler should not be called while circuit is open")
+	assert.Equal([]string{"payments: closed -> open"}, changes.get(), "state change should be reported")
+}
+
+func testBreakerShouldOpenOnFailureRate(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	b := breaker.New(breaker.Settings{Name: "payments", FailureRate: 0.5, Window: 4, CoolDown: time.Hour})
+	handlerWasCalled := &wasCalledCounter{}
+	calls := 0
+	c, _ := command.New(b.Command(failingHandler("charge", handlerWasCalled, func() bool {
+		calls++
+
+		return calls%2 == 0
+	}), nil))
+
+	for i := 0; i < 3; i++ {
+		c.Handle(ctx, command.E{Type: "charge"})
+	}
+
+	assert.Equal(breaker.Closed, b.State(), "circuit should stay closed until window is full")
+
+	c.Handle(ctx, command.E{Type: "charge"})
+	assert.Equal(breaker.Open, b.State(), "circuit should open once failure rate is reached")
+}
+
+func testBreakerShouldCloseAfterTrialCalls(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	changes := &stateChanges{}
+	b := breaker.New(breaker.Settings{
+		Name:                "payments",
+		ConsecutiveFailures: 1,
+		CoolDown:            time.Millisecond * 20,
+		HalfOpenCalls:       2,
+		OnStateChange:       changes.record})
+	handlerWasCalled := &wasCalledCounter{}
+	fail := true
+	c, _ := command.New(b.Command(failingHandler("charge", handlerWasCalled, func() bool { return fail }), nil))
+
+	c.Handle(ctx, command.E{Type: "charge"})
+	assert.Equal(breaker.Open, b.State(), "circuit should be open")
+
+	time.Sleep(time.Millisecond * 30)
+	assert.Equal(breaker.HalfOpen, b.State(), "circuit should be half-open after cool-down")
+
+	c.Handle(ctx, command.E{Type: "charge"})
+	assert.Equal(breaker.Open, b.State(), "failed trial call should open circuit again")
+
+	time.Sleep(time.Millisecond * 30)
+
+	fail = false
+	assert.NoError(c.Handle(ctx, command.E{Type: "charge"}).Err(), "trial call should pass")
+	assert.Equal(breaker.HalfOpen, b.State(), "circuit should stay half-open until all trial calls succeed")
+	assert.NoError(c.Handle(ctx, command.E{Type: "charge"}).Err(), "trial call should pass")
+	assert.Equal(breaker.Closed, b.State(), "circuit should be closed")
+
+	assert.Equal([]string{
+		"payments: closed -> open",
+		"payments: open -> half-open",
+		"payments: half-open -> open",
+		"payments: open -> half-open",
+		"payments: half-open -> closed"}, changes.get(), "state changes should be reported")
+}
+
+func testBreakerShouldUseCommandFallback(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	b := breaker.New(breaker.Settings{Name: "payments", ConsecutiveFailures: 1, CoolDown: time.Hour})
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(
+		b.Command(failingHandler("charge", handlerWasCalled, func() bool { return true }),
+			func(ctx context.Context, w command.EventWriter, event command.Event, err error) {
+				defer w.Done()
+
+				w.Write(command.E{Type: "charge_later", P: event.Payload()})
+			}),
+		command.HandlerFunc("charge_later", func(context.Context, []byte) error { return nil }))
+
+	assert.Error(c.Handle(ctx, command.E{Type: "charge"}).Err(), "error should be returned")
+	assert.NoError(c.Handle(ctx, command.E{Type: "charge"}).Err(), "fallback should handle event")
+	assert.Equal(1, handlerWasCalled.getCount(), "handler should not be called while circuit is open")
+}
+
+func testBreakerShouldRejectQueries(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	b := breaker.New(breaker.Settings{Name: "catalog", ConsecutiveFailures: 1, CoolDown: time.Hour})
+	handlerWasCalled := &wasCalledCounter{}
+	failing := query.HandlerFunc("products", func(context.Context, []byte) ([]byte, error) {
+		handlerWasCalled.increase()
+
+		return nil, errors.New("dependency is down")
+	})
+	q, _ := query.New(
+		b.Query(failing, nil),
+		b.Query(query.HandlerFunc("cached", nil), func(context.Context, []byte, error) ([]byte, error) {
+			return []byte(`[]`), nil
+		}))
+
+	for r := range q.Handle(ctx, "products", nil) {
+		assert.Error(r.Err(), "error should be returned")
+	}
+
+	for r := range q.Handle(ctx, "products", nil) {
+		errOpen := new(breaker.ErrCircuitOpen)
+		assert.True(errors.As(r.Err(), &errOpen), "ErrCircuitOpen should be returned")
+		assert.Equal("products", r.QueryName(), "result should have query name")
+	}
+
+	for r := range q.Handle(ctx, "cached", nil) {
+		assert.NoError(r.Err(), "fallback should return result")
+		assert.Equal([]byte(`[]`), r.Body(), "fallback body should be returned")
+	}
+
+	assert.Equal(1, handlerWasCalled.getCount(), "handler should not be called while circuit is open")
+}
+
+func testBreakerShouldCountCallsNotDoneInTimeAsFailures(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+
+	defer close(release)
+
+	b := breaker.New(breaker.Settings{Name: "payments", ConsecutiveFailures: 1, CoolDown: time.Hour})
+	c, _ := command.New(b.Command(command.WithTimeout(&command.BaseHandler{
+		Type: "charge",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+			<-release
+		}}, time.Millisecond*20), nil))
+
+	assert.Error(c.Handle(context.TODO(), command.E{Type: "charge"}).Err(), "error should be returned")
+	assert.Eventually(func() bool { return b.State() == breaker.Open }, time.Second, time.Millisecond*10,
+		"call not done before its timeout should open circuit")
+}
+
+func testBreakerShouldNotCountCancelledCallsAsFailures(t *testing.T) {
+	assert := assert.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	b := breaker.New(breaker.Settings{Name: "payments", ConsecutiveFailures: 1, CoolDown: time.Hour})
+	h := b.Command(&command.BaseHandler{
+		Type: "charge",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+			defer w.Done()
+
+			cancel()
+			time.Sleep(time.Millisecond * 20)
+		}}, nil)
+
+	done := make(chan struct{})
+	h.Handle(ctx, &doneWriter{done: done}, command.E{Type: "charge"})
+	<-done
+
+	assert.Equal(breaker.Closed, b.State(), "successful call of cancelled chain should not open circuit")
+}
+
+// command.EventWriter closing done once Done is called.
+type doneWriter struct {
+	done chan struct{}
+}
+
+func (w *doneWriter) Write(command.Event) {}
+
+func (w *doneWriter) Done() {
+	close(w.done)
+}
@@ -0,0 +1,112 @@
+package command
+
+type chainNode struct {
+	eventType string
+	parentID  string
+	depth     int
+}
+
+// tracks causation chain of Events dispatched during single Commands.Handle call.
+// Is not safe for concurrent use.
+type chainTracker struct {
+	maxDepth     int
+	maxEvents    int
+	detectCycles bool
+
+	nodes  map[string]chainNode
+	events int
+}
+
+func newChainTracker(maxDepth, maxEvents int, detectCycles bool) *chainTracker {
+	return &chainTracker{
+		maxDepth:     maxDepth,
+		maxEvents:    maxEvents,
+		detectCycles: detectCycles,
+		nodes:        make(map[string]chainNode)}
+}
+
+// registers event in the chain.
+// Returns *ErrTooManyEvents, *ErrChainTooDeep or *ErrEventCycle if event violates limits.
+func (t *chainTracker) track(event EventWithMetadata) error {
+	// done and error Events are results of their parent rather than next step of the chain
+	isResult := AsDoneEvent(event) != nil || event.Err() != nil
+	if !isResult {
+		t.events++
+		if t.maxEvents > 0 && t.events > t.maxEvents {
+			return &ErrTooManyEvents{Max: t.maxEvents}
+		}
+	}
+
+	metadata := event.Metadata()
+	if metadata == nil {
+		return nil
+	}
+
+	node := chainNode{eventType: event.EventType(), depth: 1}
+	if parent, ok := t.nodes[metadata.CausationID()]; ok && metadata.CausationID() != metadata.ID() {
+		node.parentID = metadata.CausationID()
+		node.depth = parent.depth + 1
+	}
+
+	if !isResult {
+		if t.maxDepth > 0 && node.depth > t.maxDepth {
+			return &ErrChainTooDeep{Max: t.maxDepth, Path: t.path(node)}
+		}
+
+		if t.detectCycles && t.hasAncestor(node, node.eventType) {
+			return &ErrEventCycle{Path: t.path(node)}
+		}
+	}
+
+	t.nodes[metadata.ID()] = node
+
+	return nil
+}
+
+// registers event as another copy of original.
+func (t *chainTracker) alias(event, original EventWithMetadata) {
+	if event.Metadata() == nil || original.Metadata() == nil {
+		return
+	}
+
+	if node, ok := t.nodes[original.Metadata().ID()]; ok {
+		t.nodes[event.Metadata().ID()] = node
+	}
+}
+
+func (t *chainTracker) hasAncestor(node chainNode, eventType string) bool {
+	for id := node.parentID; id != ""; {
+		parent, ok := t.nodes[id]
+		if !ok {
+			return false
+		}
+
+		if parent.eventType == eventType {
+			return true
+		}
+
+		id = parent.parentID
+	}
+
+	return false
+}
+
+// returns event types from root of the chain to node.
+func (t *chainTracker) path(node chainNode) []string {
+	path := []string{node.eventType}
+	for id := node.parentID; id != ""; {
+		parent, ok := t.nodes[id]
+		if !ok {
+			break
+		}
+
+		path = append(path, parent.eventType)
+		id = parent.parentID
+	}
+
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+
+	return path
+}
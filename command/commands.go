@@ -3,15 +3,21 @@ package command
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"sync"
+	"time"
 
+	"github.com/andriiyaremenko/tinycqs/internal/match"
 	"github.com/andriiyaremenko/tinycqs/tracing"
 	"github.com/google/uuid"
 )
 
-// Returns new Commands with Concurrency Limit equals to limit or error.
-// Concurrency Limit is amount of Events that can be processed concurrently per each handler.
-func NewWithConcurrencyLimit(limit int, handlers ...Handler) (Commands, error) {
+// Returns new Commands configured with options or error.
+// Concurrency Limit defaults to 1.
+// Several Handlers can subscribe to the same event type: each of them receives every Event of that type.
+// Handlers can subscribe to patterns of event types (see MatchEventType).
+// Event is routed to Handlers of exact event type if any, otherwise to Handlers of the most precise pattern.
+func NewWithOptions(options []Option, handlers ...Handler) (Commands, error) {
 	globalErrHandlersN := 0
 	for _, h := range handlers {
 		if h.EventType() == CatchAllErrorEventType {
@@ -27,11 +33,34 @@ func NewWithConcurrencyLimit(limit int, handlers ...Handler) (Commands, error) {
 		return nil, MoreThanOneCatchAllErrorHandler
 	}
 
-	if limit < 1 {
+	c := &commands{handlers: handlers, cLimit: 1}
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.cLimit < 1 {
 		return nil, LimitLessThanOne
 	}
 
-	return &commands{handlers: handlers, cLimit: limit}, nil
+	c.invokers = make([]invoker, len(handlers))
+	registered := make(map[string]bool)
+	for i, h := range handlers {
+		c.invokers[i] = newInvoker(h, c.chain(h))
+		c.invokers[i].bounded = c.chainTimeout > 0
+
+		if !registered[h.EventType()] {
+			registered[h.EventType()] = true
+			c.subscriptions = append(c.subscriptions, h.EventType())
+		}
+	}
+
+	return c, nil
+}
+
+// Returns new Commands with Concurrency Limit equals to limit or error.
+// Concurrency Limit is amount of Events that can be processed concurrently per each handler.
+func NewWithConcurrencyLimit(limit int, handlers ...Handler) (Commands, error) {
+	return NewWithOptions([]Option{WithConcurrencyLimit(limit)}, handlers...)
 }
 
 // Returns new Commands with Concurrency Limit equals to 0 or error.
@@ -42,14 +71,28 @@ func New(handlers ...Handler) (Commands, error) {
 
 type commands struct {
 	handlers []Handler
-	cLimit   int
+	// handlers wrapped with middlewares
+	invokers []invoker
+	// unique event types and patterns handlers are subscribed to
+	subscriptions []string
+	cLimit        int
+	dlq           DeadLetterQueue
+	idempotency   *idempotency
+
+	maxDepth     int
+	maxEvents    int
+	detectCycles bool
+	chainTimeout time.Duration
+
+	observers []Observer
+
+	middlewares     []Middleware
+	typeMiddlewares []eventTypeMiddleware
 }
 
 func (c *commands) MarshalJSON() ([]byte, error) {
 	events := make([]string, 0, 1)
-	for _, h := range c.handlers {
-		events = append(events, h.EventType())
-	}
+	events = append(events, c.subscriptions...)
 
 	return json.Marshal(events)
 }
@@ -62,8 +105,8 @@ func (c *commands) HandleOnly(ctx context.Context, event Event, only ...string)
 	}
 
 	exists := false
-	for _, c := range only {
-		if exists = c == event.EventType(); exists {
+	for _, pattern := range only {
+		if exists = MatchEventType(pattern, event.EventType()); exists {
 			break
 		}
 	}
@@ -72,32 +115,98 @@ func (c *commands) HandleOnly(ctx context.Context, event Event, only ...string)
 		return Done(event)
 	}
 
-	h, ok := c.getHandle(event.EventType())
-	if !ok {
-		return NewErrEvent(event, &ErrCommandHandlerNotFound{event.EventType()})
+	invokers := c.getInvokers(event.EventType())
+	if len(invokers) == 0 {
+		return c.deadLetter(withMetadata,
+			NewErrEvent(event, &ErrCommandHandlerNotFound{event.EventType()}))
 	}
 
 	rw := newEventRW(ctx)
 
 	defer rw.Close()
 
-	h.Handle(ctx, rw.GetWriter(withMetadata.Metadata()), withMetadata)
+	for _, inv := range invokers {
+		ev := subscriberEvent(withMetadata, len(invokers))
+		inv.invoke(ctx, rw.GetWriter(ev.Metadata()), ev)
+	}
 
-	for {
+	// every subscriber has to finish writing,
+	// so none of their events or errors are lost
+	var (
+		first  Event
+		failed []Event
+	)
+
+	for pending := len(invokers); pending > 0; {
 		select {
 		case <-ctx.Done():
-			return NewErrEvent(event, ctx.Err())
+			return c.deadLetter(withMetadata, NewErrEvent(event, ctx.Err()))
 		case ev := <-rw.Read():
-			if event == doneWriting {
-				return Done(event)
+			switch {
+			case ev == doneWriting:
+				pending--
+			case ev.Err() != nil:
+				failed = append(failed, c.deadLetter(failedEvent(ev), ev))
+			case first == nil:
+				first = ev
 			}
+		}
+	}
 
-			return ev
+	switch {
+	case len(failed) == 1:
+		return failed[0]
+	case len(failed) > 1:
+		aggregated := NewErrAggregatedEvent(withMetadata)
+		for _, ev := range failed {
+			aggregated.Append(ev.Err())
 		}
+
+		return aggregated
+	case first != nil:
+		return first
 	}
+
+	return Done(event)
+}
+
+// sends event to dead letter queue if it is set and returns errEvent.
+func (c *commands) deadLetter(event EventWithMetadata, errEvent Event) Event {
+	if c.dlq == nil {
+		return errEvent
+	}
+
+	if err := c.dlq.Put(NewDeadLetter(event, errEvent.Err())); err != nil {
+		aggregated := NewErrAggregatedEvent(event)
+		aggregated.Append(errEvent.Err(), err)
+
+		return aggregated
+	}
+
+	return errEvent
 }
 
 func (c *commands) Handle(ctx context.Context, event Event) Event {
+	if c.idempotency == nil || IsDryRun(ctx) {
+		return c.handle(ctx, event)
+	}
+
+	key := IdempotencyKeyOf(event)
+	if key == "" {
+		return c.handle(ctx, event)
+	}
+
+	return c.idempotency.handle(ctx, event.EventType()+"#"+key, event, c.handle)
+}
+
+func (c *commands) handle(ctx context.Context, event Event) Event {
+	if c.chainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.chainTimeout)
+
+		defer cancel()
+	}
+
 	rw := newEventRW(ctx)
 
 	defer rw.Close()
@@ -109,6 +218,7 @@ func (c *commands) Handle(ctx context.Context, event Event) Event {
 	}
 
 	result := newResult(withMetadata)
+	result.dlq = c.dlq
 	c.startWorkers(ctx, rw, result)
 
 	if result.Err() != nil {
@@ -119,22 +229,22 @@ func (c *commands) Handle(ctx context.Context, event Event) Event {
 }
 
 func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *result) {
-	channels := make(map[string]chan EventWithMetadata)
-	for _, h := range c.handlers {
+	channels := make(map[string][]chan EventWithMetadata)
+	for i, h := range c.handlers {
 		events := make(chan EventWithMetadata)
-		channels[h.EventType()] = events
+		channels[h.EventType()] = append(channels[h.EventType()], events)
 
 		workers := h.Workers()
 		if workers == 0 {
 			workers = c.cLimit
 		}
 
-		for i := 0; i < workers; i++ {
-			go func(h Handler) {
+		for n := 0; n < workers; n++ {
+			go func(inv invoker) {
 				for event := range events {
-					h.Handle(ctx, rw.GetWriter(event.Metadata()), event)
+					inv.invoke(ctx, rw.GetWriter(event.Metadata()), event)
 				}
-			}(h)
+			}(c.invokers[i])
 		}
 	}
 
@@ -143,11 +253,34 @@ func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *res
 	go func() {
 		var wg sync.WaitGroup
 
+		chain := newChainTracker(c.maxDepth, c.maxEvents, c.detectCycles)
+
+		// every subscriber is counted separately
+		// and receives event with its own metadata
+		dispatch := func(event EventWithMetadata, subscribers []chan EventWithMetadata) {
+			for _, handle := range subscribers {
+				ev := subscriberEvent(event, len(subscribers))
+				chain.alias(ev, event)
+
+				wg.Add(1)
+				handle <- ev
+			}
+		}
+
 		event := result.event
-		handle, ok := channels[event.EventType()]
+		chain.track(event)
+
+		if err := c.observe(ctx, event); err != nil {
+			result.fail(event, err)
+			close(done)
+
+			return
+		}
+
+		handle, ok := channels[c.route(event.EventType())]
 
 		if !ok {
-			result.errors.Append(&ErrCommandHandlerNotFound{event.EventType()})
+			result.fail(event, &ErrCommandHandlerNotFound{event.EventType()})
 			close(done)
 
 			return
@@ -155,24 +288,25 @@ func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *res
 
 		select {
 		case <-ctx.Done():
-			result.errors.Append(ctx.Err())
+			result.fail(event, ctx.Err())
 			close(done)
 
 			return
 		default:
 		}
 
-		wg.Add(1)
-		handle <- event
+		dispatch(event, handle)
 
 		go func() {
 			wg.Wait()
 			close(done)
 		}()
 
+		interrupted := false
 		for event := range rw.Read() {
-			if err := ctx.Err(); err != nil {
-				result.errors.Append(err)
+			if err := ctx.Err(); err != nil && !interrupted {
+				interrupted = true
+				result.errors.Append(NewErrEvent(result.event, err))
 			}
 
 			if event == doneWriting {
@@ -181,7 +315,13 @@ func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *res
 				continue
 			}
 
+			// only command Events are left unhandled,
+			// *DoneEvents and error Events are results of handled ones
 			if err := ctx.Err(); err != nil {
+				if event != nil && AsDoneEvent(event) == nil && event.Err() == nil {
+					result.deadLetter(event, err)
+				}
+
 				continue
 			}
 
@@ -191,19 +331,35 @@ func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *res
 				continue
 			}
 
+			if err := chain.track(event); err != nil {
+				result.fail(event, NewErrEvent(event, err))
+
+				continue
+			}
+
+			if err := c.observe(ctx, event); err != nil {
+				result.fail(event, err)
+
+				continue
+			}
+
 			if done := AsDoneEvent(event); done != nil {
 				result.Append(done, event.Metadata())
 
+				if handle, ok := channels[c.route(event.EventType())]; ok {
+					dispatch(event, handle)
+				}
+
 				continue
 			}
 
-			handle, ok := channels[event.EventType()]
+			handle, ok := channels[c.route(event.EventType())]
 			err := event.Err()
 			isUnhandledEvent := !ok && err == nil
 			isUnhandledError := !ok && err != nil
 
 			if isUnhandledEvent {
-				result.errors.Append(&ErrCommandHandlerNotFound{event.EventType()})
+				result.fail(event, &ErrCommandHandlerNotFound{event.EventType()})
 
 				continue
 			}
@@ -211,27 +367,77 @@ func (c *commands) startWorkers(ctx context.Context, rw EventReader, result *res
 			if isUnhandledError {
 				handle, ok = channels[CatchAllErrorEventType]
 				if !ok {
-					result.errors.Append(err)
+					result.fail(failedEvent(event), err)
 
 					continue
 				}
 			}
 
-			wg.Add(1)
-			handle <- event
+			dispatch(event, handle)
 		}
 	}()
 
 	<-done
 }
 
-func (c *commands) getHandle(eventType string) (Handler, bool) {
-	for _, h := range c.handlers {
-		if h.EventType() == eventType {
-			return h, true
+// notifies observers about event.
+func (c *commands) observe(ctx context.Context, event EventWithMetadata) error {
+	for _, observer := range c.observers {
+		if err := observer(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// returns Event that caused error event or event itself.
+func failedEvent(event EventWithMetadata) EventWithMetadata {
+	var errEvent *ErrEvent
+	if !errors.As(event.Err(), &errEvent) {
+		return event
+	}
+
+	if withMetadata := AsEventWithMetadata(errEvent.Event()); withMetadata != nil {
+		return withMetadata
+	}
+
+	return WithMetadata(errEvent.Event(), event.Metadata())
+}
+
+// returns Event to pass to one of n subscribers of event.
+// If there are several subscribers each of them is caused by event.
+func subscriberEvent(event EventWithMetadata, n int) EventWithMetadata {
+	if n < 2 {
+		return event
+	}
+
+	return WithMetadata(event, event.Metadata().New(uuid.New().String()))
+}
+
+// returns event type or pattern eventType is routed to.
+// Returns empty string if no Handler is subscribed to eventType.
+func (c *commands) route(eventType string) string {
+	subscription, _ := match.Route(c.subscriptions, eventType)
+
+	return subscription
+}
+
+// returns invokers of all Handlers eventType is routed to.
+func (c *commands) getInvokers(eventType string) []invoker {
+	subscription, ok := match.Route(c.subscriptions, eventType)
+	if !ok {
+		return nil
+	}
+
+	invokers := make([]invoker, 0, 1)
+	for i, h := range c.handlers {
+		if h.EventType() == subscription {
+			invokers = append(invokers, c.invokers[i])
 		}
 	}
-	return nil, false
+
+	return invokers
 }
 
 func (c *commands) sealed() {}
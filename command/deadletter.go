@@ -0,0 +1,337 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+	"github.com/google/uuid"
+)
+
+// DeadLetter is an Event that Commands failed to process together with the cause of failure.
+type DeadLetter struct {
+	// Unique dead letter ID.
+	ID string `json:"id"`
+	// Failed Event. E.ID is ID of failed Event.
+	record.E `json:"event"`
+	// Messages of error chain from outermost to root cause.
+	Causes []string  `json:"causes"`
+	Time   time.Time `json:"time"`
+	// Original error. Is not persisted by file-backed DeadLetterQueue.
+	Cause error `json:"-"`
+}
+
+// Returns new DeadLetter for event failed with cause.
+func NewDeadLetter(event EventWithMetadata, cause error) DeadLetter {
+	letter := DeadLetter{
+		ID:    uuid.New().String(),
+		E:     record.New(event, event.Metadata()),
+		Time:  time.Now().UTC(),
+		Cause: cause}
+
+	for err := cause; err != nil; err = errors.Unwrap(err) {
+		letter.Causes = append(letter.Causes, err.Error())
+	}
+
+	return letter
+}
+
+// Returns failed Event with its original Metadata.
+func (dl DeadLetter) Event() EventWithMetadata {
+	return dl.E.Event()
+}
+
+// DeadLetterQueue stores Events that failed to be processed.
+type DeadLetterQueue interface {
+	// Stores dead letter.
+	Put(letter DeadLetter) error
+	// Returns all stored dead letters in order they were put.
+	List() ([]DeadLetter, error)
+	// Returns dead letter with id or *ErrDeadLetterNotFound.
+	Get(id string) (DeadLetter, error)
+	// Removes dead letter with id or returns *ErrDeadLetterNotFound.
+	Remove(id string) error
+}
+
+// Handles Event of dead letter with id with commands and removes dead letter from dlq once Event succeeds.
+// If Event fails again dead letter is kept and error of Event is returned.
+// Dead letter put again by commands configured with dlq replaces kept one, so Event is not stored twice.
+func Resubmit(ctx context.Context, dlq DeadLetterQueue, id string, commands Commands) (Event, error) {
+	letter, err := dlq.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	result := commands.Handle(ctx, letter.Event())
+	if result.Err() == nil {
+		return result, dlq.Remove(id)
+	}
+
+	if err := replaceDeadLetter(dlq, letter); err != nil {
+		return result, err
+	}
+
+	return result, result.Err()
+}
+
+// replaces letter with dead letters of the same Event put after it, keeping ID of letter.
+func replaceDeadLetter(dlq DeadLetterQueue, letter DeadLetter) error {
+	if letter.E.ID == "" {
+		return nil
+	}
+
+	letters, err := dlq.List()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range letters {
+		if l.ID == letter.ID || l.E.ID != letter.E.ID {
+			continue
+		}
+
+		if err := dlq.Remove(l.ID); err != nil {
+			return err
+		}
+
+		l.ID = letter.ID
+		if err := dlq.Put(l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Passes Event of dead letter with id to worker and removes dead letter from dlq
+// if worker accepted it.
+func ResubmitToWorker(dlq DeadLetterQueue, id string, worker CommandsWorker) error {
+	letter, err := dlq.Get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := worker.Handle(letter.Event()); err != nil {
+		return err
+	}
+
+	return dlq.Remove(id)
+}
+
+// Returns in-memory DeadLetterQueue.
+func NewInMemoryDeadLetterQueue() DeadLetterQueue {
+	return newMemoryDLQ()
+}
+
+func newMemoryDLQ() *memoryDLQ {
+	return &memoryDLQ{letters: make(map[string]DeadLetter)}
+}
+
+type memoryDLQ struct {
+	mu sync.RWMutex
+
+	letters map[string]DeadLetter
+	order   []string
+}
+
+func (q *memoryDLQ) Put(letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.letters[letter.ID]; !ok {
+		q.order = append(q.order, letter.ID)
+	}
+
+	q.letters[letter.ID] = letter
+
+	return nil
+}
+
+func (q *memoryDLQ) List() ([]DeadLetter, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	letters := make([]DeadLetter, 0, len(q.order))
+	for _, id := range q.order {
+		letters = append(letters, q.letters[id])
+	}
+
+	return letters, nil
+}
+
+func (q *memoryDLQ) Get(id string) (DeadLetter, error) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	letter, ok := q.letters[id]
+	if !ok {
+		return DeadLetter{}, &ErrDeadLetterNotFound{id}
+	}
+
+	return letter, nil
+}
+
+func (q *memoryDLQ) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.letters[id]; !ok {
+		return &ErrDeadLetterNotFound{id}
+	}
+
+	delete(q.letters, id)
+
+	for i, letterID := range q.order {
+		if letterID == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (q *memoryDLQ) len() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return len(q.order)
+}
+
+// Amount of records FileDeadLetterQueue keeps in underlying file on top of stored dead letters
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns DeadLetterQueue stored in append-only file at path.
+// Dead letters already stored in the file are loaded.
+// File is compacted automatically once it holds compactAfter records more than stored dead letters.
+func NewFileDeadLetterQueue(path string) (*FileDeadLetterQueue, error) {
+	log, err := filelog.Open(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &FileDeadLetterQueue{log: log, memory: newMemoryDLQ()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r dlqRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		q.appended++
+		if r.Letter != nil {
+			return q.memory.Put(*r.Letter)
+		}
+
+		q.memory.Remove(r.Removed)
+
+		return nil
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// *FileDeadLetterQueue implements DeadLetterQueue.
+type FileDeadLetterQueue struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryDLQ
+	// amount of records in underlying file
+	appended int
+}
+
+type dlqRecord struct {
+	Letter  *DeadLetter `json:"letter,omitempty"`
+	Removed string      `json:"removed,omitempty"`
+}
+
+func (q *FileDeadLetterQueue) Put(letter DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.log.Append(dlqRecord{Letter: &letter}); err != nil {
+		return err
+	}
+
+	if err := q.memory.Put(letter); err != nil {
+		return err
+	}
+
+	return q.compactIfNeeded()
+}
+
+func (q *FileDeadLetterQueue) List() ([]DeadLetter, error) {
+	return q.memory.List()
+}
+
+func (q *FileDeadLetterQueue) Get(id string) (DeadLetter, error) {
+	return q.memory.Get(id)
+}
+
+func (q *FileDeadLetterQueue) Remove(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, err := q.memory.Get(id); err != nil {
+		return err
+	}
+
+	if err := q.log.Append(dlqRecord{Removed: id}); err != nil {
+		return err
+	}
+
+	if err := q.memory.Remove(id); err != nil {
+		return err
+	}
+
+	return q.compactIfNeeded()
+}
+
+// Rewrites underlying file keeping only stored dead letters.
+func (q *FileDeadLetterQueue) Compact() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.compact()
+}
+
+// compacts underlying file once it holds compactAfter records more than stored dead letters.
+func (q *FileDeadLetterQueue) compactIfNeeded() error {
+	if q.appended++; q.appended < compactAfter+q.memory.len() {
+		return nil
+	}
+
+	return q.compact()
+}
+
+func (q *FileDeadLetterQueue) compact() error {
+	letters, _ := q.memory.List()
+	records := make([]interface{}, 0, len(letters))
+	for i := range letters {
+		records = append(records, dlqRecord{Letter: &letters[i]})
+	}
+
+	if err := q.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	q.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (q *FileDeadLetterQueue) Close() error {
+	return q.log.Close()
+}
@@ -0,0 +1,34 @@
+package command
+
+import (
+	"context"
+)
+
+type dryRunKey struct{}
+
+// Returns context in which Handlers with side effects are not invoked (see SideEffectHandler).
+// Use it to replay Events without sending emails, charging cards etc.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// Reports whether ctx was created with WithDryRun.
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+
+	return dryRun
+}
+
+// Returns Handler marked as having side effects.
+// It is skipped if Event is handled in dry-run (see WithDryRun).
+func WithSideEffects(h Handler) Handler {
+	return &sideEffectHandler{HandlerWrapper{h}}
+}
+
+type sideEffectHandler struct {
+	HandlerWrapper
+}
+
+func (sh *sideEffectHandler) HasSideEffects() bool {
+	return true
+}
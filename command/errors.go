@@ -6,7 +6,12 @@ import (
 	"sync"
 )
 
-const CatchAllErrorEventType string = "ERROR#*"
+const (
+	CatchAllErrorEventType string = "ERROR#*"
+	// Type of Event that *ErrEvent passed to CommandsWorker event sink is caused by
+	// if Queue fails to dequeue Event.
+	DequeueEventType string = "dequeue"
+)
 
 var (
 	LimitLessThanOne                = fmt.Errorf("concurrency limit should equal or more than 1")
@@ -153,6 +158,87 @@ func (err *ErrCommandHandlerNotFound) Error() string {
 	return fmt.Sprintf("handler not found for command %s", err.commandName)
 }
 
+// error type returned if Handler failed to handle Event after all retry attempts.
+type ErrRetriesExhausted struct {
+	Attempts int
+	Err      error
+}
+
+// Implementation of error.
+func (err *ErrRetriesExhausted) Error() string {
+	return fmt.Sprintf("gave up after %d attempts: %s", err.Attempts, err.Err)
+}
+
+// Returns underlying error.
+func (err *ErrRetriesExhausted) Unwrap() error {
+	return err.Err
+}
+
+// error type returned if dead letter was not found in DeadLetterQueue.
+type ErrDeadLetterNotFound struct {
+	id string
+}
+
+// Implementation of error.
+func (err *ErrDeadLetterNotFound) Error() string {
+	return fmt.Sprintf("dead letter %s not found", err.id)
+}
+
+// error type returned if Handler panicked while handling Event.
+type ErrHandlerPanicked struct {
+	// Value passed to panic.
+	Value interface{}
+	// Stack trace of panicked goroutine.
+	Stack []byte
+	// Event Handler was handling.
+	Event Event
+}
+
+// Implementation of error.
+func (err *ErrHandlerPanicked) Error() string {
+	return fmt.Sprintf("handler panicked while handling event %s: %v", err.Event.EventType(), err.Value)
+}
+
+// Returns value passed to panic if it is an error.
+func (err *ErrHandlerPanicked) Unwrap() error {
+	e, _ := err.Value.(error)
+
+	return e
+}
+
+// error type returned if causation chain of Event is longer than allowed.
+type ErrChainTooDeep struct {
+	Max int
+	// Event types from the root of the chain to the offending Event.
+	Path []string
+}
+
+// Implementation of error.
+func (err *ErrChainTooDeep) Error() string {
+	return fmt.Sprintf("event chain is deeper than %d: %s", err.Max, strings.Join(err.Path, " -> "))
+}
+
+// error type returned if Event type repeats in its own causation chain.
+type ErrEventCycle struct {
+	// Event types from the root of the chain to the offending Event.
+	Path []string
+}
+
+// Implementation of error.
+func (err *ErrEventCycle) Error() string {
+	return fmt.Sprintf("event cycle detected: %s", strings.Join(err.Path, " -> "))
+}
+
+// error type returned if single Commands.Handle call dispatched more Events than allowed.
+type ErrTooManyEvents struct {
+	Max int
+}
+
+// Implementation of error.
+func (err *ErrTooManyEvents) Error() string {
+	return fmt.Sprintf("more than %d events were dispatched", err.Max)
+}
+
 // ErrNilEvent instance.
 const NilEvent ErrNilEvent = "NilEvent"
 
@@ -128,6 +128,15 @@ func AsEventWithMetadata(event Event) EventWithMetadata {
 	return nil
 }
 
+// Returns Metadata of event or empty Metadata if it has none.
+func MetadataOf(event Event) tracing.Metadata {
+	if withMetadata := AsEventWithMetadata(event); withMetadata != nil && withMetadata.Metadata() != nil {
+		return withMetadata.Metadata()
+	}
+
+	return tracing.M{}
+}
+
 type eventWithMetadata struct {
 	event    Event
 	metadata tracing.Metadata
@@ -172,6 +181,24 @@ type result struct {
 	event   EventWithMetadata
 	results []json.RawMessage
 	errors  *ErrAggregatedEvent
+	dlq     DeadLetterQueue
+}
+
+// records err caused by event.
+func (r *result) fail(event EventWithMetadata, err error) {
+	r.errors.Append(err)
+	r.deadLetter(event, err)
+}
+
+// sends event to dead letter queue if it is set.
+func (r *result) deadLetter(event EventWithMetadata, err error) {
+	if r.dlq == nil || event == nil {
+		return
+	}
+
+	if err := r.dlq.Put(NewDeadLetter(event, err)); err != nil {
+		r.errors.Append(err)
+	}
 }
 
 func (r *result) Append(done *DoneEvent, metadata tracing.Metadata) {
@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"time"
 )
 
 // *BaseHandler implements Handler.
@@ -9,6 +10,12 @@ type BaseHandler struct {
 	Type       string
 	HandleFunc func(ctx context.Context, w EventWriter, e Event)
 	NWorkers   int
+	// Optional retry policy applied when HandleFunc writes an error Event.
+	Retry *RetryPolicy
+	// Optional limit of single Handle call including retries.
+	Timeout time.Duration
+	// Marks HandleFunc as having side effects, so it is skipped in dry-run.
+	SideEffects bool
 }
 
 // Returns EType.
@@ -17,7 +24,14 @@ func (ch *BaseHandler) EventType() string {
 }
 
 // Runs HandleFunc.
+// Runs HandleFunc again according to Retry if it is set.
 func (ch *BaseHandler) Handle(ctx context.Context, w EventWriter, event Event) {
+	if ch.Retry != nil {
+		handleWithRetry(ctx, ch.Retry, ch.HandleFunc, w, event)
+
+		return
+	}
+
 	ch.HandleFunc(ctx, w, event)
 }
 
@@ -25,6 +39,65 @@ func (ch *BaseHandler) Workers() int {
 	return ch.NWorkers
 }
 
+// Returns Timeout.
+func (ch *BaseHandler) HandleTimeout() time.Duration {
+	return ch.Timeout
+}
+
+// Returns SideEffects.
+func (ch *BaseHandler) HasSideEffects() bool {
+	return ch.SideEffects
+}
+
+// HandlerWrapper forwards HandleTimeout and HasSideEffects to wrapped Handler.
+// Embed it in Handler that wraps another one to keep its timeout and side effects.
+type HandlerWrapper struct {
+	Handler
+}
+
+// Returns timeout of wrapped Handler if it has one.
+func (hw HandlerWrapper) HandleTimeout() time.Duration {
+	return handleTimeout(hw.Handler)
+}
+
+// Reports whether wrapped Handler has side effects.
+func (hw HandlerWrapper) HasSideEffects() bool {
+	return hasSideEffects(hw.Handler)
+}
+
+// Returns Handler that limits single h.Handle call to timeout.
+func WithTimeout(h Handler, timeout time.Duration) Handler {
+	return &timeoutHandler{HandlerWrapper: HandlerWrapper{h}, timeout: timeout}
+}
+
+type timeoutHandler struct {
+	HandlerWrapper
+
+	timeout time.Duration
+}
+
+func (th *timeoutHandler) HandleTimeout() time.Duration {
+	return th.timeout
+}
+
+// returns timeout of h if it has one.
+func handleTimeout(h Handler) time.Duration {
+	if withTimeout, ok := h.(TimeoutHandler); ok {
+		return withTimeout.HandleTimeout()
+	}
+
+	return 0
+}
+
+// reports whether h has side effects.
+func hasSideEffects(h Handler) bool {
+	if withSideEffects, ok := h.(SideEffectHandler); ok {
+		return withSideEffects.HasSideEffects()
+	}
+
+	return false
+}
+
 // Returns Handler with EventType equals eventType.
 // and Handle based on handle.
 func HandlerFunc(eventType string, handle func(context.Context, []byte) error) Handler {
@@ -0,0 +1,327 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of puts after which IdempotencyStore removes expired processed events.
+// Stores holding more processed events wait for as many puts as they hold, so removal stays amortised.
+const purgeAfter = 100
+
+// ProcessedEvent is result of Event successfully handled by Commands with idempotency (see WithIdempotency).
+type ProcessedEvent struct {
+	// Event type and idempotency key of Event joined with "#" (see IdempotencyKeyOf).
+	Key       string `json:"key"`
+	EventType string `json:"type"`
+	// Payload of *DoneEvent returned for Event.
+	Result  []byte    `json:"result"`
+	Time    time.Time `json:"time"`
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// Returns *DoneEvent returned for processed Event.
+func (p ProcessedEvent) Done() *DoneEvent {
+	return Done(E{Type: p.EventType, P: p.Result})
+}
+
+// Reports whether p is expired at t.
+func (p ProcessedEvent) Expired(t time.Time) bool {
+	return !p.Expires.IsZero() && !t.Before(p.Expires)
+}
+
+// IdempotencyStore stores ProcessedEvents by their keys.
+type IdempotencyStore interface {
+	// Returns not expired ProcessedEvent with key and true or false if there is none.
+	Get(key string) (ProcessedEvent, bool, error)
+	// Stores processed event replacing one with the same Key.
+	Put(processed ProcessedEvent) error
+}
+
+// Returns event with idempotency key.
+// Empty key makes event not idempotent even if it has Metadata.
+func WithIdempotencyKey(event Event, key string) Event {
+	return &idempotentEvent{event: event, key: key}
+}
+
+// Returns idempotency key of event or ID of its Metadata if it has none.
+func IdempotencyKeyOf(event Event) string {
+	for e := event; e != nil; e = Unwrap(e) {
+		if idempotent, ok := e.(*idempotentEvent); ok {
+			return idempotent.key
+		}
+	}
+
+	if withMetadata := AsEventWithMetadata(event); withMetadata != nil && withMetadata.Metadata() != nil {
+		return withMetadata.Metadata().ID()
+	}
+
+	return ""
+}
+
+type idempotentEvent struct {
+	event Event
+	key   string
+}
+
+func (e *idempotentEvent) EventType() string {
+	return e.event.EventType()
+}
+
+func (e *idempotentEvent) Payload() []byte {
+	return e.event.Payload()
+}
+
+func (e *idempotentEvent) Err() error {
+	return e.event.Err()
+}
+
+func (e *idempotentEvent) Event() Event {
+	return e.event
+}
+
+// deduplicates Events handled by Commands.
+type idempotency struct {
+	store IdempotencyStore
+	ttl   time.Duration
+
+	mu sync.Mutex
+	// closed once Event with key is handled
+	inFlight map[string]chan struct{}
+}
+
+// returns stored result of Event with key or result of handle.
+// Event with the same key handled concurrently is waited for.
+// Result of handle is stored only if it is not an error.
+func (i *idempotency) handle(ctx context.Context, key string, event Event,
+	handle func(context.Context, Event) Event) Event {
+	for {
+		processed, ok, err := i.store.Get(key)
+		if err != nil {
+			return NewErrEvent(event, err)
+		}
+
+		if ok {
+			return processed.Done()
+		}
+
+		i.mu.Lock()
+		wait, busy := i.inFlight[key]
+		if !busy {
+			i.inFlight[key] = make(chan struct{})
+			i.mu.Unlock()
+
+			break
+		}
+
+		i.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return NewErrEvent(event, ctx.Err())
+		case <-wait:
+		}
+	}
+
+	defer func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+
+		close(i.inFlight[key])
+		delete(i.inFlight, key)
+	}()
+
+	result := handle(ctx, event)
+	if result.Err() != nil {
+		return result
+	}
+
+	now := time.Now().UTC()
+	processed := ProcessedEvent{Key: key, EventType: event.EventType(), Result: result.Payload(), Time: now}
+	if i.ttl > 0 {
+		processed.Expires = now.Add(i.ttl)
+	}
+
+	if err := i.store.Put(processed); err != nil {
+		aggregated := NewErrAggregatedEvent(event)
+		aggregated.Append(err)
+
+		return aggregated
+	}
+
+	return result
+}
+
+// Returns in-memory IdempotencyStore.
+func NewInMemoryIdempotencyStore() IdempotencyStore {
+	return newMemoryIdempotencyStore()
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{processed: make(map[string]ProcessedEvent)}
+}
+
+type memoryIdempotencyStore struct {
+	mu sync.Mutex
+
+	processed map[string]ProcessedEvent
+	// amount of puts since expired processed events were removed
+	puts int
+}
+
+func (s *memoryIdempotencyStore) Get(key string) (ProcessedEvent, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	processed, ok := s.processed[key]
+	if ok && processed.Expired(time.Now()) {
+		delete(s.processed, key)
+
+		return ProcessedEvent{}, false, nil
+	}
+
+	return processed, ok, nil
+}
+
+func (s *memoryIdempotencyStore) Put(processed ProcessedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.processed[processed.Key] = processed
+	if s.puts++; s.puts >= purgeAfter && s.puts >= len(s.processed) {
+		s.removeExpired(time.Now())
+	}
+
+	return nil
+}
+
+// returns not expired ProcessedEvents and removes expired ones.
+func (s *memoryIdempotencyStore) purge() []ProcessedEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired(time.Now())
+
+	processed := make([]ProcessedEvent, 0, len(s.processed))
+	for _, p := range s.processed {
+		processed = append(processed, p)
+	}
+
+	return processed
+}
+
+func (s *memoryIdempotencyStore) removeExpired(now time.Time) {
+	for key, p := range s.processed {
+		if p.Expired(now) {
+			delete(s.processed, key)
+		}
+	}
+
+	s.puts = 0
+}
+
+func (s *memoryIdempotencyStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.processed)
+}
+
+// Returns IdempotencyStore stored in append-only file at path.
+// Not expired processed events already stored in the file are loaded.
+// If sync is true every Put is flushed to disk before returning.
+// File is compacted automatically once it holds purgeAfter records more than not expired processed events.
+func NewFileIdempotencyStore(path string, sync bool) (*FileIdempotencyStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileIdempotencyStore{log: log, memory: newMemoryIdempotencyStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var processed ProcessedEvent
+		if err := json.Unmarshal(b, &processed); err != nil {
+			return err
+		}
+
+		s.appended++
+
+		return s.memory.Put(processed)
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	s.memory.purge()
+
+	return s, nil
+}
+
+// *FileIdempotencyStore implements IdempotencyStore.
+type FileIdempotencyStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryIdempotencyStore
+	// amount of processed events appended since file was compacted
+	appended int
+}
+
+func (s *FileIdempotencyStore) Get(key string) (ProcessedEvent, bool, error) {
+	return s.memory.Get(key)
+}
+
+func (s *FileIdempotencyStore) Put(processed ProcessedEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(processed); err != nil {
+		return err
+	}
+
+	if err := s.memory.Put(processed); err != nil {
+		return err
+	}
+
+	if s.appended++; s.appended < purgeAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+// Rewrites underlying file keeping only not expired processed events.
+func (s *FileIdempotencyStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+func (s *FileIdempotencyStore) compact() error {
+	processed := s.memory.purge()
+	records := make([]interface{}, 0, len(processed))
+	for _, p := range processed {
+		records = append(records, p)
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileIdempotencyStore) Close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,156 @@
+package command
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// invokes Handler wrapped with middlewares.
+type invoker struct {
+	handler Handler
+	// limits single Handle call if greater than zero.
+	timeout time.Duration
+	// Handler is skipped in dry-run.
+	sideEffects bool
+	// Handler is abandoned once context is done (see WithChainTimeout).
+	bounded bool
+}
+
+func newInvoker(h Handler, chain Handler) invoker {
+	return invoker{handler: chain, timeout: handleTimeout(h), sideEffects: hasSideEffects(h)}
+}
+
+// handles event with Handler.
+// Recovers from Handler panic and writes *ErrEvent caused by *ErrHandlerPanicked instead.
+// If Handler did not call Done before timeout
+// writes *ErrEvent caused by context.DeadlineExceeded and ignores everything Handler writes afterwards.
+// Bounded invoker does the same once ctx is done.
+// Handler with side effects is not called in dry-run.
+func (inv invoker) invoke(ctx context.Context, w EventWriter, event EventWithMetadata) {
+	if inv.sideEffects && IsDryRun(ctx) {
+		w.Done()
+
+		return
+	}
+
+	iw := &invocationWriter{w: w, handling: true, done: make(chan struct{})}
+	if inv.timeout <= 0 && !inv.bounded {
+		inv.handle(ctx, iw, event)
+
+		return
+	}
+
+	if inv.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, inv.timeout)
+
+		defer cancel()
+	}
+
+	iw.deadline, iw.event = ctx, event
+
+	go inv.handle(ctx, iw, event)
+
+	select {
+	case <-iw.done:
+	case <-ctx.Done():
+		iw.abort(NewErrEvent(event, ctx.Err()))
+	}
+}
+
+func (inv invoker) handle(ctx context.Context, iw *invocationWriter, event EventWithMetadata) {
+	defer func() {
+		if r := recover(); r != nil {
+			iw.abort(NewErrEvent(event, &ErrHandlerPanicked{Value: r, Stack: debug.Stack(), Event: event}))
+
+			return
+		}
+
+		iw.returned()
+	}()
+
+	inv.handler.Handle(ctx, iw, event)
+}
+
+// postpones Done until Handler.Handle returns
+// so it is possible to write error Event if Handler panicked or timed out.
+type invocationWriter struct {
+	mu sync.Mutex
+
+	// context of invocation limited by timeout
+	deadline context.Context
+	event    EventWithMetadata
+
+	w          EventWriter
+	handling   bool
+	doneCalled bool
+	closed     bool
+	done       chan struct{}
+}
+
+func (iw *invocationWriter) Write(e Event) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	if iw.closed {
+		return
+	}
+
+	iw.w.Write(e)
+}
+
+func (iw *invocationWriter) Done() {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	if iw.closed {
+		return
+	}
+
+	iw.doneCalled = true
+	if !iw.handling {
+		iw.close()
+	}
+}
+
+func (iw *invocationWriter) returned() {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	iw.handling = false
+	if iw.doneCalled && !iw.closed {
+		iw.close()
+	}
+}
+
+// writes errEvent and closes writer ignoring everything Handler writes afterwards.
+func (iw *invocationWriter) abort(errEvent Event) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	iw.handling = false
+	if iw.closed {
+		return
+	}
+
+	iw.w.Write(errEvent)
+	iw.finish()
+}
+
+// closes writer after Handler finished.
+// Handler that finished after deadline is considered timed out.
+func (iw *invocationWriter) close() {
+	if iw.deadline != nil && iw.deadline.Err() == context.DeadlineExceeded {
+		iw.w.Write(NewErrEvent(iw.event, iw.deadline.Err()))
+	}
+
+	iw.finish()
+}
+
+func (iw *invocationWriter) finish() {
+	iw.closed = true
+	iw.w.Done()
+	close(iw.done)
+}
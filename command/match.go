@@ -0,0 +1,13 @@
+package command
+
+import (
+	"github.com/andriiyaremenko/tinycqs/internal/match"
+)
+
+// Reports whether eventType matches pattern.
+// "*" in pattern matches any sequence of characters, every other character matches itself.
+// Patterns of error ("ERROR#...") and done ("DONE#...") event types match only
+// error and done event types respectively, other patterns never match them.
+func MatchEventType(pattern, eventType string) bool {
+	return match.EventType(pattern, eventType)
+}
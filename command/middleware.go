@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"strings"
+)
+
+// Middleware wraps Handler to add behaviour around its Handle.
+// Middleware should call next.Handle or w.Done.
+type Middleware func(next Handler) Handler
+
+// Returns Middleware calling handle instead of next.Handle.
+func MiddlewareFunc(handle func(ctx context.Context, w EventWriter, event Event, next Handler)) Middleware {
+	return func(next Handler) Handler {
+		return &middlewareHandler{Handler: next, handle: handle}
+	}
+}
+
+type middlewareHandler struct {
+	Handler
+
+	handle func(ctx context.Context, w EventWriter, event Event, next Handler)
+}
+
+func (mh *middlewareHandler) Handle(ctx context.Context, w EventWriter, event Event) {
+	mh.handle(ctx, w, event, mh.Handler)
+}
+
+type eventTypeMiddleware struct {
+	eventType   string
+	middlewares []Middleware
+}
+
+// wraps h with per event type middlewares and then with global middlewares.
+// First registered middleware is the outermost one.
+func (c *commands) chain(h Handler) Handler {
+	isPattern := strings.Contains(h.EventType(), "*")
+	for i := len(c.typeMiddlewares) - 1; i >= 0; i-- {
+		eventType, middlewares := c.typeMiddlewares[i].eventType, c.typeMiddlewares[i].middlewares
+
+		switch {
+		case eventType == h.EventType() || !isPattern && MatchEventType(eventType, h.EventType()):
+			h = wrap(h, middlewares)
+		case isPattern:
+			// handler subscribed to pattern receives events of different types,
+			// so middlewares are applied only to events matching eventType
+			h = &eventTypeHandler{Handler: h, eventType: eventType, wrapped: wrap(h, middlewares)}
+		}
+	}
+
+	return wrap(h, c.middlewares)
+}
+
+// Handler applying middlewares only to events matching eventType.
+type eventTypeHandler struct {
+	Handler
+
+	eventType string
+	wrapped   Handler
+}
+
+func (th *eventTypeHandler) Handle(ctx context.Context, w EventWriter, event Event) {
+	if MatchEventType(th.eventType, event.EventType()) {
+		th.wrapped.Handle(ctx, w, event)
+
+		return
+	}
+
+	th.Handler.Handle(ctx, w, event)
+}
+
+func wrap(h Handler, middlewares []Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
@@ -0,0 +1,96 @@
+package command
+
+import (
+	"time"
+)
+
+// Option configures Commands created by NewWithOptions.
+type Option func(*commands)
+
+// Sets Concurrency Limit of Commands.
+// Concurrency Limit is amount of Events that can be processed concurrently per each handler.
+func WithConcurrencyLimit(limit int) Option {
+	return func(c *commands) {
+		c.cLimit = limit
+	}
+}
+
+// Sends every Event that failed to be processed to dlq.
+func WithDeadLetterQueue(dlq DeadLetterQueue) Option {
+	return func(c *commands) {
+		c.dlq = dlq
+	}
+}
+
+// Makes Commands.Handle idempotent: result of Event successfully handled is stored in store for ttl
+// and returned for every next Event of the same event type with the same idempotency key (see IdempotencyKeyOf)
+// instead of handling it.
+// Event with the same key handled concurrently is waited for. Events are never expired if ttl is 0.
+// Idempotency is not applied in dry-run.
+func WithIdempotency(store IdempotencyStore, ttl time.Duration) Option {
+	return func(c *commands) {
+		c.idempotency = &idempotency{store: store, ttl: ttl, inFlight: make(map[string]chan struct{})}
+	}
+}
+
+// Wraps every Handler of Commands with middlewares.
+// Global middlewares wrap per event type middlewares.
+// First registered middleware is the outermost one.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *commands) {
+		c.middlewares = append(c.middlewares, middlewares...)
+	}
+}
+
+// Wraps Handlers of eventType with middlewares.
+// eventType can be a pattern (see MatchEventType).
+// Handlers subscribed to a pattern are wrapped only for Events matching eventType.
+// First registered middleware is the outermost one.
+func WithEventTypeMiddleware(eventType string, middlewares ...Middleware) Option {
+	return func(c *commands) {
+		c.typeMiddlewares = append(c.typeMiddlewares, eventTypeMiddleware{eventType, middlewares})
+	}
+}
+
+// Limits causation chain of Events dispatched by Commands.Handle to depth Events.
+// Events exceeding the limit fail with *ErrChainTooDeep.
+func WithMaxChainDepth(depth int) Option {
+	return func(c *commands) {
+		c.maxDepth = depth
+	}
+}
+
+// Limits total amount of Events dispatched by single Commands.Handle call.
+// Events exceeding the limit fail with *ErrTooManyEvents.
+// *DoneEvents and error Events are results of handled Events and are not counted.
+func WithMaxEvents(n int) Option {
+	return func(c *commands) {
+		c.maxEvents = n
+	}
+}
+
+// Fails Events whose type already occurred in their causation chain with *ErrEventCycle.
+func WithCycleDetection() Option {
+	return func(c *commands) {
+		c.detectCycles = true
+	}
+}
+
+// Limits time of every Commands.Handle call including all chained Events to timeout.
+// Handlers still running at the deadline are abandoned as if they timed out (see WithTimeout)
+// and Commands.Handle returns error caused by context.DeadlineExceeded.
+func WithChainTimeout(timeout time.Duration) Option {
+	return func(c *commands) {
+		c.chainTimeout = timeout
+	}
+}
+
+// Notifies observers about every Event dispatched by Commands.Handle before it is handled.
+// Observers are called one by one in order they were registered.
+// If observer returns error Event fails with it and is not handled.
+// Events handled by Commands.HandleOnly are not observed.
+func WithObserver(observers ...Observer) Option {
+	return func(c *commands) {
+		c.observers = append(c.observers, observers...)
+	}
+}
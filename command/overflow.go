@@ -0,0 +1,242 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// Returned if CommandsWorker can not accept Event without waiting.
+	ErrWorkerBusy = errors.New("command worker is busy")
+	// Cause of *ErrEvent passed to CommandsWorker event sink for Events dropped by OverflowDropOldest.
+	ErrEventDropped = errors.New("event dropped by command worker")
+)
+
+// OverflowPolicy decides what CommandsWorker.Handle does when worker queue is full.
+type OverflowPolicy int
+
+const (
+	// Handle waits until there is room in queue.
+	OverflowBlock OverflowPolicy = iota
+	// Handle drops accepted Event and returns ErrWorkerBusy.
+	OverflowDropNewest
+	// Handle drops the oldest queued Event to make room for accepted Event.
+	// Dropped Event is passed to event sink as *ErrEvent caused by ErrEventDropped.
+	// If room is taken by concurrent Handle calls every time, Handle gives up and returns ErrWorkerBusy.
+	OverflowDropOldest
+	// Handle stores accepted Event in spill Queue (see WithSpillQueue).
+	// Spilled Events are moved back to worker queue in order as soon as there is room.
+	// Behaves as OverflowBlock if spill Queue is not set.
+	OverflowSpill
+)
+
+// Sets policy applied by CommandsWorker.Handle when worker queue is full.
+// Defaults to OverflowBlock.
+func WithOverflowPolicy(policy OverflowPolicy) WorkerOption {
+	return func(w *worker) {
+		w.overflow = policy
+	}
+}
+
+// Stores Events that do not fit into worker queue in queue (see OverflowSpill).
+// Sets overflow policy to OverflowSpill.
+// Events left in queue on Shutdown are reported persisted only if queue is durable (see DurableQueue).
+func WithSpillQueue(queue Queue) WorkerOption {
+	return func(w *worker) {
+		w.overflow = OverflowSpill
+		w.spillQueue = queue
+	}
+}
+
+// puts event to worker queue according to overflow policy.
+// If wait is false returns ErrWorkerBusy instead of applying policy when worker queue is full.
+// Event of event type with limit (see WithEventTypeConcurrency) is handled as if worker queue is full
+// once depth Events of its event type are accepted but not started,
+// OverflowDropOldest drops the oldest of them that waits for its turn if there is one.
+// Returns Events dropped to make room for event.
+// Waiting for room does not prevent worker from stopping, WorkerStopped or ctx error is returned instead.
+func (w *worker) submit(ctx context.Context, event Event, wait bool) ([]Event, error) {
+	w.rwMu.RLock()
+	if !w.started {
+		w.rwMu.RUnlock()
+
+		return nil, WorkerStopped
+	}
+
+	w.submitting.Add(1)
+	w.rwMu.RUnlock()
+
+	defer w.submitting.Done()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defer w.arrive()
+
+	spilling := w.overflow == OverflowSpill && w.spillQueue != nil
+
+	var dropped []Event
+	for {
+		admitted, changed := w.pool.admit(event)
+		if admitted {
+			break
+		}
+
+		switch {
+		case !wait || w.overflow == OverflowDropNewest:
+			return dropped, ErrWorkerBusy
+		case spilling:
+			return dropped, w.spill(event)
+		case w.overflow == OverflowDropOldest:
+			oldest, ok := w.pool.dropWaiting(event)
+			if !ok {
+				return dropped, ErrWorkerBusy
+			}
+
+			dropped = append(dropped, oldest)
+
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return dropped, ctx.Err()
+		case <-w.ctx.Done():
+			return dropped, WorkerStopped
+		case <-w.stop:
+			return dropped, WorkerStopped
+		}
+	}
+
+	queued, more, err := w.enqueue(ctx, event, wait, spilling)
+	if !queued {
+		w.pool.release(event)
+	}
+
+	return append(dropped, more...), err
+}
+
+// puts admitted event to its lane according to overflow policy.
+// Reports whether event was put to lane.
+func (w *worker) enqueue(ctx context.Context, event Event, wait, spilling bool) (bool, []Event, error) {
+	l := w.laneOf(event)
+	if !spilling || atomic.LoadInt64(&w.spilled) == 0 {
+		select {
+		case l.events <- event:
+			return true, nil, nil
+		default:
+		}
+	}
+
+	if !wait {
+		return false, nil, ErrWorkerBusy
+	}
+
+	switch {
+	case spilling:
+		return false, nil, w.spill(event)
+	case w.overflow == OverflowDropNewest:
+		return false, nil, ErrWorkerBusy
+	case w.overflow == OverflowDropOldest:
+		// room made by dropping can be taken by concurrent Handle calls,
+		// so attempts are bounded instead of spinning while lane is refilled
+		var dropped []Event
+		for attempts := cap(l.events) + 1; attempts > 0; attempts-- {
+			select {
+			case l.events <- event:
+				return true, dropped, nil
+			default:
+			}
+
+			select {
+			case oldest := <-l.events:
+				w.pool.release(oldest)
+				dropped = append(dropped, oldest)
+			default:
+			}
+		}
+
+		return false, dropped, ErrWorkerBusy
+	default:
+		select {
+		case l.events <- event:
+			return true, nil, nil
+		case <-ctx.Done():
+			return false, nil, ctx.Err()
+		case <-w.ctx.Done():
+			return false, nil, WorkerStopped
+		case <-w.stop:
+			return false, nil, WorkerStopped
+		}
+	}
+}
+
+// stores event in spill Queue.
+func (w *worker) spill(event Event) error {
+	atomic.AddInt64(&w.spilled, 1)
+	if err := w.spillQueue.Enqueue(ensureMetadata(event)); err != nil {
+		atomic.AddInt64(&w.spilled, -1)
+
+		return err
+	}
+
+	return nil
+}
+
+// moves spilled Events back to worker queue until worker is stopped.
+func (w *worker) moveSpilled() {
+	defer close(w.moverDone)
+
+	ctx, cancel := context.WithCancel(w.ctx)
+
+	defer cancel()
+
+	go func() {
+		select {
+		case <-w.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		offset, event, err := w.spillQueue.Dequeue(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.eventSink(w, NewErrEvent(E{Type: DequeueEventType}, err))
+			}
+
+			return
+		}
+
+		for {
+			admitted, changed := w.pool.admit(event)
+			if admitted {
+				break
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case w.laneOf(event).events <- event:
+		case <-ctx.Done():
+			w.pool.release(event)
+
+			return
+		}
+
+		w.arrive()
+		atomic.AddInt64(&w.spilled, -1)
+		if err := w.spillQueue.Ack(offset); err != nil {
+			w.eventSink(w, NewErrEvent(event, err))
+		}
+	}
+}
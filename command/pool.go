@@ -0,0 +1,238 @@
+package command
+
+import (
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/match"
+)
+
+// Limits amount of chains started by Events of event type (or pattern, see MatchEventType) to n.
+// Events exceeding the limit wait for their turn without blocking Events of other types.
+// If Event matches several patterns the most precise one is applied.
+func WithEventTypeConcurrency(eventType string, n int) WorkerOption {
+	return func(w *worker) {
+		if w.pool.caps == nil {
+			w.pool.caps = make(map[string]int)
+		}
+
+		w.pool.caps[eventType] = n
+		w.pool.patterns = append(w.pool.patterns, eventType)
+	}
+}
+
+// Limits amount of chains handled by CommandsWorker concurrently to n.
+// Accepted Events wait in worker queue once the limit is reached.
+// 0 means no limit.
+func WithMaxConcurrentChains(n int) WorkerOption {
+	return func(w *worker) {
+		w.pool.maxChains = n
+	}
+}
+
+// Sets amount of accepted Events that can wait in worker queue to depth.
+// Events of event type with limit (see WithEventTypeConcurrency) are limited by depth per event type,
+// so Events waiting for their turn do not block Events of other types.
+// Overrides limit passed to NewWorkerWithOptions.
+func WithQueueDepth(depth int) WorkerOption {
+	return func(w *worker) {
+		w.cLimit = depth
+	}
+}
+
+// schedules chains respecting global and per event type limits.
+type pool struct {
+	mu sync.Mutex
+
+	maxChains int
+	depth     int
+	// limits of chains per event type or pattern
+	caps     map[string]int
+	patterns []string
+
+	running       int
+	runningByType map[string]int
+	// amount of accepted but not started Events per event type or pattern with limit
+	queued map[string]int
+	// Events waiting for their event type limit in order they were accepted
+	waiting []Event
+	stopped bool
+	// closed and replaced every time chain is finished or Event of event type with limit is started
+	changed chan struct{}
+
+	// starts chain of Event
+	run func(Event)
+}
+
+func (p *pool) init(depth int, run func(Event)) {
+	p.depth = depth
+	p.run = run
+	p.runningByType = make(map[string]int)
+	p.queued = make(map[string]int)
+	p.changed = make(chan struct{})
+}
+
+// reports whether pool can accept Event.
+// Returns channel closed once it is worth to ask again otherwise.
+func (p *pool) ready() (bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.maxChains < 1 || p.running < p.maxChains {
+		return true, nil
+	}
+
+	return false, p.changed
+}
+
+// takes place of event in worker queue if its event type limit is set.
+// Returns false and channel closed once it is worth to try again
+// if depth Events of its event type are already accepted but not started.
+func (p *pool) admit(event Event) (bool, <-chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pattern, ok := p.capOf(event)
+	if !ok {
+		return true, nil
+	}
+
+	if p.queued[pattern] >= p.depth {
+		return false, p.changed
+	}
+
+	p.queued[pattern]++
+
+	return true, nil
+}
+
+// releases place of admitted event that was not put to worker queue.
+func (p *pool) release(event Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pattern, ok := p.capOf(event); ok {
+		p.queued[pattern]--
+		p.notify()
+	}
+}
+
+// removes the oldest waiting Event of the same event type limit as event and releases its place.
+func (p *pool) dropWaiting(event Event) (Event, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pattern, ok := p.capOf(event)
+	if !ok {
+		return nil, false
+	}
+
+	for i, e := range p.waiting {
+		if other, _ := p.capOf(e); other != pattern {
+			continue
+		}
+
+		p.waiting = append(p.waiting[:i], p.waiting[i+1:]...)
+		p.queued[pattern]--
+
+		return e, true
+	}
+
+	return nil, false
+}
+
+// starts chain of event or puts it to waiting list if its event type limit is reached.
+func (p *pool) schedule(event Event) {
+	p.mu.Lock()
+
+	if !p.canStart(event) {
+		p.waiting = append(p.waiting, event)
+		p.mu.Unlock()
+
+		return
+	}
+
+	p.started(event)
+	p.mu.Unlock()
+
+	p.run(event)
+}
+
+// releases limits taken by chain of event and starts waiting Events that fit into limits.
+func (p *pool) finish(event Event) {
+	p.mu.Lock()
+
+	p.running--
+	if pattern, ok := p.capOf(event); ok {
+		p.runningByType[pattern]--
+	}
+
+	var next []Event
+	if !p.stopped {
+		waiting := p.waiting[:0]
+		for _, e := range p.waiting {
+			if p.canStart(e) {
+				p.started(e)
+				next = append(next, e)
+
+				continue
+			}
+
+			waiting = append(waiting, e)
+		}
+
+		p.waiting = waiting
+	}
+
+	p.notify()
+	p.mu.Unlock()
+
+	for _, e := range next {
+		p.run(e)
+	}
+}
+
+// stops starting chains and returns waiting Events.
+func (p *pool) stop() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	waiting := p.waiting
+	p.waiting = nil
+
+	return waiting
+}
+
+func (p *pool) canStart(event Event) bool {
+	if p.maxChains > 0 && p.running >= p.maxChains {
+		return false
+	}
+
+	pattern, ok := p.capOf(event)
+
+	return !ok || p.runningByType[pattern] < p.caps[pattern]
+}
+
+func (p *pool) started(event Event) {
+	p.running++
+	if pattern, ok := p.capOf(event); ok {
+		p.runningByType[pattern]++
+		p.queued[pattern]--
+		p.notify()
+	}
+}
+
+// wakes up those waiting for changed.
+func (p *pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// returns event type or pattern limiting event.
+func (p *pool) capOf(event Event) (string, bool) {
+	if len(p.patterns) == 0 {
+		return "", false
+	}
+
+	return match.Route(p.patterns, event.EventType())
+}
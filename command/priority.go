@@ -0,0 +1,185 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Priority of Event handled by CommandsWorker (see WithPriorityLanes).
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	// Priority of Events without priority.
+	PriorityNormal
+	PriorityHigh
+)
+
+// Returns name of priority or its number if it has no name.
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	default:
+		return strconv.Itoa(int(p))
+	}
+}
+
+// Returns Priority named s ("low", "normal" or "high") or represented by integer s.
+func ParsePriority(s string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return PriorityLow, nil
+	case "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	}
+
+	p, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return PriorityNormal, fmt.Errorf("unknown priority %q", s)
+	}
+
+	return Priority(p), nil
+}
+
+// Returns event with priority.
+func WithPriority(event Event, priority Priority) Event {
+	return &prioritizedEvent{event: event, priority: priority}
+}
+
+// Returns priority of event or PriorityNormal if it has none.
+func PriorityOf(event Event) Priority {
+	if prioritized, ok := event.(*prioritizedEvent); ok {
+		return prioritized.priority
+	}
+
+	if e := Unwrap(event); e != nil {
+		return PriorityOf(e)
+	}
+
+	return PriorityNormal
+}
+
+type prioritizedEvent struct {
+	event    Event
+	priority Priority
+}
+
+func (e *prioritizedEvent) EventType() string {
+	return e.event.EventType()
+}
+
+func (e *prioritizedEvent) Payload() []byte {
+	return e.event.Payload()
+}
+
+func (e *prioritizedEvent) Err() error {
+	return e.event.Err()
+}
+
+func (e *prioritizedEvent) Event() Event {
+	return e.event
+}
+
+// Splits worker queue into lanes of priorities with weights.
+// Lanes are served with weighted fair scheduling:
+// lane with weight 3 is served three times as often as lane with weight 1 while both have Events.
+// Event goes to lane of the highest priority not greater than its own or to the lowest lane.
+// Every lane holds up to worker queue depth Events.
+// Without lanes all Events share single queue regardless of their priority.
+func WithPriorityLanes(weights map[Priority]int) WorkerOption {
+	return func(w *worker) {
+		w.laneWeights = weights
+	}
+}
+
+// queue of Events of single priority.
+type lane struct {
+	priority Priority
+	weight   int
+	// weighted round-robin counter
+	current int
+	events  chan Event
+}
+
+// creates lanes sorted by priority in descending order.
+func newLanes(weights map[Priority]int, depth int) []*lane {
+	if len(weights) == 0 {
+		weights = map[Priority]int{PriorityNormal: 1}
+	}
+
+	lanes := make([]*lane, 0, len(weights))
+	for priority, weight := range weights {
+		if weight < 1 {
+			weight = 1
+		}
+
+		lanes = append(lanes, &lane{priority: priority, weight: weight, events: make(chan Event, depth)})
+	}
+
+	sort.Slice(lanes, func(i, j int) bool { return lanes[i].priority > lanes[j].priority })
+
+	return lanes
+}
+
+// returns lane of event.
+func (w *worker) laneOf(event Event) *lane {
+	priority := PriorityOf(event)
+	for _, l := range w.lanes {
+		if l.priority <= priority {
+			return l
+		}
+	}
+
+	return w.lanes[len(w.lanes)-1]
+}
+
+// returns next Event chosen with smooth weighted round-robin amongst lanes with Events.
+// Returns false if all lanes are empty.
+func (w *worker) next() (Event, bool) {
+	for {
+		var best *lane
+
+		total := 0
+		for _, l := range w.lanes {
+			if len(l.events) == 0 {
+				continue
+			}
+
+			l.current += l.weight
+			total += l.weight
+
+			if best == nil || l.current > best.current {
+				best = l
+			}
+		}
+
+		if best == nil {
+			return nil, false
+		}
+
+		best.current -= total
+
+		select {
+		case event := <-best.events:
+			return event, true
+		default:
+		}
+	}
+}
+
+// signals worker loop that Event was put to one of lanes.
+func (w *worker) arrive() {
+	select {
+	case w.arrived <- struct{}{}:
+	default:
+	}
+}
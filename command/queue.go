@@ -0,0 +1,326 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/google/uuid"
+)
+
+// Queue keeps Events accepted by CommandsWorker until they are handled.
+// Durable Queue keeps not acknowledged Events across restarts.
+type Queue interface {
+	// Appends event to the end of Queue.
+	Enqueue(event EventWithMetadata) error
+	// Returns next Event in order it was enqueued with its offset.
+	// Blocks until there is one or ctx is done.
+	// Not acknowledged Events are dequeued again after restart.
+	Dequeue(ctx context.Context) (int64, EventWithMetadata, error)
+	// Acknowledges that Event with offset was handled.
+	Ack(offset int64) error
+}
+
+// Queue that declares whether it keeps Events across restarts.
+// Queue that does not implement DurableQueue is considered durable.
+type DurableQueue interface {
+	Queue
+	// Reports whether Queue keeps Events across restarts.
+	IsDurable() bool
+}
+
+// Queue that reports amount of Events waiting to be dequeued.
+// Durable worker reports them as pending on Shutdown.
+type CountingQueue interface {
+	Queue
+	// Returns amount of Events not dequeued yet.
+	Waiting() int
+}
+
+// Returns Queue that keeps Events in memory.
+// Queue is not durable.
+func NewInMemoryQueue() Queue {
+	return &memoryQueue{notify: make(chan struct{})}
+}
+
+type memoryQueue struct {
+	mu sync.Mutex
+
+	next   int64
+	events []queuedEvent
+	// closed and replaced on every Enqueue to wake up Dequeue
+	notify chan struct{}
+}
+
+type queuedEvent struct {
+	offset int64
+	event  EventWithMetadata
+}
+
+func (q *memoryQueue) Enqueue(event EventWithMetadata) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.next++
+	q.events = append(q.events, queuedEvent{offset: q.next, event: event})
+
+	close(q.notify)
+	q.notify = make(chan struct{})
+
+	return nil
+}
+
+func (q *memoryQueue) Dequeue(ctx context.Context) (int64, EventWithMetadata, error) {
+	for {
+		q.mu.Lock()
+		if len(q.events) > 0 {
+			e := q.events[0]
+			q.events = q.events[1:]
+			q.mu.Unlock()
+
+			return e.offset, e.event, nil
+		}
+
+		notify := q.notify
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-notify:
+		}
+	}
+}
+
+func (q *memoryQueue) Ack(offset int64) error {
+	return nil
+}
+
+func (q *memoryQueue) IsDurable() bool {
+	return false
+}
+
+func (q *memoryQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.events)
+}
+
+// reports whether queue keeps Events across restarts.
+func isDurable(queue Queue) bool {
+	if durable, ok := queue.(DurableQueue); ok {
+		return durable.IsDurable()
+	}
+
+	return true
+}
+
+// returns amount of Events waiting in queue if it reports it.
+func waitingIn(queue Queue) int {
+	if counting, ok := queue.(CountingQueue); ok {
+		return counting.Waiting()
+	}
+
+	return 0
+}
+
+// Returns CommandsWorker based on Commands that keeps accepted Events in queue until they are handled.
+// Events are dequeued in order they were accepted and handled by up to limit concurrent chains.
+// Event is acknowledged once Commands.Handle returns, unless its chain was cancelled by then,
+// so Events interrupted by worker stop are handled again after restart.
+// Worker stops if queue fails to dequeue Event (see DequeueEventType).
+// eventSink is used to channel all unhandled errors in form of Event.
+func NewDurableWorker(ctx context.Context, eventSink func(CommandsWorker, Event), commands Commands, queue Queue, limit int) CommandsWorker {
+	if limit < 1 {
+		limit = 1
+	}
+
+	loopCtx, stop := context.WithCancel(ctx)
+	chainCtx, cancelChains := context.WithCancel(ctx)
+	w := &durableWorker{
+		loopCtx:      loopCtx,
+		stop:         stop,
+		stopped:      make(chan struct{}),
+		chainCtx:     chainCtx,
+		cancelChains: cancelChains,
+		started:      true,
+		eventSink:    eventSink,
+		commands:     commands,
+		queue:        queue,
+		cLimit:       limit}
+
+	go w.start()
+
+	return w
+}
+
+type durableWorker struct {
+	// context of dequeuing cancelled by Shutdown
+	loopCtx context.Context
+	stop    context.CancelFunc
+	// closed when worker stopped dequeuing Events
+	stopped chan struct{}
+	// context of chains in flight cancelled at drain deadline
+	chainCtx     context.Context
+	cancelChains context.CancelFunc
+	rwMu         sync.RWMutex
+
+	started   bool
+	commands  Commands
+	queue     Queue
+	eventSink func(CommandsWorker, Event)
+	cLimit    int
+
+	chains inFlight
+	// amount of Events dequeued but not started before worker stopped
+	left int
+}
+
+// Enqueues event. Event gets Metadata if it has none,
+// so it keeps the same ID when it is handled after restart.
+func (w *durableWorker) Handle(event Event) error {
+	w.rwMu.RLock()
+	defer w.rwMu.RUnlock()
+
+	if !w.started {
+		return WorkerStopped
+	}
+
+	return w.queue.Enqueue(ensureMetadata(event))
+}
+
+// Enqueues event. Returns ctx error if ctx is done.
+func (w *durableWorker) HandleContext(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return w.Handle(event)
+}
+
+// Enqueues event. Durable worker is never busy.
+func (w *durableWorker) TryHandle(event Event) error {
+	return w.Handle(event)
+}
+
+func (w *durableWorker) IsRunning() bool {
+	w.rwMu.RLock()
+	defer w.rwMu.RUnlock()
+
+	return w.started
+}
+
+// Stops dequeuing Events and waits for chains in flight until ctx is done.
+// Chains still in flight by then are cancelled.
+// Interrupted and pending Events are not acknowledged, so they stay in queue.
+// If queue is not durable they are reported as Dropped.
+// Events waiting in queue are reported as pending if queue implements CountingQueue.
+func (w *durableWorker) Shutdown(ctx context.Context) (DrainReport, error) {
+	w.rwMu.Lock()
+	if !w.started {
+		w.rwMu.Unlock()
+
+		return DrainReport{}, WorkerStopped
+	}
+
+	w.started = false
+	w.rwMu.Unlock()
+
+	w.chains.drain()
+	w.stop()
+	<-w.stopped
+
+	w.chains.waitUntil(ctx, w.cancelChains)
+	w.cancelChains()
+
+	report, _ := w.chains.report()
+	report.Pending = w.left + waitingIn(w.queue)
+	if isDurable(w.queue) {
+		report.Persisted = report.Interrupted + report.Pending
+	} else {
+		report.Dropped = report.Interrupted + report.Pending
+	}
+
+	return report, nil
+}
+
+func (w *durableWorker) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.commands)
+}
+
+func (w *durableWorker) start() {
+	slots := make(chan struct{}, w.cLimit)
+
+	defer func() {
+		w.rwMu.Lock()
+
+		w.started = false
+
+		w.rwMu.Unlock()
+		close(w.stopped)
+	}()
+
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-w.loopCtx.Done():
+			return
+		}
+
+		offset, event, err := w.queue.Dequeue(w.loopCtx)
+		if err != nil {
+			if w.loopCtx.Err() == nil {
+				w.eventSink(w, NewErrEvent(E{Type: DequeueEventType}, err))
+			}
+
+			return
+		}
+
+		if w.loopCtx.Err() != nil {
+			w.left++
+
+			return
+		}
+
+		w.chains.add()
+		go func() {
+			defer func() { <-slots }()
+
+			ctx, cancel := context.WithCancel(w.chainCtx)
+
+			defer cancel()
+
+			result := w.commands.Handle(ctx, event)
+			interrupted := w.chainCtx.Err() != nil
+
+			w.eventSink(w, result)
+			if !interrupted {
+				if err := w.queue.Ack(offset); err != nil {
+					w.eventSink(w, NewErrEvent(event, err))
+				}
+			}
+
+			w.chains.done(event, interrupted)
+		}()
+	}
+}
+
+func (w *durableWorker) sealed() {}
+
+// returns event with its Metadata or with new root Metadata if it has none.
+// Wrappers of event (for example priority) are preserved.
+func ensureMetadata(event Event) EventWithMetadata {
+	if withMetadata, ok := event.(EventWithMetadata); ok {
+		return withMetadata
+	}
+
+	if withMetadata := AsEventWithMetadata(event); withMetadata != nil {
+		return WithMetadata(event, withMetadata.Metadata())
+	}
+
+	id := uuid.New().String()
+
+	return WithMetadata(event, tracing.M{EID: id, ECorrelationID: id, ECausationID: id})
+}
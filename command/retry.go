@@ -0,0 +1,206 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type attemptKey struct{}
+
+// Returns number of current attempt to handle Event (starting from 1).
+// Returns 1 if ctx was not created by retrying Handler.
+func Attempt(ctx context.Context) int {
+	attempt, ok := ctx.Value(attemptKey{}).(int)
+	if !ok {
+		return 1
+	}
+
+	return attempt
+}
+
+// RetryPolicy describes how Handler is retried when it writes an error Event.
+// Every attempt is atomic: Events written during failed attempt are discarded.
+type RetryPolicy struct {
+	// Maximum amount of attempts including the first one.
+	// Values less than 2 disable retries.
+	MaxAttempts int
+	// Delay before the second attempt.
+	InitialBackoff time.Duration
+	// Upper bound for delay between attempts. 0 means no upper bound.
+	MaxBackoff time.Duration
+	// Factor by which delay grows after each attempt. 0 defaults to 2.
+	Multiplier float64
+	// Fraction of delay in range [0, 1] that is randomised to spread retries.
+	Jitter float64
+	// Reports if error is transient and Event should be handled again.
+	// Every error is retried if Retryable is nil.
+	Retryable func(err error) bool
+}
+
+// Returns delay before next attempt after attempt has failed.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 2
+	}
+
+	backoff := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	if backoff < 0 {
+		return 0
+	}
+
+	return time.Duration(backoff)
+}
+
+func (p *RetryPolicy) canRetry(attempt int, err error) bool {
+	if attempt >= p.MaxAttempts {
+		return false
+	}
+
+	if p.Retryable == nil {
+		return true
+	}
+
+	return p.Retryable(err)
+}
+
+// Returns Handler that retries h according to policy.
+func WithRetry(h Handler, policy *RetryPolicy) Handler {
+	return &retryHandler{HandlerWrapper: HandlerWrapper{h}, policy: policy}
+}
+
+type retryHandler struct {
+	HandlerWrapper
+
+	policy *RetryPolicy
+}
+
+func (rh *retryHandler) Handle(ctx context.Context, w EventWriter, event Event) {
+	handleWithRetry(ctx, rh.policy, rh.Handler.Handle, w, event)
+}
+
+func handleWithRetry(ctx context.Context, policy *RetryPolicy,
+	handle func(context.Context, EventWriter, Event), w EventWriter, event Event) {
+	defer w.Done()
+
+	for attempt := 1; ; attempt++ {
+		attemptW := newAttemptWriter()
+
+		handle(context.WithValue(ctx, attemptKey{}, attempt), attemptW, event)
+
+		select {
+		case <-ctx.Done():
+			w.Write(NewErrEvent(event, ctx.Err()))
+
+			return
+		case <-attemptW.done:
+		}
+
+		err := attemptW.Err()
+		if err == nil || !policy.canRetry(attempt, err) {
+			attemptW.flush(w, attempt)
+
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			w.Write(NewErrEvent(event, ctx.Err()))
+
+			return
+		case <-time.After(policy.Backoff(attempt)):
+		}
+	}
+}
+
+func newAttemptWriter() *attemptWriter {
+	return &attemptWriter{done: make(chan struct{})}
+}
+
+// collects Events written during single attempt.
+type attemptWriter struct {
+	mu     sync.Mutex
+	once   sync.Once
+	isDone bool
+
+	events []Event
+	done   chan struct{}
+}
+
+func (w *attemptWriter) Write(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.isDone {
+		w.events = append(w.events, e)
+	}
+}
+
+func (w *attemptWriter) Done() {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.isDone = true
+		w.mu.Unlock()
+
+		close(w.done)
+	})
+}
+
+// returns first error written during attempt.
+func (w *attemptWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, e := range w.events {
+		if e == nil {
+			continue
+		}
+
+		if err := e.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *attemptWriter) flush(to EventWriter, attempts int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, e := range w.events {
+		if e != nil && e.Err() != nil && attempts > 1 {
+			e = retriesExhausted(e, attempts)
+		}
+
+		to.Write(e)
+	}
+}
+
+// returns error event e with its cause wrapped in *ErrRetriesExhausted.
+// Metadata of e is preserved.
+func retriesExhausted(e Event, attempts int) Event {
+	var errEvent *ErrEvent
+	if !errors.As(e.Err(), &errEvent) {
+		return e
+	}
+
+	exhausted := NewErrEvent(errEvent.Event(), &ErrRetriesExhausted{Attempts: attempts, Err: errEvent.Unwrap()})
+	if withMetadata, ok := e.(EventWithMetadata); ok {
+		return WithMetadata(exhausted, withMetadata.Metadata())
+	}
+
+	return exhausted
+}
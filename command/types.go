@@ -2,6 +2,7 @@ package command
 
 import (
 	"context"
+	"time"
 
 	"github.com/andriiyaremenko/tinycqs/tracing"
 )
@@ -53,6 +54,27 @@ type Handler interface {
 	Workers() int
 }
 
+// Handler that limits time of single Handle call.
+// If Handler does not call EventWriter.Done in time
+// *ErrEvent caused by context.DeadlineExceeded is written instead and Handler writer is closed.
+type TimeoutHandler interface {
+	Handler
+	// Maximum duration of single Handle call. 0 means no limit.
+	HandleTimeout() time.Duration
+}
+
+// Handler that declares whether it has side effects outside of Commands.
+// Handlers with side effects are skipped in dry-run (see WithDryRun).
+type SideEffectHandler interface {
+	Handler
+	// Reports whether Handler has side effects.
+	HasSideEffects() bool
+}
+
+// Observer is notified about Events dispatched by Commands.
+// Events include initial Event, chained Events, error Events and *DoneEvents.
+type Observer func(ctx context.Context, event EventWithMetadata) error
+
 // Sealed interface to handle Events.
 type Commands interface {
 	sealed()
@@ -61,6 +83,7 @@ type Commands interface {
 	// Can chain Events if any occurred as a result of processing this event.
 	Handle(ctx context.Context, event Event) Event
 	// Handles event regardless of its type without event chaining.
+	// Observers (see WithObserver) are not notified.
 	HandleOnly(ctx context.Context, event Event, only ...string) Event
 }
 
@@ -74,4 +97,26 @@ type CommandsWorker interface {
 	// Handles event regardless of its type.
 	// Can chain Events if any occurred as a result of processing this event.
 	Handle(event Event) error
+	// Handles event as Handle does, but returns ctx error if ctx is done before event was accepted.
+	HandleContext(ctx context.Context, event Event) error
+	// Handles event as Handle does, but returns ErrWorkerBusy instead of waiting if worker is busy.
+	TryHandle(event Event) error
+	// Stops accepting Events and waits for chains in flight until ctx is done.
+	// Chains still in flight by then are cancelled.
+	// Returns WorkerStopped if CommandWorker is not running.
+	Shutdown(ctx context.Context) (DrainReport, error)
+}
+
+// DrainReport describes Events affected by CommandsWorker.Shutdown.
+type DrainReport struct {
+	// Amount of chains in flight that finished before drain deadline.
+	Completed int
+	// Amount of chains in flight cancelled at drain deadline.
+	Interrupted int
+	// Amount of accepted Events that were not started.
+	Pending int
+	// Amount of interrupted and pending Events kept in Queue.
+	Persisted int
+	// Amount of interrupted and pending Events that were lost.
+	Dropped int
 }
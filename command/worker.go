@@ -5,25 +5,56 @@ import (
 	"encoding/json"
 	"errors"
 	"sync"
+	"sync/atomic"
 )
 
 var (
 	WorkerStopped = errors.New("command worker is stopped")
 )
 
+// WorkerOption configures CommandsWorker created by NewWorkerWithOptions.
+type WorkerOption func(*worker)
+
+// Stores Events left unprocessed by CommandsWorker.Shutdown in queue,
+// so they can be handled later (see NewDurableWorker).
+func WithShutdownQueue(queue Queue) WorkerOption {
+	return func(w *worker) {
+		w.shutdownQueue = queue
+	}
+}
+
 // Returns CommandWorker based on Commands.
 // eventSink is used to channel all unhandled errors in form of Event.
 func NewWorker(ctx context.Context, eventSink func(CommandsWorker, Event), commands Commands, limit int) CommandsWorker {
+	return NewWorkerWithOptions(ctx, eventSink, commands, limit)
+}
+
+// Returns CommandWorker based on Commands configured with options.
+// eventSink is used to channel all unhandled errors in form of Event.
+func NewWorkerWithOptions(ctx context.Context, eventSink func(CommandsWorker, Event), commands Commands, limit int, options ...WorkerOption) CommandsWorker {
 	if limit < 1 {
 		limit = 1
 	}
 
+	chainCtx, cancelChains := context.WithCancel(ctx)
 	w := &worker{
-		ctx:       ctx,
-		started:   false,
-		eventSink: eventSink,
-		commands:  commands,
-		cLimit:    limit}
+		ctx:          ctx,
+		chainCtx:     chainCtx,
+		cancelChains: cancelChains,
+		started:      false,
+		eventSink:    eventSink,
+		commands:     commands,
+		cLimit:       limit,
+		stop:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		moverDone:    make(chan struct{}),
+		arrived:      make(chan struct{}, 1)}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	w.lanes = newLanes(w.laneWeights, w.cLimit)
 
 	w.start()
 
@@ -31,27 +62,64 @@ func NewWorker(ctx context.Context, eventSink func(CommandsWorker, Event), comma
 }
 
 type worker struct {
-	ctx  context.Context
-	rwMu sync.RWMutex
+	ctx context.Context
+	// context of chains in flight cancelled at drain deadline
+	chainCtx     context.Context
+	cancelChains context.CancelFunc
+	rwMu         sync.RWMutex
 
 	started   bool
 	commands  Commands
-	eventPipe chan Event
 	eventSink func(CommandsWorker, Event)
 	cLimit    int
+
+	// worker queue split by priority
+	lanes       []*lane
+	laneWeights map[Priority]int
+	// signals that Event was put to one of lanes
+	arrived chan struct{}
+
+	pool          pool
+	chains        inFlight
+	shutdownQueue Queue
+	// Handle calls putting Events to lanes
+	submitting sync.WaitGroup
+	// closed by Shutdown to stop receiving Events
+	stop chan struct{}
+	// closed when worker stopped receiving Events
+	stopped chan struct{}
+	// Events accepted but not started before worker stopped
+	left []Event
+
+	overflow   OverflowPolicy
+	spillQueue Queue
+	// amount of Events in spillQueue
+	spilled int64
+	// closed when worker stopped moving spilled Events
+	moverDone chan struct{}
 }
 
+// Puts event to worker queue according to overflow policy.
 func (w *worker) Handle(event Event) error {
-	w.rwMu.RLock()
-	defer w.rwMu.RUnlock()
+	return w.HandleContext(context.Background(), event)
+}
 
-	if !w.started {
-		return WorkerStopped
+// Puts event to worker queue according to overflow policy.
+// Returns ctx error if ctx is done before event was accepted.
+func (w *worker) HandleContext(ctx context.Context, event Event) error {
+	dropped, err := w.submit(ctx, event, true)
+	for _, e := range dropped {
+		w.eventSink(w, NewErrEvent(e, ErrEventDropped))
 	}
 
-	w.eventPipe <- event
+	return err
+}
+
+// Puts event to worker queue or returns ErrWorkerBusy if it is full.
+func (w *worker) TryHandle(event Event) error {
+	_, err := w.submit(context.Background(), event, false)
 
-	return nil
+	return err
 }
 
 func (w *worker) IsRunning() bool {
@@ -61,6 +129,44 @@ func (w *worker) IsRunning() bool {
 	return w.started
 }
 
+// Stops accepting Events and waits for chains in flight until ctx is done.
+// Chains still in flight by then are cancelled.
+// Events that were accepted but not started and interrupted Events are stored in shutdown Queue if it is set.
+func (w *worker) Shutdown(ctx context.Context) (DrainReport, error) {
+	w.rwMu.Lock()
+	if !w.started {
+		w.rwMu.Unlock()
+
+		return DrainReport{}, WorkerStopped
+	}
+
+	w.started = false
+	w.rwMu.Unlock()
+
+	w.chains.drain()
+	close(w.stop)
+	<-w.stopped
+
+	w.chains.waitUntil(ctx, w.cancelChains)
+	w.cancelChains()
+
+	report, interrupted := w.chains.report()
+	report.Pending = len(w.left)
+
+	unprocessed := append(w.left, interrupted...)
+	err := persist(w.shutdownQueue, unprocessed, &report)
+
+	spilled := int(atomic.LoadInt64(&w.spilled))
+	report.Pending += spilled
+	if isDurable(w.spillQueue) {
+		report.Persisted += spilled
+	} else {
+		report.Dropped += spilled
+	}
+
+	return report, err
+}
+
 func (w *worker) MarshalJSON() ([]byte, error) {
 	return json.Marshal(w.commands)
 }
@@ -70,42 +176,198 @@ func (w *worker) start() {
 		return
 	}
 
+	w.pool.init(w.cLimit, w.run)
+
 	w.rwMu.Lock()
 
-	w.eventPipe = make(chan Event, w.cLimit)
 	w.started = true
 
 	w.rwMu.Unlock()
 
+	if w.overflow == OverflowSpill && w.spillQueue != nil {
+		go w.moveSpilled()
+	} else {
+		close(w.moverDone)
+	}
+
 	go func() {
-		var wg sync.WaitGroup
+		defer close(w.stopped)
 
 		for {
 			select {
 			case <-w.ctx.Done():
-				w.rwMu.Lock()
+				w.cancelled()
+
+				return
+			case <-w.stop:
+				w.shutDown()
 
-				wg.Wait()
+				return
+			default:
+			}
 
-				w.started = false
-				close(w.eventPipe)
+			ready, changed := w.pool.ready()
+			if ready {
+				if event, ok := w.next(); ok {
+					w.pool.schedule(event)
 
-				w.rwMu.Unlock()
+					continue
+				}
+			}
+
+			select {
+			case <-w.ctx.Done():
+				w.cancelled()
 
 				return
-			case event := <-w.eventPipe:
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
+			case <-w.stop:
+				w.shutDown()
+
+				return
+			case <-changed:
+			case <-w.arrived:
+			}
+		}
+	}()
+}
+
+// stops worker after its context is done.
+func (w *worker) cancelled() {
+	w.rwMu.Lock()
+	w.started = false
+	w.rwMu.Unlock()
+
+	<-w.moverDone
+	w.pool.stop()
+	w.chains.wait()
+}
 
-					ctx, cancel := context.WithCancel(w.ctx)
+// stops worker on Shutdown and collects Events that were not started.
+func (w *worker) shutDown() {
+	w.submitting.Wait()
+	<-w.moverDone
 
-					defer cancel()
-					w.eventSink(w, w.commands.Handle(ctx, event))
-				}()
+	w.left = w.pool.stop()
+	for _, l := range w.lanes {
+		for len(l.events) > 0 {
+			select {
+			case event := <-l.events:
+				w.left = append(w.left, event)
+			default:
 			}
 		}
+	}
+}
+
+// handles event in its own chain.
+func (w *worker) run(event Event) {
+	w.chains.add()
+	go func() {
+		ctx, cancel := context.WithCancel(w.chainCtx)
+
+		defer cancel()
+
+		result := w.commands.Handle(ctx, event)
+		interrupted := w.chainCtx.Err() != nil
+
+		// release chain limits before eventSink, so it can submit Events to w
+		w.pool.finish(event)
+		w.eventSink(w, result)
+		w.chains.done(event, interrupted)
 	}()
 }
 
 func (w *worker) sealed() {}
+
+// tracks chains in flight to report them on Shutdown.
+type inFlight struct {
+	mu sync.Mutex
+	wg sync.WaitGroup
+
+	draining    bool
+	completed   int
+	interrupted []Event
+}
+
+func (f *inFlight) add() {
+	f.wg.Add(1)
+}
+
+func (f *inFlight) done(event Event, interrupted bool) {
+	defer f.wg.Done()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.draining {
+		return
+	}
+
+	if interrupted {
+		f.interrupted = append(f.interrupted, event)
+
+		return
+	}
+
+	f.completed++
+}
+
+// starts counting chains finished during drain.
+func (f *inFlight) drain() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.draining = true
+}
+
+func (f *inFlight) wait() {
+	f.wg.Wait()
+}
+
+// waits for chains in flight until ctx is done, then calls cancel and waits for cancelled chains.
+func (f *inFlight) waitUntil(ctx context.Context, cancel context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		cancel()
+		<-done
+	}
+}
+
+// returns report of chains finished during drain and interrupted Events.
+func (f *inFlight) report() (DrainReport, []Event) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return DrainReport{Completed: f.completed, Interrupted: len(f.interrupted)}, f.interrupted
+}
+
+// stores events in queue if it is set and updates report.
+func persist(queue Queue, events []Event, report *DrainReport) error {
+	report.Dropped = len(events)
+	if queue == nil {
+		return nil
+	}
+
+	var err error
+	for _, event := range events {
+		if e := queue.Enqueue(ensureMetadata(event)); e != nil {
+			if err == nil {
+				err = e
+			}
+
+			continue
+		}
+
+		report.Persisted++
+		report.Dropped--
+	}
+
+	return err
+}
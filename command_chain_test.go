@@ -0,0 +1,173 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandChainGuard(t *testing.T) {
+	t.Run("Chain deeper than limit should fail", testChainTooDeepShouldFail)
+	t.Run("Results of the last handler should not count towards depth", testChainResultsShouldNotCountTowardsDepth)
+	t.Run("Event cycle should be detected", testEventCycleShouldBeDetected)
+	t.Run("Total amount of events should be limited", testTooManyEventsShouldFail)
+	t.Run("Results should not count towards total amount of events", testChainResultsShouldNotCountTowardsMaxEvents)
+}
+
+func chainingHandler(eventType, next string) command.Handler {
+	return &command.BaseHandler{
+		Type: eventType,
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			r.Write(command.E{Type: next})
+		}}
+}
+
+func testChainTooDeepShouldFail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+
+	defer cancel()
+
+	assert := assert.New(t)
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMaxChainDepth(3)},
+		chainingHandler("test_1", "test_2"),
+		chainingHandler("test_2", "test_3"),
+		chainingHandler("test_3", "test_4"),
+		chainingHandler("test_4", "test_5"),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	tooDeep := new(command.ErrChainTooDeep)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+
+	if assert.Len(inner, 1, "one error should be returned") &&
+		assert.True(errors.As(inner[0], &tooDeep), "error should wrap *command.ErrChainTooDeep") {
+		assert.Equal([]string{"test_1", "test_2", "test_3", "test_4"}, tooDeep.Path, "path should be reported")
+	}
+}
+
+func testChainResultsShouldNotCountTowardsDepth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+
+	defer cancel()
+
+	assert := assert.New(t)
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMaxChainDepth(2)},
+		chainingHandler("test_1", "test_2"),
+		&command.BaseHandler{
+			Type: "test_2",
+			HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+				defer r.Done()
+
+				r.Write(command.Done(command.E{Type: "test_2", P: []byte(`"result"`)}))
+			}},
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "chain within limit should not fail")
+	assert.Contains(string(ev.Payload()), `"payload":"result"`, "result should be returned")
+
+	c, _ = command.NewWithOptions(
+		[]command.Option{command.WithMaxChainDepth(2)},
+		chainingHandler("test_1", "test_2"),
+		command.HandlerFunc("test_2", func(context.Context, []byte) error { return errors.New("fail") }),
+	)
+
+	ev = c.Handle(ctx, command.E{Type: "test_1"})
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	tooDeep := new(command.ErrChainTooDeep)
+
+	if assert.Len(inner, 1, "one error should be returned") {
+		assert.False(errors.As(inner[0], &tooDeep), "handler error should be returned")
+	}
+}
+
+func testEventCycleShouldBeDetected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+
+	defer cancel()
+
+	assert := assert.New(t)
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithCycleDetection()},
+		chainingHandler("test_1", "test_2"),
+		chainingHandler("test_2", "test_1"),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	cycle := new(command.ErrEventCycle)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+
+	if assert.Len(inner, 1, "one error should be returned") &&
+		assert.True(errors.As(inner[0], &cycle), "error should wrap *command.ErrEventCycle") {
+		assert.Equal([]string{"test_1", "test_2", "test_1"}, cycle.Path, "path should be reported")
+		assert.Contains(inner[0].Error(), "test_1 -> test_2 -> test_1", "path should be named in error")
+	}
+}
+
+func testTooManyEventsShouldFail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			for i := 0; i < 5; i++ {
+				r.Write(command.E{Type: fmt.Sprintf("test_%d", i+2)})
+			}
+		}}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		return nil
+	}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMaxEvents(4)},
+		handler,
+		command.HandlerFunc("test_*", handlerFunc),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+
+	if assert.Len(inner, 2, "two errors should be returned") {
+		for _, err := range inner {
+			assert.True(errors.As(err, new(*command.ErrTooManyEvents)), "error should wrap *command.ErrTooManyEvents")
+		}
+	}
+}
+
+func testChainResultsShouldNotCountTowardsMaxEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+
+	defer cancel()
+
+	assert := assert.New(t)
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMaxEvents(3)},
+		chainingHandler("test_1", "test_2"),
+		chainingHandler("test_2", "test_3"),
+		&command.BaseHandler{
+			Type: "test_3",
+			HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+				defer r.Done()
+
+				r.Write(command.Done(command.E{Type: "test_3", P: []byte(`"result"`)}))
+			}},
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "chain of exactly max events should not fail")
+	assert.Contains(string(ev.Payload()), `"payload":"result"`, "result should be returned")
+}
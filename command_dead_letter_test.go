@@ -0,0 +1,306 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterQueue(t *testing.T) {
+	t.Run("Failed events should be sent to dead letter queue", testFailedEventsShouldBeDeadLettered)
+	t.Run("Dead letter should be re-submitted to Commands", testDeadLetterShouldBeResubmitted)
+	t.Run("Dead letter should be kept if re-submitted event fails again", testDeadLetterShouldBeKeptIfResubmissionFails)
+	t.Run("Only unhandled command events should be dead lettered on cancel",
+		testOnlyUnhandledCommandsShouldBeDeadLetteredOnCancel)
+	t.Run("File dead letter queue should restore dead letters", testFileDeadLetterQueueShouldRestore)
+	t.Run("File dead letter queue should recover from crash mid-write", testFileDeadLetterQueueShouldRecoverFromTornWrite)
+	t.Run("File dead letter queue should compact itself", testFileDeadLetterQueueShouldCompactItself)
+}
+
+func testFailedEventsShouldBeDeadLettered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.E{Type: "test_2", P: []byte("payload")})
+			r.Write(command.E{Type: "test_3"})
+		}}
+	handlerFunc2 := func(ctx context.Context, _ []byte) error {
+		return errors.New("fail")
+	}
+	dlq := command.NewInMemoryDeadLetterQueue()
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithDeadLetterQueue(dlq)},
+		handler1,
+		command.HandlerFunc("test_2", handlerFunc2),
+	)
+
+	ev := c.Handle(ctx, command.WithMetadata(command.E{Type: "test_1"},
+		tracing.M{EID: "1", ECausationID: "1", ECorrelationID: "correlation"}))
+	assert.Error(ev.Err(), "error should be returned")
+
+	letters, err := dlq.List()
+	assert.NoError(err, "no error should be returned")
+
+	types := make([]string, 0, len(letters))
+	for _, letter := range letters {
+		types = append(types, letter.EventType)
+		assert.Equal("correlation", letter.CorrelationID, "correlation ID should be preserved")
+
+		if letter.EventType == "test_2" {
+			assert.Equal([]byte("payload"), letter.Payload, "payload should be preserved")
+			assert.Equal("fail", letter.Causes[len(letter.Causes)-1], "root cause should be recorded")
+		}
+	}
+
+	assert.ElementsMatch([]string{"test_2", "test_3"}, types, "failed events should be dead lettered")
+}
+
+func testOnlyUnhandledCommandsShouldBeDeadLetteredOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			cancel()
+
+			r.Write(command.E{Type: "test_2"})
+			r.Write(command.Done(command.E{Type: "test_3"}))
+			r.Write(command.NewErrEvent(e, errors.New("fail")))
+		}}
+	dlq := command.NewInMemoryDeadLetterQueue()
+	c, _ := command.NewWithOptions([]command.Option{command.WithDeadLetterQueue(dlq)}, handler)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+
+	letters, err := dlq.List()
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(letters, 1, "only unhandled command event should be dead lettered") {
+		assert.Equal("test_2", letters[0].EventType, "unhandled command event should be dead lettered")
+	}
+}
+
+func testDeadLetterShouldBeResubmitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	dlq := command.NewInMemoryDeadLetterQueue()
+	fails := true
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		if fails {
+			return errors.New("fail")
+		}
+
+		return nil
+	}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithDeadLetterQueue(dlq)},
+		command.HandlerFunc("test_1", handlerFunc),
+	)
+
+	assert.Error(c.Handle(ctx, command.E{Type: "test_1"}).Err(), "error should be returned")
+
+	letters, _ := dlq.List()
+	if !assert.Len(letters, 1, "one dead letter should be stored") {
+		return
+	}
+
+	fails = false
+	ev, err := command.Resubmit(ctx, dlq, letters[0].ID, c)
+	assert.NoError(err, "no error should be returned")
+	assert.NoError(ev.Err(), "re-submitted event should succeed")
+
+	letters, _ = dlq.List()
+	assert.Empty(letters, "dead letter should be removed")
+
+	_, err = command.Resubmit(ctx, dlq, "unknown", c)
+	assert.IsType(&command.ErrDeadLetterNotFound{}, err, "error should be of type *command.ErrDeadLetterNotFound")
+}
+
+func testDeadLetterShouldBeKeptIfResubmissionFails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	dlq := command.NewInMemoryDeadLetterQueue()
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		return errors.New("fail again")
+	}
+	withDLQ, _ := command.NewWithOptions(
+		[]command.Option{command.WithDeadLetterQueue(dlq)},
+		command.HandlerFunc("test_1", handlerFunc),
+	)
+	withoutDLQ, _ := command.New(command.HandlerFunc("test_1", handlerFunc))
+
+	letter := command.NewDeadLetter(command.WithMetadata(command.E{Type: "test_1"},
+		tracing.M{EID: "1", ECausationID: "1", ECorrelationID: "1"}), errors.New("fail"))
+	assert.NoError(dlq.Put(letter), "no error should be returned")
+
+	for _, c := range []command.Commands{withoutDLQ, withDLQ} {
+		ev, err := command.Resubmit(ctx, dlq, letter.ID, c)
+		assert.Error(err, "error should be returned")
+		assert.Error(ev.Err(), "re-submitted event should fail")
+
+		letters, _ := dlq.List()
+		if assert.Len(letters, 1, "dead letter should be kept once") {
+			assert.Equal(letter.ID, letters[0].ID, "dead letter should keep its ID")
+		}
+	}
+
+	letters, _ := dlq.List()
+	if assert.Len(letters, 1) {
+		assert.Equal("fail again", letters[0].Causes[len(letters[0].Causes)-1], "latest cause should be recorded")
+	}
+}
+
+func testFileDeadLetterQueueShouldRestore(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "dlq.log")
+
+	dlq, err := command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	event := command.WithMetadata(command.E{Type: "test_1", P: []byte("1")},
+		tracing.M{EID: "id", ECausationID: "causation", ECorrelationID: "correlation"})
+	first := command.NewDeadLetter(event, errors.New("first"))
+	second := command.NewDeadLetter(event, errors.New("second"))
+
+	assert.NoError(dlq.Put(first), "no error should be returned")
+	assert.NoError(dlq.Put(second), "no error should be returned")
+	assert.NoError(dlq.Remove(first.ID), "no error should be returned")
+	assert.NoError(dlq.Close(), "no error should be returned")
+
+	dlq, err = command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer dlq.Close()
+
+	letters, err := dlq.List()
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(letters, 1, "only one dead letter should be restored") {
+		restored := letters[0].Event()
+		assert.Equal(second.ID, letters[0].ID, "second dead letter should be restored")
+		assert.Equal("test_1", restored.EventType(), "event type should be restored")
+		assert.Equal("id", restored.Metadata().ID(), "event ID should be restored")
+		assert.Equal([]string{"second"}, letters[0].Causes, "causes should be restored")
+	}
+}
+
+func testFileDeadLetterQueueShouldRecoverFromTornWrite(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "dlq.log")
+
+	dlq, err := command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	first := command.NewDeadLetter(command.WithMetadata(command.E{Type: "test_1"}, nil), errors.New("first"))
+	second := command.NewDeadLetter(command.WithMetadata(command.E{Type: "test_2"}, nil), errors.New("second"))
+
+	assert.NoError(dlq.Put(first), "no error should be returned")
+	assert.NoError(dlq.Close(), "no error should be returned")
+
+	// simulate crash in the middle of writing record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	f.WriteString(`{"id": "torn", "event`)
+	f.Close()
+
+	dlq, err = command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.NoError(dlq.Put(second), "no error should be returned")
+	assert.NoError(dlq.Close(), "no error should be returned")
+
+	dlq, err = command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer dlq.Close()
+
+	letters, err := dlq.List()
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(letters, 2, "dead letters written before and after crash should be restored") {
+		assert.Equal(first.ID, letters[0].ID, "first dead letter should be restored")
+		assert.Equal(second.ID, letters[1].ID, "second dead letter should be restored")
+	}
+}
+
+func testFileDeadLetterQueueShouldCompactItself(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "dlq.log")
+
+	dlq, err := command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	kept := command.NewDeadLetter(command.WithMetadata(command.E{Type: "kept"}, nil), errors.New("fail"))
+	assert.NoError(dlq.Put(kept), "no error should be returned")
+
+	for i := 0; i < 150; i++ {
+		letter := command.NewDeadLetter(command.WithMetadata(command.E{Type: "test_1"}, nil), errors.New("fail"))
+		assert.NoError(dlq.Put(letter), "no error should be returned")
+		assert.NoError(dlq.Remove(letter.ID), "no error should be returned")
+	}
+
+	assert.NoError(dlq.Close(), "no error should be returned")
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "removed dead letters should be dropped from file")
+
+	dlq, err = command.NewFileDeadLetterQueue(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer dlq.Close()
+
+	letters, err := dlq.List()
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(letters, 1, "stored dead letter should survive compaction") {
+		assert.Equal(kept.ID, letters[0].ID, "stored dead letter should survive compaction")
+	}
+}
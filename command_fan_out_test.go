@@ -0,0 +1,173 @@
+package tinycqs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandFanOut(t *testing.T) {
+	t.Run("Every subscriber should receive event", testFanOutEverySubscriberShouldReceiveEvent)
+	t.Run("Every subscriber should get its own causation metadata", testFanOutSubscribersShouldHaveOwnMetadata)
+	t.Run("Every subscriber should be called in HandleOnly", testFanOutHandleOnly)
+	t.Run("HandleOnly should report errors of every subscriber", testFanOutHandleOnlyShouldAggregateErrors)
+	t.Run("Commands should marshal event type once", testFanOutMarshalJSON)
+}
+
+func testFanOutEverySubscriberShouldReceiveEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "user_created",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.Done(command.E{Type: "email_sent"}))
+		}}
+	handler2 := &command.BaseHandler{
+		Type: "user_created",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.Done(command.E{Type: "projection_updated"}))
+		}}
+	auditWasCalled := &wasCalledCounter{}
+	handlerFunc3 := func(ctx context.Context, _ []byte) error {
+		auditWasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(handler1, handler2, command.HandlerFunc("user_created", handlerFunc3))
+
+	ev := c.Handle(ctx, command.E{Type: "user_created"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(1, auditWasCalled.getCount(), "audit handler should have been called once")
+
+	var result command.EventMessage
+	if err := json.Unmarshal(ev.Payload(), &result); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	var messages []command.EventMessage
+	if err := json.Unmarshal(result.Payload, &messages); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	types := make([]string, 0, len(messages))
+	for _, m := range messages {
+		types = append(types, m.EventType)
+	}
+
+	assert.ElementsMatch([]string{"email_sent", "projection_updated"}, types, "every subscriber should report result")
+}
+
+func testFanOutSubscribersShouldHaveOwnMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	ids := make(map[string]struct{})
+	handle := func(ctx context.Context, r command.EventWriter, e command.Event) {
+		defer r.Done()
+
+		metadata := command.AsEventWithMetadata(e).Metadata()
+
+		mu.Lock()
+		ids[metadata.ID()] = struct{}{}
+		mu.Unlock()
+
+		assert.Equal("id", metadata.CausationID(), "subscriber event should be caused by original event")
+		assert.Equal("correlation", metadata.CorrelationID(), "correlation ID should be preserved")
+	}
+
+	c, _ := command.New(
+		&command.BaseHandler{Type: "test_1", HandleFunc: handle},
+		&command.BaseHandler{Type: "test_1", HandleFunc: handle},
+	)
+
+	ev := c.Handle(ctx, command.WithMetadata(command.E{Type: "test_1"},
+		tracing.M{EID: "id", ECausationID: "id", ECorrelationID: "correlation"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Len(ids, 2, "every subscriber should receive unique event ID")
+}
+
+func testFanOutHandleOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(
+		command.HandlerFunc("test_1", handlerFunc),
+		command.HandlerFunc("test_1", handlerFunc),
+	)
+
+	ev := c.HandleOnly(ctx, command.E{Type: "test_1"}, "test_1")
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.True(command.IsDone(ev, "test_1"), "done event should be returned")
+	assert.Equal(2, handlerWasCalled.getCount(), "both handlers should have been called")
+}
+
+func testFanOutHandleOnlyShouldAggregateErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(
+		command.HandlerFunc("test_1", func(ctx context.Context, _ []byte) error {
+			handlerWasCalled.increase()
+			return errors.New("first")
+		}),
+		command.HandlerFunc("test_1", func(ctx context.Context, _ []byte) error {
+			time.Sleep(20 * time.Millisecond)
+
+			handlerWasCalled.increase()
+			return errors.New("second")
+		}),
+	)
+
+	ev := c.HandleOnly(ctx, command.E{Type: "test_1"}, "test_1")
+	assert.Equal(2, handlerWasCalled.getCount(), "both handlers should have finished")
+
+	if assert.IsType(&command.ErrAggregatedEvent{}, ev, "error should be of type *command.ErrAggregatedEvent") {
+		assert.Len(ev.(*command.ErrAggregatedEvent).Inner(), 2, "errors of both handlers should be returned")
+	}
+}
+
+func testFanOutMarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		return nil
+	}
+
+	c, _ := command.New(
+		command.HandlerFunc("test_1", handlerFunc),
+		command.HandlerFunc("test_1", handlerFunc),
+		command.HandlerFunc("test_2", handlerFunc),
+	)
+
+	b, err := json.Marshal(c)
+	assert.NoError(err, "no error should be returned")
+	assert.JSONEq(`["test_1", "test_2"]`, string(b), "event types should not repeat")
+}
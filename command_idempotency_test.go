@@ -0,0 +1,277 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/jsonrpc"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandIdempotency(t *testing.T) {
+	t.Run("Commands should return stored result for duplicate events", testCommandsShouldReturnStoredResultForDuplicates)
+	t.Run("Commands should keep idempotency keys per event type", testCommandsShouldKeepKeysPerEventType)
+	t.Run("Commands should not store failed results", testCommandsShouldNotStoreFailedResults)
+	t.Run("Commands should handle event again once its result expires", testCommandsShouldHandleExpiredEventsAgain)
+	t.Run("Commands should wait for duplicate events in flight", testCommandsShouldWaitForDuplicatesInFlight)
+	t.Run("Commands should keep processed events in file store", testCommandsShouldKeepProcessedEventsInFileStore)
+	t.Run("File idempotency store should compact expired events", testFileIdempotencyStoreShouldCompactExpiredEvents)
+	t.Run("JSON RPC Handler should deduplicate requests by request ID", testHandlerShouldDeduplicateByRequestID)
+}
+
+// returns Handler of eventType counting its calls.
+func countingHandler(eventType string, counter *wasCalledCounter) command.Handler {
+	return command.HandlerFunc(eventType, func(context.Context, []byte) error {
+		counter.increase()
+
+		return nil
+	})
+}
+
+func testCommandsShouldReturnStoredResultForDuplicates(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithIdempotency(command.NewInMemoryIdempotencyStore(), time.Hour)},
+		countingHandler("test", handlerWasCalled))
+	event := command.WithMetadata(command.E{Type: "test", P: []byte(`"payload"`)},
+		tracing.M{EID: "1", ECausationID: "1", ECorrelationID: "1"})
+
+	first := c.Handle(ctx, event)
+	assert.NoError(first.Err(), "no error should be returned")
+
+	second := c.Handle(ctx, event)
+	assert.NoError(second.Err(), "no error should be returned")
+	assert.True(command.IsDone(second, "test"), "duplicate should return DoneEvent")
+	assert.Equal(first.Payload(), second.Payload(), "duplicate should return stored result")
+	assert.Equal(1, handlerWasCalled.getCount(), "duplicate should not be handled")
+
+	c.Handle(ctx, command.WithIdempotencyKey(event, "other"))
+	assert.Equal(2, handlerWasCalled.getCount(), "event with other idempotency key should be handled")
+
+	c.Handle(ctx, command.E{Type: "test"})
+	c.Handle(ctx, command.E{Type: "test"})
+	assert.Equal(4, handlerWasCalled.getCount(), "events without metadata should always be handled")
+
+	c.Handle(command.WithDryRun(ctx), event)
+	assert.Equal(5, handlerWasCalled.getCount(), "idempotency should not be applied in dry-run")
+}
+
+func testCommandsShouldKeepKeysPerEventType(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithIdempotency(command.NewInMemoryIdempotencyStore(), time.Hour)},
+		countingHandler("test", handlerWasCalled),
+		countingHandler("test_1", handlerWasCalled))
+
+	first := c.Handle(ctx, command.WithIdempotencyKey(command.E{Type: "test"}, "key"))
+	assert.NoError(first.Err(), "no error should be returned")
+
+	second := c.Handle(ctx, command.WithIdempotencyKey(command.E{Type: "test_1"}, "key"))
+	assert.NoError(second.Err(), "no error should be returned")
+	assert.True(command.IsDone(second, "test_1"), "event of other type should return its own result")
+	assert.Equal(2, handlerWasCalled.getCount(), "event of other type with the same key should be handled")
+}
+
+func testCommandsShouldNotStoreFailedResults(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithIdempotency(command.NewInMemoryIdempotencyStore(), time.Hour)},
+		command.HandlerFunc("test", func(context.Context, []byte) error {
+			if handlerWasCalled.increase(); handlerWasCalled.getCount() == 1 {
+				return errors.New("fail")
+			}
+
+			return nil
+		}))
+	event := command.WithIdempotencyKey(command.E{Type: "test"}, "key")
+
+	assert.Error(c.Handle(ctx, event).Err(), "error should be returned")
+	assert.NoError(c.Handle(ctx, event).Err(), "failed event should be handled again")
+	assert.NoError(c.Handle(ctx, event).Err(), "no error should be returned")
+	assert.Equal(2, handlerWasCalled.getCount(), "event should be handled until it succeeds")
+}
+
+func testCommandsShouldHandleExpiredEventsAgain(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithIdempotency(command.NewInMemoryIdempotencyStore(), time.Millisecond*50)},
+		countingHandler("test", handlerWasCalled))
+	event := command.WithIdempotencyKey(command.E{Type: "test"}, "key")
+
+	c.Handle(ctx, event)
+	c.Handle(ctx, event)
+	assert.Equal(1, handlerWasCalled.getCount(), "duplicate should not be handled")
+
+	time.Sleep(time.Millisecond * 60)
+
+	c.Handle(ctx, event)
+	assert.Equal(2, handlerWasCalled.getCount(), "expired event should be handled again")
+}
+
+func testCommandsShouldWaitForDuplicatesInFlight(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	release := make(chan struct{})
+	c, _ := command.NewWithOptions(
+		[]command.Option{
+			command.WithIdempotency(command.NewInMemoryIdempotencyStore(), 0),
+			command.WithConcurrencyLimit(2)},
+		command.HandlerFunc("test", func(context.Context, []byte) error {
+			handlerWasCalled.increase()
+			<-release
+
+			return nil
+		}))
+	event := command.WithIdempotencyKey(command.E{Type: "test"}, "key")
+
+	var wg sync.WaitGroup
+	results := make(chan command.Event, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results <- c.Handle(ctx, event)
+		}()
+	}
+
+	time.Sleep(time.Millisecond * 20)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(1, handlerWasCalled.getCount(), "concurrent duplicate should not be handled")
+	assert.NoError((<-results).Err(), "no error should be returned")
+	assert.NoError((<-results).Err(), "no error should be returned")
+}
+
+func testCommandsShouldKeepProcessedEventsInFileStore(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "processed.log")
+	store, err := command.NewFileIdempotencyStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions([]command.Option{command.WithIdempotency(store, time.Hour)},
+		countingHandler("test", handlerWasCalled))
+	first := c.Handle(ctx, command.WithIdempotencyKey(command.E{Type: "test"}, "key"))
+	assert.NoError(store.Close(), "no error should be returned")
+
+	store, err = command.NewFileIdempotencyStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	assert.NoError(store.Compact(), "no error should be returned")
+
+	c, _ = command.NewWithOptions([]command.Option{command.WithIdempotency(store, time.Hour)},
+		countingHandler("test", handlerWasCalled))
+	second := c.Handle(ctx, command.WithIdempotencyKey(command.E{Type: "test"}, "key"))
+	assert.Equal(first.Payload(), second.Payload(), "stored result should be returned after restart")
+	assert.Equal(1, handlerWasCalled.getCount(), "duplicate should not be handled after restart")
+}
+
+func testFileIdempotencyStoreShouldCompactExpiredEvents(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "processed.log")
+	store, err := command.NewFileIdempotencyStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	expired := time.Now().Add(-time.Hour)
+	for i := 0; i < 1000; i++ {
+		err := store.Put(command.ProcessedEvent{Key: fmt.Sprintf("test#%d", i), EventType: "test", Expires: expired})
+		assert.NoError(err, "no error should be returned")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(err, "no error should be returned")
+	assert.True(bytes.Count(b, []byte("\n")) <= 200, "expired events should be compacted away")
+}
+
+func testHandlerShouldDeduplicateByRequestID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithIdempotency(command.NewInMemoryIdempotencyStore(), time.Hour)},
+		countingHandler("test", handlerWasCalled),
+		countingHandler("test_1", handlerWasCalled))
+	handled := make(chan struct{}, 10)
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) { handled <- struct{}{} }, c, 1)
+	ts := httptest.NewServer(jsonrpc.CommandsWorker(w))
+
+	defer ts.Close()
+
+	send := func(body, requestID string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(body))
+		if err != nil {
+			assert.FailNow(err.Error())
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set("Request_id", requestID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			assert.FailNow(err.Error())
+		}
+
+		resp.Body.Close()
+	}
+
+	batch := `[ {"jsonrpc": "2.0", "method": "test", "params": {}},
+				{"jsonrpc": "2.0", "method": "test_1", "params": {}} ]`
+
+	send(notificationRequestBody, "request")
+	send(notificationRequestBody, "request")
+	send(batch, "batch")
+	send(batch, "batch")
+
+	for i := 0; i < 6; i++ {
+		<-handled
+	}
+
+	assert.Equal(3, handlerWasCalled.getCount(), "retried requests should not be handled again")
+
+	send(`[ {"jsonrpc": "2.0", "method": "test", "params": {}},
+			{"jsonrpc": "2.0", "method": "test", "params": {}} ]`, "")
+
+	for i := 0; i < 2; i++ {
+		<-handled
+	}
+
+	assert.Equal(5, handlerWasCalled.getCount(), "requests without request ID should not be deduplicated")
+}
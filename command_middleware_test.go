@@ -0,0 +1,177 @@
+package tinycqs
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandMiddleware(t *testing.T) {
+	t.Run("Middlewares should be applied in registration order", testMiddlewareShouldRespectOrder)
+	t.Run("Middleware should be able to short-circuit Handler", testMiddlewareShouldShortCircuit)
+	t.Run("Middlewares should be applied in HandleOnly", testMiddlewareShouldApplyInHandleOnly)
+	t.Run("Event type middlewares should match dispatched event type", testMiddlewareShouldMatchEventType)
+}
+
+type callRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (cr *callRecorder) record(call string) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.calls = append(cr.calls, call)
+}
+
+func (cr *callRecorder) get() []string {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	return append([]string(nil), cr.calls...)
+}
+
+func recordingMiddleware(recorder *callRecorder, name string) command.Middleware {
+	return command.MiddlewareFunc(
+		func(ctx context.Context, w command.EventWriter, e command.Event, next command.Handler) {
+			recorder.record(name + ":" + e.EventType())
+			next.Handle(ctx, w, e)
+		})
+}
+
+func testMiddlewareShouldRespectOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	recorder := &callRecorder{}
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			recorder.record("handler:test_1")
+			r.Write(command.E{Type: "test_2"})
+		}}
+	handler2 := &command.BaseHandler{
+		Type: "test_2",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			recorder.record("handler:test_2")
+		}}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{
+			command.WithEventTypeMiddleware("test_2", recordingMiddleware(recorder, "typed")),
+			command.WithMiddleware(
+				recordingMiddleware(recorder, "first"),
+				recordingMiddleware(recorder, "second")),
+		},
+		handler1,
+		handler2,
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(
+		[]string{
+			"first:test_1", "second:test_1", "handler:test_1",
+			"first:test_2", "second:test_2", "typed:test_2", "handler:test_2"},
+		recorder.get(),
+		"middlewares should be called in order")
+}
+
+func testMiddlewareShouldShortCircuit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return nil
+	}
+	deny := command.MiddlewareFunc(
+		func(ctx context.Context, w command.EventWriter, e command.Event, next command.Handler) {
+			defer w.Done()
+
+			w.Write(command.NewErrEvent(e, context.Canceled))
+		})
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithEventTypeMiddleware("test_1", deny)},
+		command.HandlerFunc("test_1", handlerFunc),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Equal(0, handlerWasCalled.getCount(), "handler should not have been called")
+}
+
+func testMiddlewareShouldApplyInHandleOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	recorder := &callRecorder{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		return nil
+	}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(recordingMiddleware(recorder, "global"))},
+		command.HandlerFunc("test_1", handlerFunc),
+	)
+
+	ev := c.HandleOnly(ctx, command.E{Type: "test_1"}, "test_1")
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal([]string{"global:test_1"}, recorder.get(), "middleware should be called")
+}
+
+func testMiddlewareShouldMatchEventType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	recorder := &callRecorder{}
+	handler := func(eventType string) command.Handler {
+		return &command.BaseHandler{
+			Type: eventType,
+			HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+				defer r.Done()
+
+				recorder.record(eventType + ":" + e.EventType())
+			}}
+	}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{
+			command.WithEventTypeMiddleware("user.created", recordingMiddleware(recorder, "created")),
+			command.WithEventTypeMiddleware("order.*", recordingMiddleware(recorder, "order")),
+		},
+		handler("user.*"),
+		handler("order.placed"),
+	)
+
+	for _, eventType := range []string{"user.created", "user.deleted", "order.placed"} {
+		ev := c.Handle(ctx, command.E{Type: eventType})
+		assert.NoError(ev.Err(), "no error should be returned")
+	}
+
+	assert.Equal(
+		[]string{
+			"created:user.created", "user.*:user.created",
+			"user.*:user.deleted",
+			"order:order.placed", "order.placed:order.placed"},
+		recorder.get(),
+		"middlewares should be applied to matching event types only")
+}
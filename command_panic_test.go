@@ -0,0 +1,97 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandPanic(t *testing.T) {
+	t.Run("Handler panic should be returned as error", testPanicShouldBeReturnedAsError)
+	t.Run("Handler panic should be routed to error handler", testPanicShouldBeRoutedToErrorHandler)
+	t.Run("Handler panic should be recovered in HandleOnly", testPanicShouldBeRecoveredInHandleOnly)
+}
+
+func testPanicShouldBeReturnedAsError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			r.Write(command.E{Type: "test_2"})
+		}}
+	handler2 := &command.BaseHandler{
+		Type: "test_2",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			panic("boom")
+		}}
+
+	c, _ := command.New(handler1, handler2)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	panicked := new(command.ErrHandlerPanicked)
+	if assert.Len(inner, 1, "one error should be returned") &&
+		assert.True(errors.As(inner[0], &panicked), "error should wrap *command.ErrHandlerPanicked") {
+		assert.Equal("boom", panicked.Value, "panic value should be preserved")
+		assert.Equal("test_2", panicked.Event.EventType(), "offending event should be preserved")
+		assert.NotEmpty(panicked.Stack, "stack trace should be preserved")
+	}
+}
+
+func testPanicShouldBeRoutedToErrorHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			panic(errors.New("boom"))
+		}}
+	errHandlerWasCalled := &wasCalledCounter{}
+	handlerErr := &command.BaseHandler{
+		Type: command.ErrorEventType("test_1"),
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			if errors.As(e.Err(), new(*command.ErrHandlerPanicked)) {
+				errHandlerWasCalled.increase()
+			}
+		}}
+
+	c, _ := command.New(handler, handlerErr)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(1, errHandlerWasCalled.getCount(), "error handler should have been called once")
+}
+
+func testPanicShouldBeRecoveredInHandleOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		panic("boom")
+	}
+
+	c, _ := command.New(command.HandlerFunc("test_1", handlerFunc))
+
+	ev := c.HandleOnly(ctx, command.E{Type: "test_1"}, "test_1")
+	assert.True(errors.As(ev.Err(), new(*command.ErrHandlerPanicked)), "error should wrap *command.ErrHandlerPanicked")
+}
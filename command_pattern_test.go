@@ -0,0 +1,168 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandPatterns(t *testing.T) {
+	t.Run("Event type should match patterns", testEventTypeShouldMatchPatterns)
+	t.Run("Events should be routed to pattern subscribers", testEventsShouldBeRoutedToPatterns)
+	t.Run("Exact subscription should win over pattern", testExactSubscriptionShouldWin)
+	t.Run("Error pattern should win over catch all error handler", testErrorPatternShouldWinOverCatchAll)
+	t.Run("Done events should be routed to done subscribers", testDoneEventsShouldBeRouted)
+	t.Run("HandleOnly should accept patterns", testHandleOnlyShouldAcceptPatterns)
+}
+
+func testEventTypeShouldMatchPatterns(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(command.MatchEventType("user.*", "user.created"), "pattern should match")
+	assert.True(command.MatchEventType("*.created", "user.created"), "pattern should match")
+	assert.True(command.MatchEventType("user.*.v*", "user.created.v2"), "pattern should match")
+	assert.False(command.MatchEventType("user.*", "order.created"), "pattern should not match")
+	assert.False(command.MatchEventType("*", "ERROR#user.created"), "plain pattern should not match errors")
+	assert.True(command.MatchEventType("ERROR#user.*", "ERROR#user.created"), "error pattern should match")
+	assert.False(command.MatchEventType("ERROR#*", "DONE#user.created"), "error pattern should not match done events")
+	assert.True(command.MatchEventType("DONE#*", "DONE#user.created"), "done pattern should match")
+}
+
+func testEventsShouldBeRoutedToPatterns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			r.Write(command.E{Type: "user.created"})
+			r.Write(command.E{Type: "user.deleted"})
+		}}
+	userWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		userWasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(handler1, command.HandlerFunc("user.*", handlerFunc))
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(2, userWasCalled.getCount(), "pattern handler should have been called twice")
+}
+
+func testExactSubscriptionShouldWin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	recorder := &callRecorder{}
+	record := func(name string) func(context.Context, []byte) error {
+		return func(ctx context.Context, _ []byte) error {
+			recorder.record(name)
+			return nil
+		}
+	}
+
+	c, _ := command.New(
+		command.HandlerFunc("*", record("any")),
+		command.HandlerFunc("user.*", record("user")),
+		command.HandlerFunc("user.created", record("exact")),
+	)
+
+	assert.NoError(c.Handle(ctx, command.E{Type: "user.created"}).Err(), "no error should be returned")
+	assert.NoError(c.Handle(ctx, command.E{Type: "user.deleted"}).Err(), "no error should be returned")
+	assert.NoError(c.Handle(ctx, command.E{Type: "order.created"}).Err(), "no error should be returned")
+	assert.Equal([]string{"exact", "user", "any"}, recorder.get(), "most precise subscription should win")
+}
+
+func testErrorPatternShouldWinOverCatchAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	recorder := &callRecorder{}
+	failing := func(ctx context.Context, _ []byte) error {
+		return errors.New("fail")
+	}
+	errHandler := func(name string) command.Handler {
+		return &command.BaseHandler{
+			Type: name,
+			HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+				defer r.Done()
+
+				recorder.record(name)
+			}}
+	}
+
+	c, _ := command.New(
+		command.HandlerFunc("payment.charge", failing),
+		command.HandlerFunc("order.create", failing),
+		errHandler(command.CatchAllErrorEventType),
+		errHandler("ERROR#payment.*"),
+	)
+
+	assert.NoError(c.Handle(ctx, command.E{Type: "payment.charge"}).Err(), "no error should be returned")
+	assert.NoError(c.Handle(ctx, command.E{Type: "order.create"}).Err(), "no error should be returned")
+	assert.Equal([]string{"ERROR#payment.*", command.CatchAllErrorEventType}, recorder.get(),
+		"error pattern should win over catch all error handler")
+}
+
+func testDoneEventsShouldBeRouted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.Done(e))
+		}}
+	doneWasCalled := &wasCalledCounter{}
+	doneHandler := &command.BaseHandler{
+		Type: "DONE#*",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			if command.IsDone(e, "test_1") {
+				doneWasCalled.increase()
+			}
+		}}
+
+	c, _ := command.New(handler1, doneHandler)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(1, doneWasCalled.getCount(), "done handler should have been called once")
+}
+
+func testHandleOnlyShouldAcceptPatterns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(command.HandlerFunc("user.*", handlerFunc))
+
+	assert.NoError(c.HandleOnly(ctx, command.E{Type: "user.created"}, "user.*").Err(), "no error should be returned")
+	assert.NoError(c.HandleOnly(ctx, command.E{Type: "user.deleted"}, "order.*").Err(), "no error should be returned")
+	assert.Equal(1, handlerWasCalled.getCount(), "handler should have been called once")
+}
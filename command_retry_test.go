@@ -0,0 +1,149 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRetry(t *testing.T) {
+	t.Run("Handler should be retried until it succeeds", testRetryShouldRetryUntilSuccess)
+	t.Run("Handler should report error when attempts are exhausted", testRetryShouldGiveUp)
+	t.Run("Handler should report error with metadata when attempts are exhausted", testRetryShouldGiveUpWithMetadata)
+	t.Run("Handler should not be retried if error is not retryable", testRetryShouldRespectClassifier)
+	t.Run("Backoff should grow exponentially and respect max backoff", testRetryBackoff)
+}
+
+func testRetryShouldRetryUntilSuccess(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			handlerWasCalled.increase()
+			if command.Attempt(ctx) < 3 {
+				r.Write(command.E{Type: "test_2"})
+				r.Write(command.NewErrEvent(e, errors.New("transient")))
+
+				return
+			}
+
+			r.Write(command.E{Type: "test_2"})
+		},
+		Retry: &command.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}}
+	handler2WasCalled := &wasCalledCounter{}
+	handlerFunc2 := func(ctx context.Context, _ []byte) error {
+		handler2WasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(handler1, command.HandlerFunc("test_2", handlerFunc2))
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(3, handlerWasCalled.getCount(), "handler should have been called three times")
+	assert.Equal(1, handler2WasCalled.getCount(), "events of failed attempts should be discarded")
+}
+
+func testRetryShouldGiveUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return errors.New("fail")
+	}
+
+	c, _ := command.New(
+		command.WithRetry(command.HandlerFunc("test_1", handlerFunc),
+			&command.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}))
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Equal(2, handlerWasCalled.getCount(), "handler should have been called twice")
+
+	exhausted := new(command.ErrRetriesExhausted)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	if assert.Len(inner, 1, "one error should be returned") {
+		assert.True(errors.As(inner[0], &exhausted), "error should wrap *command.ErrRetriesExhausted")
+		assert.Equal(2, exhausted.Attempts, "attempts should be reported")
+	}
+}
+
+func testRetryShouldGiveUpWithMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.WithMetadata(command.NewErrEvent(e, errors.New("fail")),
+				tracing.M{EID: "id", ECausationID: "causation", ECorrelationID: "correlation"}))
+		},
+		Retry: &command.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}}
+
+	c, _ := command.New(handler)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+
+	exhausted := new(command.ErrRetriesExhausted)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	if assert.Len(inner, 1, "one error should be returned") {
+		assert.True(errors.As(inner[0], &exhausted), "error should wrap *command.ErrRetriesExhausted")
+		assert.Equal(2, exhausted.Attempts, "attempts should be reported")
+	}
+}
+
+func testRetryShouldRespectClassifier(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	permanent := errors.New("permanent")
+	handlerWasCalled := &wasCalledCounter{}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return permanent
+	}
+
+	c, _ := command.New(
+		command.WithRetry(command.HandlerFunc("test_1", handlerFunc),
+			&command.RetryPolicy{
+				MaxAttempts: 5,
+				Retryable:   func(err error) bool { return !errors.Is(err, permanent) }}))
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Equal(1, handlerWasCalled.getCount(), "handler should have been called once")
+}
+
+func testRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+	policy := &command.RetryPolicy{
+		InitialBackoff: time.Millisecond * 10,
+		MaxBackoff:     time.Millisecond * 50}
+
+	assert.Equal(time.Millisecond*10, policy.Backoff(1), "first backoff should equal initial backoff")
+	assert.Equal(time.Millisecond*20, policy.Backoff(2), "second backoff should be doubled")
+	assert.Equal(time.Millisecond*50, policy.Backoff(4), "backoff should not exceed max backoff")
+}
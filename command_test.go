@@ -55,6 +55,7 @@ func TestCommand(t *testing.T) {
 	t.Run("Command should be able to chain events and return result if *DoneEvent was written in handler",
 		testCommandHandleShouldReturnResultIfDoneEventWasWritten)
 	t.Run("Correlation IDs should be correctly resolved in Metadata", testMetadata)
+	t.Run("MetadataOf should return Metadata of wrapped Event", testMetadataOf)
 	t.Run("Commands should respect concurrency limit",
 		testCommandHandleShouldRespectConcurrencyLimit)
 }
@@ -673,6 +674,17 @@ func testMetadata(t *testing.T) {
 		"causation ID should equal causationID in unwrapped done event")
 }
 
+func testMetadataOf(t *testing.T) {
+	assert := assert.New(t)
+	id := uuid.New().String()
+	event := command.WithIdempotencyKey(command.WithMetadata(command.E{Type: "test"}, tracing.M{EID: id}), "key")
+
+	assert.Equal(id, command.MetadataOf(event).ID(), "Metadata of wrapped event should be returned")
+	assert.NotNil(command.MetadataOf(command.E{Type: "test"}), "empty Metadata should be returned")
+	assert.Equal("", command.MetadataOf(command.WithMetadata(command.E{Type: "test"}, nil)).ID(),
+		"empty Metadata should be returned for nil Metadata")
+}
+
 func testCommandHandleShouldRespectConcurrencyLimit(t *testing.T) {
 	ctx := context.TODO()
 	ctx, cancel := context.WithCancel(ctx)
@@ -0,0 +1,196 @@
+package tinycqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/breaker"
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	t.Run("Slow handler should time out", testSlowHandlerShouldTimeOut)
+	t.Run("Timed out handler writes should be ignored", testTimedOutHandlerWritesShouldBeIgnored)
+	t.Run("Handler timeout should apply in HandleOnly", testHandlerTimeoutShouldApplyInHandleOnly)
+	t.Run("Chain should time out", testChainShouldTimeOut)
+	t.Run("Chain should time out if handler ignores context", testChainShouldTimeOutIfHandlerIgnoresContext)
+	t.Run("Handler wrappers should keep timeout and side effects", testHandlerWrappersShouldKeepTimeoutAndSideEffects)
+}
+
+func testSlowHandlerShouldTimeOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handler1 := chainingHandler("test_1", "test_2")
+	handler2 := &command.BaseHandler{
+		Type: "test_2",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			<-ctx.Done()
+		},
+		Timeout: time.Millisecond * 50}
+
+	c, _ := command.New(handler1, handler2)
+
+	start := time.Now()
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+
+	assert.Less(int64(time.Since(start)), int64(time.Second), "handler should not block chain")
+	assert.True(errors.Is(ev.Err().(*command.ErrAggregatedEvent).Inner()[0], context.DeadlineExceeded),
+		"error should wrap context.DeadlineExceeded")
+}
+
+func testTimedOutHandlerWritesShouldBeIgnored(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			time.Sleep(time.Millisecond * 100)
+			r.Write(command.E{Type: "test_2"})
+		}}
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		handlerWasCalled.increase()
+		return nil
+	}
+
+	c, _ := command.New(
+		command.WithTimeout(handler1, time.Millisecond*20),
+		command.HandlerFunc("test_2", handlerFunc),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "test_1"})
+	time.Sleep(time.Millisecond * 150)
+
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Equal(0, handlerWasCalled.getCount(), "events written after timeout should be ignored")
+}
+
+func testHandlerTimeoutShouldApplyInHandleOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerFunc := func(ctx context.Context, _ []byte) error {
+		time.Sleep(time.Millisecond * 100)
+		return nil
+	}
+
+	c, _ := command.New(command.WithTimeout(command.HandlerFunc("test_1", handlerFunc), time.Millisecond*20))
+
+	ev := c.HandleOnly(ctx, command.E{Type: "test_1"}, "test_1")
+	assert.True(errors.Is(ev.Err(), context.DeadlineExceeded), "error should wrap context.DeadlineExceeded")
+}
+
+func testChainShouldTimeOut(t *testing.T) {
+	assert := assert.New(t)
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			time.Sleep(time.Millisecond * 100)
+			r.Write(command.E{Type: "test_1"})
+		}}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithChainTimeout(time.Millisecond * 250)},
+		handler1,
+	)
+
+	start := time.Now()
+	ev := c.Handle(context.TODO(), command.E{Type: "test_1"})
+
+	assert.Less(int64(time.Since(start)), int64(time.Second), "chain should be stopped")
+	assert.Contains(ev.Err().Error(), "context deadline exceeded", "error should be returned")
+}
+
+func testChainShouldTimeOutIfHandlerIgnoresContext(t *testing.T) {
+	assert := assert.New(t)
+	handler1 := chainingHandler("test_1", "test_2")
+	handler2 := command.HandlerFunc("test_2", func(context.Context, []byte) error {
+		time.Sleep(time.Second * 2)
+		return nil
+	})
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithChainTimeout(time.Millisecond * 100)},
+		handler1, handler2,
+	)
+
+	start := time.Now()
+	ev := c.Handle(context.TODO(), command.E{Type: "test_1"})
+
+	assert.Less(int64(time.Since(start)), int64(time.Millisecond*500), "chain should not wait for handler")
+	if !assert.Error(ev.Err(), "error should be returned") {
+		return
+	}
+
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	assert.Len(inner, 1, "chain timeout should be reported once")
+	assert.True(errors.Is(inner[0], context.DeadlineExceeded), "error should wrap context.DeadlineExceeded")
+}
+
+func testHandlerWrappersShouldKeepTimeoutAndSideEffects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	emailWasCalled := &wasCalledCounter{}
+	email := command.HandlerFunc("user_created", func(context.Context, []byte) error {
+		emailWasCalled.increase()
+		return nil
+	})
+	emailUpdated := command.HandlerFunc("user_updated", func(context.Context, []byte) error {
+		emailWasCalled.increase()
+		return nil
+	})
+	slow := &command.BaseHandler{
+		Type: "user_deleted",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, _ command.Event) {
+			defer r.Done()
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 2):
+			}
+		}}
+
+	b := breaker.New(breaker.Settings{Name: "email", FailureRate: 0.5, Window: 4, CoolDown: time.Hour})
+	limiter := ratelimit.New()
+	c, _ := command.New(
+		command.WithRetry(command.WithSideEffects(email), &command.RetryPolicy{MaxAttempts: 2}),
+		limiter.Command(b.Command(command.WithSideEffects(emailUpdated), nil)),
+		command.WithSideEffects(command.WithTimeout(slow, time.Millisecond*50)))
+
+	ev := c.Handle(command.WithDryRun(ctx), command.E{Type: "user_created"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(0, emailWasCalled.getCount(), "retried handler with side effects should be skipped in dry-run")
+
+	ev = c.Handle(command.WithDryRun(ctx), command.E{Type: "user_updated"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(0, emailWasCalled.getCount(),
+		"rate limited handler with breaker and side effects should be skipped in dry-run")
+
+	start := time.Now()
+	ev = c.Handle(ctx, command.E{Type: "user_deleted"})
+
+	assert.Less(int64(time.Since(start)), int64(time.Second), "handler should not block chain")
+	assert.True(errors.Is(ev.Err().(*command.ErrAggregatedEvent).Inner()[0], context.DeadlineExceeded),
+		"error should wrap context.DeadlineExceeded")
+}
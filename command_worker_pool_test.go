@@ -0,0 +1,188 @@
+package tinycqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandWorkerPool(t *testing.T) {
+	t.Run("Command Worker should limit concurrent chains", testWorkerShouldLimitConcurrentChains)
+	t.Run("Command Worker should limit concurrent chains per event type", testWorkerShouldLimitChainsPerEventType)
+	t.Run("Command Worker should not block other event types by waiting events",
+		testWorkerShouldNotBlockOtherTypesByWaitingEvents)
+	t.Run("Command Worker event sink should resubmit events under concurrency limit",
+		testWorkerSinkShouldResubmitUnderConcurrencyLimit)
+}
+
+func testWorkerShouldLimitConcurrentChains(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	active := &activeHandlersCounter{concurrentCallsLimit: 3}
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(&command.BaseHandler{
+		Type: "test",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+			defer w.Done()
+
+			active.increase(assert)
+			time.Sleep(time.Millisecond * 10)
+			active.decrease()
+			handlerWasCalled.increase()
+		}})
+
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 5,
+		command.WithMaxConcurrentChains(2))
+
+	for i := 0; i < 20; i++ {
+		assert.NoError(w.Handle(command.E{Type: "test"}), "no error should be returned")
+	}
+
+	assert.Eventually(func() bool { return handlerWasCalled.getCount() == 20 },
+		time.Second*2, time.Millisecond*10, "all events should be handled")
+}
+
+func testWorkerShouldLimitChainsPerEventType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	active := &activeHandlersCounter{concurrentCallsLimit: 2}
+	bulkWasCalled := &wasCalledCounter{}
+	urgent := make(chan struct{})
+	c, _ := command.New(
+		&command.BaseHandler{
+			Type: "bulk.reindex",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+				defer w.Done()
+
+				active.increase(assert)
+				<-release
+				active.decrease()
+				bulkWasCalled.increase()
+			}},
+		command.HandlerFunc("payment", func(context.Context, []byte) error {
+			close(urgent)
+			return nil
+		}),
+	)
+
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 10,
+		command.WithMaxConcurrentChains(3),
+		command.WithEventTypeConcurrency("bulk.*", 1))
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(w.Handle(command.E{Type: "bulk.reindex"}), "no error should be returned")
+	}
+
+	assert.NoError(w.Handle(command.E{Type: "payment"}), "no error should be returned")
+
+	select {
+	case <-urgent:
+	case <-time.After(time.Second):
+		assert.FailNow("event of other type should not wait for limited event type")
+	}
+
+	close(release)
+	assert.Eventually(func() bool { return bulkWasCalled.getCount() == 5 },
+		time.Second, time.Millisecond*10, "waiting events should be handled")
+}
+
+func testWorkerShouldNotBlockOtherTypesByWaitingEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	bulkWasCalled := &wasCalledCounter{}
+	urgent := make(chan struct{})
+	c, _ := command.New(
+		&command.BaseHandler{
+			Type: "bulk.reindex",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+				defer w.Done()
+
+				<-release
+				bulkWasCalled.increase()
+			}},
+		command.HandlerFunc("payment", func(context.Context, []byte) error {
+			close(urgent)
+			return nil
+		}),
+	)
+
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 3,
+		command.WithEventTypeConcurrency("bulk.*", 1))
+
+	submitted := 0
+	assert.Eventually(func() bool {
+		if w.TryHandle(command.E{Type: "bulk.reindex"}) == nil {
+			submitted++
+		}
+
+		return submitted == 4
+	}, time.Second, time.Millisecond, "events of limited event type should be accepted")
+	assert.Equal(command.ErrWorkerBusy, w.TryHandle(command.E{Type: "bulk.reindex"}),
+		"events of limited event type should be limited by queue depth")
+
+	assert.NoError(w.Handle(command.E{Type: "payment"}), "no error should be returned")
+
+	select {
+	case <-urgent:
+	case <-time.After(time.Second):
+		assert.FailNow("event of other type should not wait behind waiting events")
+	}
+
+	close(release)
+	assert.Eventually(func() bool { return bulkWasCalled.getCount() == 4 },
+		time.Second, time.Millisecond*10, "waiting events should be handled")
+}
+
+func testWorkerSinkShouldResubmitUnderConcurrencyLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	retryWasCalled := &wasCalledCounter{}
+	c, _ := command.New(
+		command.HandlerFunc("test", func(context.Context, []byte) error { return nil }),
+		command.HandlerFunc("retry", func(context.Context, []byte) error {
+			retryWasCalled.increase()
+			return nil
+		}),
+	)
+
+	resubmitted := make(chan struct{})
+	w := command.NewWorkerWithOptions(ctx, func(w command.CommandsWorker, e command.Event) {
+		if !command.IsDone(e, "test") {
+			return
+		}
+
+		defer close(resubmitted)
+
+		for i := 0; i < 3; i++ {
+			assert.NoError(w.Handle(command.E{Type: "retry"}), "no error should be returned")
+		}
+	}, c, 1, command.WithMaxConcurrentChains(1))
+
+	assert.NoError(w.Handle(command.E{Type: "test"}), "no error should be returned")
+
+	select {
+	case <-resubmitted:
+	case <-time.After(time.Second):
+		assert.FailNow("event sink should not wait for chain limit it holds")
+	}
+
+	assert.Eventually(func() bool { return retryWasCalled.getCount() == 3 },
+		time.Second, time.Millisecond*10, "resubmitted events should be handled")
+}
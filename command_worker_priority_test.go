@@ -0,0 +1,198 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/jsonrpc"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandWorkerPriority(t *testing.T) {
+	t.Run("Priority should be parsed and kept by wrapped events", testPriorityShouldBeKeptByWrappedEvents)
+	t.Run("Command Worker should serve priority lanes by weight", testWorkerShouldServeLanesByWeight)
+	t.Run("Command Worker should put events without lane to the lowest lane", testWorkerShouldPutEventsToLowestLane)
+	t.Run("JSON RPC Handler should pass priority to Command Worker", testHandlerShouldPassPriorityToWorker)
+}
+
+func testPriorityShouldBeKeptByWrappedEvents(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range []string{"low", "Normal", " high ", "5"} {
+		p, err := command.ParsePriority(s)
+		assert.NoError(err, "no error should be returned")
+
+		parsed, err := command.ParsePriority(p.String())
+		assert.NoError(err, "no error should be returned")
+		assert.Equal(p, parsed, "priority should survive round trip")
+	}
+
+	_, err := command.ParsePriority("urgent")
+	assert.Error(err, "unknown priority should return error")
+
+	e := command.E{Type: "test"}
+	assert.Equal(command.PriorityNormal, command.PriorityOf(e), "events have normal priority by default")
+
+	prioritized := command.WithMetadata(command.WithPriority(e, command.PriorityHigh), tracing.M{})
+	assert.Equal(command.PriorityHigh, command.PriorityOf(prioritized), "priority should be kept under metadata")
+	assert.Equal("test", prioritized.EventType(), "event type should be kept")
+}
+
+// returns Commands with "blocked" Handler that reports start of every Event and waits for release to be closed
+// and channel receiving priorities of Events once they are handled.
+func prioritizedCommands(started chan<- struct{}, release <-chan struct{}) (command.Commands, <-chan command.Priority) {
+	handled := make(chan command.Priority, 100)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "blocked",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			started <- struct{}{}
+			<-release
+			handled <- command.PriorityOf(e)
+		}})
+
+	return c, handled
+}
+
+func testWorkerShouldServeLanesByWeight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	started := make(chan struct{}, 100)
+	release := make(chan struct{})
+	c, handled := prioritizedCommands(started, release)
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 10,
+		command.WithMaxConcurrentChains(1),
+		command.WithPriorityLanes(map[command.Priority]int{command.PriorityHigh: 3, command.PriorityLow: 1}))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked"}), "no error should be returned")
+	<-started
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(w.Handle(command.WithPriority(command.E{Type: "blocked"}, command.PriorityLow)),
+			"no error should be returned")
+	}
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(w.Handle(command.WithPriority(command.E{Type: "blocked"}, command.PriorityHigh)),
+			"no error should be returned")
+	}
+
+	close(release)
+
+	got := make([]command.Priority, 0, 9)
+	for i := 0; i < 9; i++ {
+		select {
+		case p := <-handled:
+			got = append(got, p)
+		case <-time.After(time.Second * 2):
+			assert.FailNow("all events should be handled")
+		}
+	}
+
+	low, high := command.PriorityLow, command.PriorityHigh
+	assert.Equal(
+		[]command.Priority{command.PriorityNormal, high, high, low, high, high, low, low, low}, got,
+		"high lane should be served three times as often as low lane")
+}
+
+func testWorkerShouldPutEventsToLowestLane(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	c, handled := blockedCommands(release)
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithPriorityLanes(map[command.Priority]int{command.PriorityHigh: 1, command.PriorityNormal: 1}))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("first")}), "no error should be returned")
+	assert.Eventually(func() bool {
+		return w.TryHandle(command.WithPriority(command.E{Type: "blocked", P: []byte("low")}, command.PriorityLow)) == nil
+	}, time.Second, time.Millisecond*10, "low event should be accepted once first event is started")
+
+	assert.Equal(command.ErrWorkerBusy,
+		w.TryHandle(command.E{Type: "blocked", P: []byte("normal")}),
+		"low and normal events should share normal lane")
+	assert.NoError(w.TryHandle(command.WithPriority(command.E{Type: "blocked", P: []byte("high")}, command.PriorityHigh)),
+		"high lane should have its own room")
+
+	close(release)
+
+	got := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		select {
+		case p := <-handled:
+			got = append(got, p)
+		case <-time.After(time.Second * 2):
+			assert.FailNow("all events should be handled")
+		}
+	}
+
+	assert.ElementsMatch([]string{"first", "low", "high"}, got, "accepted events should be handled")
+}
+
+func testHandlerShouldPassPriorityToWorker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handled := make(chan command.Priority, 10)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "test",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			handled <- command.PriorityOf(e)
+		}})
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, 1)
+	ts := httptest.NewServer(jsonrpc.CommandsWorker(w))
+
+	defer ts.Close()
+
+	send := func(body string, priority string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString(body))
+		if err != nil {
+			assert.FailNow(err.Error())
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if priority != "" {
+			req.Header.Set(jsonrpc.PriorityHeader, priority)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			assert.FailNow(err.Error())
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	assert.Equal(http.StatusNoContent, send(notificationRequestBody, "high"), "notification should be accepted")
+	assert.Equal(command.PriorityHigh, <-handled, "priority should be taken from header")
+
+	body := `{"jsonrpc": "2.0", "method": "test", "params": {}, "priority": "low"}`
+	assert.Equal(http.StatusNoContent, send(body, "high"), "notification should be accepted")
+	assert.Equal(command.PriorityLow, <-handled, "request priority should take precedence over header")
+
+	assert.Equal(http.StatusNoContent, send(notificationRequestBody, "u=1, i"), "notification should be accepted")
+	assert.Equal(command.PriorityNormal, <-handled, "unknown header priority should be ignored")
+
+	body = `{"jsonrpc": "2.0", "method": "test", "params": {}, "priority": "urgent"}`
+	assert.Equal(http.StatusBadRequest, send(body, ""), "unknown request priority should be rejected")
+}
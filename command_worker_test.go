@@ -2,7 +2,10 @@ package tinycqs
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,6 +15,19 @@ import (
 
 func TestCommandWorker(t *testing.T) {
 	t.Run("Command Worker should start and Handle commands", testWorkerShouldStartAndHandleCommands)
+	t.Run("Command Worker should drain chains in flight on Shutdown", testWorkerShouldDrainOnShutdown)
+	t.Run("Command Worker should interrupt chains at drain deadline", testWorkerShouldInterruptAtDrainDeadline)
+	t.Run("Command Worker should accept events without blocking", testWorkerShouldAcceptEventsWithoutBlocking)
+	t.Run("Command Worker should fail fast when busy", testWorkerShouldFailFastWhenBusy)
+	t.Run("Command Worker should stop while events wait for room", testWorkerShouldStopWhileEventsWaitForRoom)
+	t.Run("Command Worker should drop oldest event on overflow", testWorkerShouldDropOldestOnOverflow)
+	t.Run("Command Worker should not spin dropping oldest events under concurrent overflow",
+		testWorkerShouldDropOldestConcurrently)
+	t.Run("Command Worker should spill events on overflow", testWorkerShouldSpillOnOverflow)
+	t.Run("Command Worker should report events spilled to memory dropped on Shutdown",
+		testWorkerShouldReportSpilledToMemoryDropped)
+	t.Run("Durable worker should report events interrupted in memory queue dropped on Shutdown",
+		testDurableWorkerShouldReportInterruptedInMemoryDropped)
 }
 
 func testWorkerShouldStartAndHandleCommands(t *testing.T) {
@@ -77,3 +93,433 @@ func testWorkerShouldStartAndHandleCommands(t *testing.T) {
 	assert.Equal(6, handler2WasCalled.getCount(), "second handler should have been called three times")
 	assert.Equal(8, handler3WasCalled.getCount(), "third handler should have been called four times")
 }
+
+func testWorkerShouldDrainOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	started := make(chan struct{})
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(&command.BaseHandler{
+		Type: "test",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, _ command.Event) {
+			defer w.Done()
+
+			close(started)
+			time.Sleep(time.Millisecond * 50)
+
+			if ctx.Err() == nil {
+				handlerWasCalled.increase()
+			}
+		}})
+
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, 1)
+	assert.NoError(w.Handle(command.E{Type: "test"}), "no error should be returned")
+
+	<-started
+
+	report, err := w.Shutdown(context.TODO())
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.DrainReport{Completed: 1}, report, "chain in flight should be completed")
+	assert.Equal(1, handlerWasCalled.getCount(), "chain should not be cancelled")
+	assert.False(w.IsRunning(), "worker should be stopped")
+	assert.Equal(command.WorkerStopped, w.Handle(command.E{Type: "test"}), "stopped worker should not accept events")
+
+	_, err = w.Shutdown(context.TODO())
+	assert.Equal(command.WorkerStopped, err, "worker should be stopped only once")
+}
+
+func testWorkerShouldInterruptAtDrainDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	started := make(chan struct{}, 2)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "test",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			started <- struct{}{}
+			<-ctx.Done()
+			w.Write(command.NewErrEvent(e, ctx.Err()))
+		}})
+
+	queue := command.NewInMemoryQueue()
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithShutdownQueue(queue))
+
+	assert.NoError(w.Handle(command.E{Type: "test", P: []byte("1")}), "no error should be returned")
+	assert.NoError(w.Handle(command.E{Type: "test", P: []byte("2")}), "no error should be returned")
+
+	<-started
+	<-started
+
+	drainCtx, cancelDrain := context.WithTimeout(context.TODO(), time.Millisecond*50)
+
+	defer cancelDrain()
+
+	report, err := w.Shutdown(drainCtx)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.DrainReport{Interrupted: 2, Persisted: 2}, report, "chains in flight should be interrupted")
+
+	payloads := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		_, event, err := queue.Dequeue(context.TODO())
+		assert.NoError(err, "no error should be returned")
+		payloads = append(payloads, string(event.Payload()))
+	}
+
+	assert.ElementsMatch([]string{"1", "2"}, payloads, "interrupted events should be persisted")
+}
+
+func testWorkerShouldAcceptEventsWithoutBlocking(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(command.HandlerFunc("test", func(context.Context, []byte) error {
+		handlerWasCalled.increase()
+		return nil
+	}))
+
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, 1)
+	assert.NoError(w.TryHandle(command.E{Type: "test"}), "no error should be returned")
+
+	handleCtx, cancelHandle := context.WithCancel(context.TODO())
+	assert.NoError(w.HandleContext(handleCtx, command.E{Type: "test"}), "no error should be returned")
+
+	cancelHandle()
+	assert.Equal(context.Canceled, w.HandleContext(handleCtx, command.E{Type: "test"}),
+		"event should not be accepted after context is done")
+
+	assert.Eventually(func() bool { return handlerWasCalled.getCount() == 2 },
+		time.Second, time.Millisecond*10, "accepted events should be handled")
+
+	_, err := w.Shutdown(context.TODO())
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.WorkerStopped, w.TryHandle(command.E{Type: "test"}), "stopped worker should not accept events")
+}
+
+// returns Commands with "blocked" Handler waiting for release to be closed
+// and channel receiving payloads of Events once they are handled.
+func blockedCommands(release <-chan struct{}) (command.Commands, <-chan string) {
+	handled := make(chan string, 100)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "blocked",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			<-release
+			handled <- string(e.Payload())
+		}})
+
+	return c, handled
+}
+
+func testWorkerShouldFailFastWhenBusy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+
+	defer close(release)
+
+	c, handled := blockedCommands(release)
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithOverflowPolicy(command.OverflowDropNewest))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+	assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+		time.Second, time.Millisecond*10, "event should be queued")
+
+	assert.Equal(command.ErrWorkerBusy, w.TryHandle(command.E{Type: "blocked", P: []byte("3")}),
+		"busy worker should fail fast")
+	assert.Equal(command.ErrWorkerBusy, w.Handle(command.E{Type: "blocked", P: []byte("3")}),
+		"newest event should be dropped")
+
+	handleCtx, cancelHandle := context.WithTimeout(context.TODO(), time.Millisecond*20)
+
+	defer cancelHandle()
+
+	w = command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithMaxConcurrentChains(1))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+	assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+		time.Second, time.Millisecond*10, "event should be queued")
+	assert.Equal(context.DeadlineExceeded, w.HandleContext(handleCtx, command.E{Type: "blocked"}),
+		"blocked submission should honour deadline")
+
+	select {
+	case <-handled:
+		assert.Fail("no events should be handled")
+	default:
+	}
+}
+
+func testWorkerShouldStopWhileEventsWaitForRoom(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+
+	defer close(release)
+
+	c, _ := blockedCommands(release)
+
+	// returns worker with full queue and result of Handle waiting for room
+	fill := func(ctx context.Context) (command.CommandsWorker, <-chan error) {
+		w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+			command.WithMaxConcurrentChains(1))
+
+		assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+		assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+			time.Second, time.Millisecond*10, "event should be queued")
+
+		result := make(chan error, 1)
+		go func() { result <- w.Handle(command.E{Type: "blocked", P: []byte("3")}) }()
+
+		return w, result
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	_, result := fill(ctx)
+
+	cancel()
+
+	select {
+	case err := <-result:
+		assert.Equal(command.WorkerStopped, err, "waiting event should not be accepted")
+	case <-time.After(time.Second):
+		assert.FailNow("Handle should return once worker context is done")
+	}
+
+	ctx, cancel = context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	w, result := fill(ctx)
+	go w.Shutdown(context.TODO())
+
+	select {
+	case err := <-result:
+		assert.Equal(command.WorkerStopped, err, "waiting event should not be accepted")
+	case <-time.After(time.Second):
+		assert.FailNow("Handle should return once worker is shut down")
+	}
+}
+
+func testWorkerShouldDropOldestOnOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	c, handled := blockedCommands(release)
+
+	var mu sync.Mutex
+	dropped := make([]string, 0, 1)
+	sink := func(_ command.CommandsWorker, e command.Event) {
+		if errors.Is(e.Err(), command.ErrEventDropped) {
+			mu.Lock()
+			dropped = append(dropped, string(e.Payload()))
+			mu.Unlock()
+		}
+	}
+
+	w := command.NewWorkerWithOptions(ctx, sink, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithOverflowPolicy(command.OverflowDropOldest))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+	assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+		time.Second, time.Millisecond*10, "event should be queued")
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("3")}), "no error should be returned")
+
+	close(release)
+
+	assert.Equal("1", <-handled)
+	assert.Equal("3", <-handled)
+
+	mu.Lock()
+	assert.Equal([]string{"2"}, dropped, "oldest queued event should be dropped")
+	mu.Unlock()
+}
+
+func testWorkerShouldDropOldestConcurrently(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	c, handled := blockedCommands(release)
+
+	var dropped int64
+	sink := func(_ command.CommandsWorker, e command.Event) {
+		if errors.Is(e.Err(), command.ErrEventDropped) {
+			atomic.AddInt64(&dropped, 1)
+		}
+	}
+
+	w := command.NewWorkerWithOptions(ctx, sink, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithOverflowPolicy(command.OverflowDropOldest))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("0")}), "no error should be returned")
+
+	const producers = 50
+
+	var (
+		wg   sync.WaitGroup
+		busy int64
+	)
+
+	for i := 1; i <= producers; i++ {
+		wg.Add(1)
+
+		go func(p string) {
+			defer wg.Done()
+
+			if err := w.Handle(command.E{Type: "blocked", P: []byte(p)}); err != nil {
+				assert.Equal(command.ErrWorkerBusy, err, "only ErrWorkerBusy should be returned")
+				atomic.AddInt64(&busy, 1)
+			}
+		}(strconv.Itoa(i))
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		assert.FailNow("concurrent Handle calls should return")
+	}
+
+	close(release)
+
+	accounted := func() bool {
+		return int64(len(handled))+atomic.LoadInt64(&dropped)+atomic.LoadInt64(&busy) == producers+1
+	}
+	assert.Eventually(accounted, time.Second, time.Millisecond*10,
+		"every event should be handled, dropped or rejected")
+}
+
+func testWorkerShouldSpillOnOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+	c, handled := blockedCommands(release)
+
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithSpillQueue(command.NewInMemoryQueue()))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+	assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+		time.Second, time.Millisecond*10, "event should be queued")
+
+	for _, p := range []string{"3", "4", "5"} {
+		assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte(p)}), "no error should be returned")
+	}
+
+	close(release)
+
+	payloads := make([]string, 0, 5)
+	for i := 0; i < 5; i++ {
+		select {
+		case p := <-handled:
+			payloads = append(payloads, p)
+		case <-time.After(time.Second):
+			assert.FailNow("spilled events should be handled")
+		}
+	}
+
+	assert.Equal([]string{"1", "2", "3", "4", "5"}, payloads, "spilled events should be handled in order")
+}
+
+func testWorkerShouldReportSpilledToMemoryDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "blocked",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			<-ctx.Done()
+		}})
+
+	w := command.NewWorkerWithOptions(ctx, func(command.CommandsWorker, command.Event) {}, c, 1,
+		command.WithMaxConcurrentChains(1),
+		command.WithSpillQueue(command.NewInMemoryQueue()))
+
+	assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte("1")}), "no error should be returned")
+	assert.Eventually(func() bool { return w.TryHandle(command.E{Type: "blocked", P: []byte("2")}) == nil },
+		time.Second, time.Millisecond*10, "event should be queued")
+
+	for _, p := range []string{"3", "4"} {
+		assert.NoError(w.Handle(command.E{Type: "blocked", P: []byte(p)}), "no error should be returned")
+	}
+
+	drainCtx, cancelDrain := context.WithTimeout(context.TODO(), time.Millisecond*20)
+
+	defer cancelDrain()
+
+	report, err := w.Shutdown(drainCtx)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.DrainReport{Interrupted: 1, Pending: 3, Dropped: 4}, report,
+		"events spilled to memory should be dropped")
+}
+
+func testDurableWorkerShouldReportInterruptedInMemoryDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	started := make(chan struct{}, 1)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "test",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			started <- struct{}{}
+			<-ctx.Done()
+			w.Write(command.NewErrEvent(e, ctx.Err()))
+		}})
+
+	w := command.NewDurableWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, command.NewInMemoryQueue(), 1)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(w.Handle(command.E{Type: "test"}), "no error should be returned")
+	}
+
+	<-started
+
+	drainCtx, cancelDrain := context.WithTimeout(context.TODO(), time.Millisecond*50)
+
+	defer cancelDrain()
+
+	report, err := w.Shutdown(drainCtx)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.DrainReport{Interrupted: 1, Pending: 2, Dropped: 3}, report,
+		"events removed from memory queue and left in it should be reported dropped")
+}
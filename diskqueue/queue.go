@@ -0,0 +1,444 @@
+// Package diskqueue implements durable command.Queue
+// as write-ahead log of segment files with acknowledgement tracking.
+package diskqueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+const (
+	segmentExt = ".seg"
+	acksFile   = "acks.log"
+)
+
+// Returned by Queue methods after Queue was closed.
+var QueueClosed = errors.New("disk queue is closed")
+
+// Returns Queue stored in dir.
+// Events not acknowledged before Queue was closed are dequeued again in order they were enqueued.
+func Open(dir string, options Options) (*Queue, error) {
+	if options.SegmentSize < 1 {
+		options.SegmentSize = defaultSegmentSize
+	}
+
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = defaultSyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:     dir,
+		options: options,
+		next:    1,
+		acked:   make(map[int64]bool),
+		notify:  make(chan struct{}),
+		stop:    make(chan struct{})}
+
+	if err := q.load(); err != nil {
+		q.close()
+
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval {
+		go q.syncEvery(options.SyncInterval)
+	}
+
+	return q, nil
+}
+
+// *Queue implements command.Queue.
+type Queue struct {
+	mu sync.Mutex
+
+	dir     string
+	options Options
+
+	segments []*segment
+	acks     *filelog.Log
+	// offsets acknowledged in live segments
+	acked map[int64]bool
+	// offset of next enqueued Event
+	next int64
+	// Events not dequeued yet in order they were enqueued
+	ready []record
+
+	// closed and replaced on every Enqueue to wake up Dequeue
+	notify chan struct{}
+	stop   chan struct{}
+	closed bool
+}
+
+// segment file holding Events with offsets starting from first.
+type segment struct {
+	first int64
+	count int
+	// amount of not acknowledged Events
+	pending int
+	log     *filelog.Log
+}
+
+// Returns directory Queue is stored in.
+func (q *Queue) Dir() string {
+	return q.dir
+}
+
+// Returns amount of Events not acknowledged yet.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for _, s := range q.segments {
+		n += s.pending
+	}
+
+	return n
+}
+
+// Returns amount of Events not dequeued yet.
+func (q *Queue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.ready)
+}
+
+func (q *Queue) Enqueue(event command.EventWithMetadata) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return QueueClosed
+	}
+
+	s, err := q.current()
+	if err != nil {
+		return err
+	}
+
+	r := newRecord(q.next, event)
+	if err := s.log.Append(r); err != nil {
+		return err
+	}
+
+	q.next++
+	s.count++
+	s.pending++
+	q.ready = append(q.ready, r)
+
+	close(q.notify)
+	q.notify = make(chan struct{})
+
+	return nil
+}
+
+func (q *Queue) Dequeue(ctx context.Context) (int64, command.EventWithMetadata, error) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+
+			return 0, nil, QueueClosed
+		}
+
+		if len(q.ready) > 0 {
+			r := q.ready[0]
+			q.ready = q.ready[1:]
+			q.mu.Unlock()
+
+			return r.Offset, r.event(), nil
+		}
+
+		notify := q.notify
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-q.stop:
+		case <-notify:
+		}
+	}
+}
+
+// Acknowledges Event with offset.
+// Segment files with every Event acknowledged are removed.
+func (q *Queue) Ack(offset int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return QueueClosed
+	}
+
+	i := q.segmentOf(offset)
+	if i < 0 || q.acked[offset] {
+		return nil
+	}
+
+	if err := q.acks.Append(ack{offset}); err != nil {
+		return err
+	}
+
+	q.acked[offset] = true
+	q.segments[i].pending--
+
+	return q.removeAcked()
+}
+
+// Stops Queue and closes its files.
+// Blocked Dequeue calls return QueueClosed.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return nil
+	}
+
+	return q.close()
+}
+
+func (q *Queue) close() error {
+	q.closed = true
+	close(q.stop)
+
+	var err error
+	for _, s := range q.segments {
+		if e := s.log.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	if q.acks != nil {
+		if e := q.acks.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// restores segments, acknowledgements and not acknowledged Events from dir.
+func (q *Queue) load() error {
+	acks, err := filelog.Open(filepath.Join(q.dir, acksFile), q.options.Sync == SyncAlways)
+	if err != nil {
+		return err
+	}
+
+	q.acks = acks
+	err = acks.ReadAll(func(b json.RawMessage) error {
+		var a ack
+		if err := json.Unmarshal(b, &a); err != nil {
+			return err
+		}
+
+		q.acked[a.Offset] = true
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	names, err := filepath.Glob(filepath.Join(q.dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+
+	firsts := make([]int64, 0, len(names))
+	for _, name := range names {
+		first, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: unexpected segment file name", name)
+		}
+
+		firsts = append(firsts, first)
+	}
+
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+
+	for _, first := range firsts {
+		s, err := q.openSegment(first)
+		if err != nil {
+			return err
+		}
+
+		q.segments = append(q.segments, s)
+		err = s.log.ReadAll(func(b json.RawMessage) error {
+			var r record
+			if err := json.Unmarshal(b, &r); err != nil {
+				return err
+			}
+
+			s.count++
+			if r.Offset >= q.next {
+				q.next = r.Offset + 1
+			}
+
+			if !q.acked[r.Offset] {
+				s.pending++
+				q.ready = append(q.ready, r)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// acknowledgements of removed segments can be left if Queue stopped while removing them,
+	// offsets are never reused so they are not mistaken for acknowledgements of new Events
+	stale := false
+	for offset := range q.acked {
+		if offset >= q.next {
+			q.next = offset + 1
+		}
+
+		if q.segmentOf(offset) < 0 {
+			delete(q.acked, offset)
+			stale = true
+		}
+	}
+
+	if stale {
+		if err := q.rewriteAcks(); err != nil {
+			return err
+		}
+	}
+
+	return q.removeAcked()
+}
+
+// returns segment new Events are appended to.
+func (q *Queue) current() (*segment, error) {
+	if n := len(q.segments); n > 0 && q.segments[n-1].count < q.options.SegmentSize {
+		return q.segments[n-1], nil
+	}
+
+	s, err := q.openSegment(q.next)
+	if err != nil {
+		return nil, err
+	}
+
+	q.segments = append(q.segments, s)
+
+	return s, nil
+}
+
+func (q *Queue) openSegment(first int64) (*segment, error) {
+	name := filepath.Join(q.dir, fmt.Sprintf("%020d%s", first, segmentExt))
+	log, err := filelog.Open(name, q.options.Sync == SyncAlways)
+	if err != nil {
+		return nil, err
+	}
+
+	return &segment{first: first, log: log}, nil
+}
+
+// returns index of segment holding Event with offset or -1.
+func (q *Queue) segmentOf(offset int64) int {
+	for i := len(q.segments) - 1; i >= 0; i-- {
+		s := q.segments[i]
+		if offset >= s.first && offset < s.first+int64(s.count) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// removes full segments with every Event acknowledged
+// and rewrites acks file keeping only acknowledgements of live segments.
+// Acks file is rewritten before segment files are removed,
+// so acknowledgements never outlive segments they refer to.
+func (q *Queue) removeAcked() error {
+	n := 0
+	for ; n < len(q.segments); n++ {
+		if s := q.segments[n]; s.pending > 0 || s.count < q.options.SegmentSize {
+			break
+		}
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	for _, s := range q.segments[:n] {
+		for offset := s.first; offset < s.first+int64(s.count); offset++ {
+			delete(q.acked, offset)
+		}
+	}
+
+	if err := q.rewriteAcks(); err != nil {
+		return err
+	}
+
+	for ; n > 0; n-- {
+		s := q.segments[0]
+		if err := s.log.Close(); err != nil {
+			return err
+		}
+
+		if err := os.Remove(s.log.Path()); err != nil {
+			return err
+		}
+
+		q.segments = q.segments[1:]
+	}
+
+	return nil
+}
+
+// rewrites acks file keeping only acknowledgements known to Queue.
+func (q *Queue) rewriteAcks() error {
+	acks := make([]interface{}, 0, len(q.acked))
+	for offset := range q.acked {
+		acks = append(acks, ack{offset})
+	}
+
+	return q.acks.Rewrite(acks...)
+}
+
+// flushes files to disk every interval until Queue is closed.
+func (q *Queue) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-ticker.C:
+			q.mu.Lock()
+			if !q.closed {
+				q.acks.Sync()
+				for _, s := range q.segments {
+					s.log.Sync()
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package diskqueue
+
+import (
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	eventrecord "github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+// SyncPolicy decides when Queue files are flushed to disk.
+type SyncPolicy int
+
+const (
+	// Every Enqueue and Ack is flushed to disk before returning.
+	SyncAlways SyncPolicy = iota
+	// Files are flushed to disk every Options.SyncInterval.
+	SyncInterval
+	// Flushing is left to operating system.
+	SyncNever
+)
+
+const (
+	defaultSegmentSize  = 1000
+	defaultSyncInterval = time.Second
+)
+
+// Options of Queue.
+type Options struct {
+	// Maximum amount of Events in single segment file. Defaults to 1000.
+	SegmentSize int
+	Sync        SyncPolicy
+	// Interval of SyncInterval policy. Defaults to 1 second.
+	SyncInterval time.Duration
+}
+
+// Event stored in segment file.
+type record struct {
+	Offset int64 `json:"offset"`
+
+	eventrecord.E
+	// Priority of Event if it is not command.PriorityNormal.
+	Priority *command.Priority `json:"priority,omitempty"`
+	// Idempotency key of Event if it differs from ID.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+func newRecord(offset int64, event command.EventWithMetadata) record {
+	r := record{Offset: offset, E: eventrecord.New(event, event.Metadata())}
+	if priority := command.PriorityOf(event); priority != command.PriorityNormal {
+		r.Priority = &priority
+	}
+
+	if key := command.IdempotencyKeyOf(event); key != r.ID {
+		r.IdempotencyKey = key
+	}
+
+	return r
+}
+
+func (r record) event() command.EventWithMetadata {
+	recorded := r.E.Event()
+
+	var event command.Event = recorded
+	if r.Priority != nil {
+		event = command.WithPriority(event, *r.Priority)
+	}
+
+	if r.IdempotencyKey != "" {
+		event = command.WithIdempotencyKey(event, r.IdempotencyKey)
+	}
+
+	return command.WithMetadata(event, recorded.Metadata())
+}
+
+// Acknowledgement stored in acks file.
+type ack struct {
+	Offset int64 `json:"ack"`
+}
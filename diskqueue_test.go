@@ -0,0 +1,261 @@
+package tinycqs
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/diskqueue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskQueue(t *testing.T) {
+	t.Run("Disk queue should resume not acknowledged events in order", testDiskQueueShouldResumeInOrder)
+	t.Run("Disk queue should remove acknowledged segments", testDiskQueueShouldRemoveAcknowledgedSegments)
+	t.Run("Disk queue should not skip new events after crash while removing segments",
+		testDiskQueueShouldNotSkipEventsAfterCrashWhileRemovingSegments)
+	t.Run("Durable worker should handle events left after restart", testDurableWorkerShouldResumeAfterRestart)
+	t.Run("Durable worker should report events left in queue on shutdown", testDurableWorkerShouldReportEventsLeftInQueue)
+}
+
+func testDiskQueueShouldResumeInOrder(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	dir := tempDir(t)
+
+	q, err := diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	for _, eventType := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(q.Enqueue(command.WithMetadata(command.E{Type: eventType}, nil)))
+	}
+
+	for i := 0; i < 3; i++ {
+		offset, _, err := q.Dequeue(ctx)
+		assert.NoError(err, "no error should be returned")
+
+		if i != 1 {
+			assert.NoError(q.Ack(offset), "no error should be returned")
+		}
+	}
+
+	assert.NoError(q.Close())
+
+	q, err = diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer q.Close()
+
+	assert.Equal(3, q.Len(), "not acknowledged events should be restored")
+
+	eventTypes := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		_, event, err := q.Dequeue(ctx)
+		assert.NoError(err, "no error should be returned")
+		eventTypes = append(eventTypes, event.EventType())
+	}
+
+	assert.Equal([]string{"b", "d", "e"}, eventTypes, "events should be resumed in order")
+}
+
+func testDiskQueueShouldRemoveAcknowledgedSegments(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	dir := tempDir(t)
+
+	q, err := diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2, Sync: diskqueue.SyncNever})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer q.Close()
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(q.Enqueue(command.WithMetadata(command.E{Type: "a"}, nil)))
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(segments, 3, "events should be split into segments")
+
+	for i := 0; i < 4; i++ {
+		offset, _, _ := q.Dequeue(ctx)
+		assert.NoError(q.Ack(offset), "no error should be returned")
+	}
+
+	segments, _ = filepath.Glob(filepath.Join(dir, "*.seg"))
+	assert.Len(segments, 1, "acknowledged segments should be removed")
+	assert.Equal(1, q.Len(), "one event should be pending")
+}
+
+func testDiskQueueShouldNotSkipEventsAfterCrashWhileRemovingSegments(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	dir := tempDir(t)
+
+	q, err := diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	for _, eventType := range []string{"a", "b"} {
+		assert.NoError(q.Enqueue(command.WithMetadata(command.E{Type: eventType}, nil)))
+	}
+
+	for i := 0; i < 2; i++ {
+		offset, _, _ := q.Dequeue(ctx)
+		assert.NoError(q.Ack(offset), "no error should be returned")
+	}
+
+	assert.NoError(q.Close())
+
+	// simulate crash after segment was removed, but before acks file was rewritten
+	if err := ioutil.WriteFile(filepath.Join(dir, "acks.log"), []byte("{\"ack\":1}\n{\"ack\":2}\n"), 0644); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	q, err = diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	for _, eventType := range []string{"c", "d"} {
+		assert.NoError(q.Enqueue(command.WithMetadata(command.E{Type: eventType}, nil)))
+	}
+
+	assert.NoError(q.Close())
+
+	q, err = diskqueue.Open(dir, diskqueue.Options{SegmentSize: 2})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer q.Close()
+
+	if !assert.Equal(2, q.Len(), "events enqueued after crash should be pending") {
+		return
+	}
+
+	eventTypes := make([]string, 0, 2)
+	for i := 0; i < 2; i++ {
+		_, event, err := q.Dequeue(ctx)
+		assert.NoError(err, "no error should be returned")
+		eventTypes = append(eventTypes, event.EventType())
+	}
+
+	assert.Equal([]string{"c", "d"}, eventTypes, "events enqueued after crash should be resumed")
+}
+
+func testDurableWorkerShouldResumeAfterRestart(t *testing.T) {
+	assert := assert.New(t)
+	dir := tempDir(t)
+
+	q, err := diskqueue.Open(dir, diskqueue.Options{})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	started := make(chan struct{}, 1)
+	stuck, _ := command.New(&command.BaseHandler{
+		Type: "job",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			started <- struct{}{}
+			<-ctx.Done()
+			w.Write(command.NewErrEvent(e, ctx.Err()))
+		}})
+
+	w := command.NewDurableWorker(ctx, func(command.CommandsWorker, command.Event) {}, stuck, q, 1)
+	for _, p := range []string{"1", "2", "3"} {
+		assert.NoError(w.Handle(command.E{Type: "job", P: []byte(p)}), "no error should be returned")
+	}
+
+	<-started
+	cancel()
+
+	assert.Eventually(func() bool { return !w.IsRunning() }, time.Second, time.Millisecond*10, "worker should stop")
+	assert.Equal(command.WorkerStopped, w.Handle(command.E{Type: "job"}), "stopped worker should not accept events")
+	assert.NoError(q.Close())
+
+	q, err = diskqueue.Open(dir, diskqueue.Options{})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer q.Close()
+
+	ctx, cancel = context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	handled := make(chan string, 3)
+	c, _ := command.New(command.HandlerFunc("job", func(_ context.Context, p []byte) error {
+		handled <- string(p)
+		return nil
+	}))
+
+	command.NewDurableWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, q, 1)
+
+	payloads := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		select {
+		case p := <-handled:
+			payloads = append(payloads, p)
+		case <-time.After(time.Second):
+			assert.FailNow("events should be resumed")
+		}
+	}
+
+	assert.Equal([]string{"1", "2", "3"}, payloads, "events should be resumed in order")
+	assert.Eventually(func() bool { return q.Len() == 0 }, time.Second, time.Millisecond*10, "events should be acknowledged")
+}
+
+func testDurableWorkerShouldReportEventsLeftInQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	q, err := diskqueue.Open(tempDir(t), diskqueue.Options{})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer q.Close()
+
+	started := make(chan struct{}, 1)
+	stuck, _ := command.New(&command.BaseHandler{
+		Type: "job",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			started <- struct{}{}
+			<-ctx.Done()
+			w.Write(command.NewErrEvent(e, ctx.Err()))
+		}})
+
+	w := command.NewDurableWorker(ctx, func(command.CommandsWorker, command.Event) {}, stuck, q, 1)
+	for i := 0; i < 3; i++ {
+		assert.NoError(w.Handle(command.E{Type: "job"}), "no error should be returned")
+	}
+
+	<-started
+
+	drainCtx, cancelDrain := context.WithTimeout(context.TODO(), time.Millisecond*50)
+
+	defer cancelDrain()
+
+	report, err := w.Shutdown(drainCtx)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(command.DrainReport{Interrupted: 1, Pending: 2, Persisted: 3}, report,
+		"events left in durable queue should be reported persisted")
+	assert.Equal(3, q.Len(), "interrupted and pending events should stay in queue")
+}
@@ -0,0 +1,18 @@
+package eventstore
+
+import (
+	"fmt"
+)
+
+// error type returned if stream version differs from expected one.
+type ErrConcurrencyConflict struct {
+	StreamID string
+	Expected int64
+	Actual   int64
+}
+
+// Implementation of error.
+func (err *ErrConcurrencyConflict) Error() string {
+	return fmt.Sprintf("stream %s has version %d, expected version %d",
+		err.StreamID, err.Actual, err.Expected)
+}
@@ -0,0 +1,85 @@
+package eventstore
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Returns EventStore stored in append-only file at path.
+// Records already stored in the file are loaded into memory index.
+// If sync is true every Append is flushed to disk before returning.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r Record
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		s.memory.add(r)
+
+		return nil
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements EventStore.
+type FileStore struct {
+	log    *filelog.Log
+	memory *memoryStore
+}
+
+func (s *FileStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...command.Event) ([]Record, error) {
+	s.memory.mu.Lock()
+	defer s.memory.mu.Unlock()
+
+	records, err := s.memory.prepare(streamID, expectedVersion, events)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]interface{}, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, r)
+	}
+
+	if err := s.log.Append(entries...); err != nil {
+		return nil, err
+	}
+
+	s.memory.add(records...)
+
+	return records, nil
+}
+
+func (s *FileStore) ReadStream(ctx context.Context, streamID string, from int64, direction Direction, limit int) ([]Record, error) {
+	return s.memory.ReadStream(ctx, streamID, from, direction, limit)
+}
+
+func (s *FileStore) ReadAll(ctx context.Context, from int64, limit int) ([]Record, error) {
+	return s.memory.ReadAll(ctx, from, limit)
+}
+
+func (s *FileStore) Version(ctx context.Context, streamID string) (int64, error) {
+	return s.memory.Version(ctx, streamID)
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
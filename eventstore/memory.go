@@ -0,0 +1,134 @@
+package eventstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/google/uuid"
+)
+
+// Returns EventStore that keeps records in memory.
+func NewInMemory() EventStore {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{streams: make(map[string][]int)}
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	records []Record
+	// indexes of stream records in records
+	streams map[string][]int
+}
+
+func (s *memoryStore) Append(ctx context.Context, streamID string, expectedVersion int64, events ...command.Event) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.prepare(streamID, expectedVersion, events)
+	if err != nil {
+		return nil, err
+	}
+
+	s.add(records...)
+
+	return records, nil
+}
+
+// returns records to append to stream or error if stream version is not expectedVersion.
+func (s *memoryStore) prepare(streamID string, expectedVersion int64, events []command.Event) ([]Record, error) {
+	version := int64(len(s.streams[streamID]))
+	if expectedVersion != AnyVersion && expectedVersion != version {
+		return nil, &ErrConcurrencyConflict{StreamID: streamID, Expected: expectedVersion, Actual: version}
+	}
+
+	now := time.Now().UTC()
+	position := int64(len(s.records))
+	records := make([]Record, 0, len(events))
+
+	for i, event := range events {
+		metadata := command.MetadataOf(event)
+		if metadata.ID() == "" {
+			id := uuid.New().String()
+			metadata = tracing.M{EID: id, ECausationID: id, ECorrelationID: id}
+		}
+
+		r := Record{
+			StreamID: streamID,
+			Version:  version + int64(i) + 1,
+			Position: position + int64(i) + 1,
+			E:        record.New(event, metadata),
+			Time:     now}
+
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+func (s *memoryStore) add(records ...Record) {
+	for _, r := range records {
+		s.streams[r.StreamID] = append(s.streams[r.StreamID], len(s.records))
+		s.records = append(s.records, r)
+	}
+}
+
+func (s *memoryStore) ReadStream(ctx context.Context, streamID string, from int64, direction Direction, limit int) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream := s.streams[streamID]
+	records := make([]Record, 0)
+
+	if direction == Backward {
+		if from < 1 || from > int64(len(stream)) {
+			from = int64(len(stream))
+		}
+
+		for v := from; v >= 1 && (limit < 1 || len(records) < limit); v-- {
+			records = append(records, s.records[stream[v-1]])
+		}
+
+		return records, nil
+	}
+
+	if from < 1 {
+		from = 1
+	}
+
+	for v := from; v <= int64(len(stream)) && (limit < 1 || len(records) < limit); v++ {
+		records = append(records, s.records[stream[v-1]])
+	}
+
+	return records, nil
+}
+
+func (s *memoryStore) ReadAll(ctx context.Context, from int64, limit int) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if from < 1 {
+		from = 1
+	}
+
+	records := make([]Record, 0)
+	for p := from; p <= int64(len(s.records)) && (limit < 1 || len(records) < limit); p++ {
+		records = append(records, s.records[p-1])
+	}
+
+	return records, nil
+}
+
+func (s *memoryStore) Version(ctx context.Context, streamID string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.streams[streamID])), nil
+}
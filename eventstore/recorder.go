@@ -0,0 +1,26 @@
+package eventstore
+
+import (
+	"context"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// Returns ID of stream event should be appended to.
+type StreamFunc func(event command.EventWithMetadata) string
+
+// StreamFunc that appends every event to stream named after event correlation ID,
+// so every chain started by Commands.Handle is stored in its own stream.
+func ByCorrelationID(event command.EventWithMetadata) string {
+	return event.Metadata().CorrelationID()
+}
+
+// Returns command.Observer that appends every Event dispatched by Commands to store.
+// Use it with command.WithObserver.
+func Recorder(store EventStore, stream StreamFunc) command.Observer {
+	return func(ctx context.Context, event command.EventWithMetadata) error {
+		_, err := store.Append(ctx, stream(event), AnyVersion, event)
+
+		return err
+	}
+}
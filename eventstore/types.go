@@ -0,0 +1,58 @@
+package eventstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+const (
+	// Expected version that disables optimistic concurrency check.
+	AnyVersion int64 = -1
+	// Expected version of stream that does not exist yet.
+	NoStream int64 = 0
+)
+
+// Direction in which stream is read.
+type Direction int
+
+const (
+	Forward Direction = iota
+	Backward
+)
+
+// Record is an Event stored in EventStore.
+type Record struct {
+	StreamID string `json:"streamId"`
+	// Version of stream this record was appended with. Starts from 1.
+	Version int64 `json:"version"`
+	// Position of record amongst all records in EventStore. Starts from 1.
+	Position int64 `json:"position"`
+
+	record.E
+	Time time.Time `json:"time"`
+}
+
+// Returns stored Event with its original Metadata.
+func (r Record) Event() command.EventWithMetadata {
+	return r.E.Event()
+}
+
+// EventStore stores Events in streams.
+type EventStore interface {
+	// Appends events to the end of stream if current stream version equals expectedVersion.
+	// Returns *ErrConcurrencyConflict otherwise.
+	// Metadata of events is preserved if events implement command.EventWithMetadata.
+	Append(ctx context.Context, streamID string, expectedVersion int64, events ...command.Event) ([]Record, error)
+	// Reads up to limit records of stream starting from version from (inclusive) in direction.
+	// Reading backward with from less than 1 starts from the last record.
+	// limit less than 1 means no limit.
+	ReadStream(ctx context.Context, streamID string, from int64, direction Direction, limit int) ([]Record, error)
+	// Reads up to limit records of all streams starting from position from (inclusive).
+	// limit less than 1 means no limit.
+	ReadAll(ctx context.Context, from int64, limit int) ([]Record, error)
+	// Returns current version of stream or NoStream if stream does not exist.
+	Version(ctx context.Context, streamID string) (int64, error)
+}
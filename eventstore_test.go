@@ -0,0 +1,157 @@
+package tinycqs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStore(t *testing.T) {
+	t.Run("In-memory store should append and read streams", func(t *testing.T) {
+		testEventStoreShouldAppendAndRead(t, eventstore.NewInMemory())
+	})
+	t.Run("File store should append and read streams", func(t *testing.T) {
+		store, err := eventstore.NewFileStore(filepath.Join(tempDir(t), "events.log"), true)
+		if err != nil {
+			assert.FailNow(t, err.Error())
+		}
+
+		defer store.Close()
+
+		testEventStoreShouldAppendAndRead(t, store)
+	})
+	t.Run("Store should reject unexpected version", testEventStoreShouldRejectUnexpectedVersion)
+	t.Run("File store should restore records", testFileStoreShouldRestoreRecords)
+	t.Run("Commands should persist events", testCommandsShouldPersistEvents)
+}
+
+func testEventStoreShouldAppendAndRead(t *testing.T, store eventstore.EventStore) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+
+	_, err := store.Append(ctx, "a", eventstore.NoStream,
+		command.E{Type: "a_1"}, command.E{Type: "a_2"}, command.E{Type: "a_3"})
+	assert.NoError(err, "no error should be returned")
+
+	records, err := store.Append(ctx, "b", eventstore.NoStream, command.WithMetadata(command.E{Type: "b_1"},
+		tracing.M{EID: "id", ECausationID: "causation", ECorrelationID: "correlation"}))
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(records, 1, "one record should be appended") {
+		assert.Equal(int64(4), records[0].Position, "position should be global")
+		assert.Equal(int64(1), records[0].Version, "version should be per stream")
+		assert.Equal("correlation", records[0].Event().Metadata().CorrelationID(), "metadata should be preserved")
+	}
+
+	records, err = store.ReadStream(ctx, "a", 2, eventstore.Forward, 0)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal([]string{"a_2", "a_3"}, recordTypes(records), "stream should be read forward")
+
+	records, err = store.ReadStream(ctx, "a", 0, eventstore.Backward, 2)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal([]string{"a_3", "a_2"}, recordTypes(records), "stream should be read backward")
+
+	records, err = store.ReadAll(ctx, 3, 0)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal([]string{"a_3", "b_1"}, recordTypes(records), "all streams should be read")
+
+	version, err := store.Version(ctx, "a")
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(3), version, "stream version should equal amount of records")
+}
+
+func testEventStoreShouldRejectUnexpectedVersion(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+
+	_, err := store.Append(ctx, "a", eventstore.NoStream, command.E{Type: "a_1"})
+	assert.NoError(err, "no error should be returned")
+
+	_, err = store.Append(ctx, "a", eventstore.NoStream, command.E{Type: "a_2"})
+	assert.IsType(&eventstore.ErrConcurrencyConflict{}, err, "error should be of type *eventstore.ErrConcurrencyConflict")
+
+	_, err = store.Append(ctx, "a", eventstore.AnyVersion, command.E{Type: "a_2"})
+	assert.NoError(err, "no error should be returned")
+}
+
+func testFileStoreShouldRestoreRecords(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "events.log")
+
+	store, err := eventstore.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	_, err = store.Append(ctx, "a", eventstore.NoStream, command.E{Type: "a_1", P: []byte("payload")})
+	assert.NoError(err, "no error should be returned")
+	assert.NoError(store.Close(), "no error should be returned")
+
+	store, err = eventstore.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	records, err := store.ReadStream(ctx, "a", 1, eventstore.Forward, 0)
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(records, 1, "record should be restored") {
+		assert.Equal([]byte("payload"), records[0].Payload, "payload should be restored")
+	}
+
+	_, err = store.Append(ctx, "a", 1, command.E{Type: "a_2"})
+	assert.NoError(err, "stream version should be restored")
+}
+
+func testCommandsShouldPersistEvents(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	handler1 := &command.BaseHandler{
+		Type: "test_1",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.E{Type: "test_2"})
+		}}
+	handler2 := &command.BaseHandler{
+		Type: "test_2",
+		HandleFunc: func(ctx context.Context, r command.EventWriter, e command.Event) {
+			defer r.Done()
+
+			r.Write(command.Done(e))
+		}}
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithObserver(eventstore.Recorder(store, eventstore.ByCorrelationID))},
+		handler1,
+		handler2,
+	)
+
+	ev := c.Handle(ctx, command.WithMetadata(command.E{Type: "test_1"},
+		tracing.M{EID: "id", ECausationID: "id", ECorrelationID: "correlation"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	records, err := store.ReadStream(ctx, "correlation", 1, eventstore.Forward, 0)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal([]string{"test_1", "test_2", command.DoneEventType("test_2")}, recordTypes(records),
+		"every event should be persisted")
+}
+
+func recordTypes(records []eventstore.Record) []string {
+	types := make([]string, 0, len(records))
+	for _, r := range records {
+		types = append(types, r.EventType)
+	}
+
+	return types
+}
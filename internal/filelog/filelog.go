@@ -0,0 +1,252 @@
+// Package filelog implements append-only log of JSON encoded records
+// shared by file-backed stores.
+package filelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Returns Log stored in file at path. File is created if it does not exist.
+// If sync is true every Append is flushed to disk before returning.
+func Open(path string, sync bool) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := truncateIncomplete(f); err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	return &Log{path: path, f: f, sync: sync}, nil
+}
+
+// truncates incomplete last record (for example after crash mid-write),
+// so records appended later do not merge with it.
+func truncateIncomplete(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	size := info.Size()
+	buf := make([]byte, 4096)
+	end := size
+
+	for end > 0 {
+		n := int64(len(buf))
+		if end < n {
+			n = end
+		}
+
+		if _, err := f.ReadAt(buf[:n], end-n); err != nil {
+			return err
+		}
+
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			end = end - n + int64(i) + 1
+
+			break
+		}
+
+		end -= n
+	}
+
+	if end == size {
+		return nil
+	}
+
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+// Log is append-only file of JSON records separated by new line.
+type Log struct {
+	mu sync.Mutex
+
+	path string
+	f    *os.File
+	sync bool
+}
+
+// Returns path of underlying file.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Appends JSON encoded records to the end of the Log.
+func (l *Log) Append(records ...interface{}) error {
+	var buf bytes.Buffer
+
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if l.sync {
+		return l.f.Sync()
+	}
+
+	return nil
+}
+
+// Calls fn for every record stored in the Log in order they were appended.
+// Incomplete last record (for example after crash) is ignored, Open truncates it.
+func (l *Log) ReadAll(fn func(record json.RawMessage) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		b, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			continue
+		}
+
+		if !json.Valid(b) {
+			return fmt.Errorf("%s: corrupted record at line %d", l.path, line)
+		}
+
+		if err := fn(json.RawMessage(b)); err != nil {
+			return err
+		}
+	}
+}
+
+// Replaces content of the Log with records.
+func (l *Log) Rewrite(records ...interface{}) error {
+	var buf bytes.Buffer
+
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tmp := l.path + ".tmp"
+	if err := writeFile(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, l.path); err != nil {
+		if f, e := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); e == nil {
+			l.f = f
+		}
+
+		return err
+	}
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	l.f = f
+
+	return syncDir(filepath.Dir(l.path))
+}
+
+// writes b to file at path and flushes it to disk.
+func writeFile(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// flushes directory entries of dir to disk, so renamed file survives crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// Closes underlying file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
+
+// Flushes underlying file to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Sync()
+}
@@ -0,0 +1,109 @@
+// Package match implements matching of event types against patterns
+// shared by command routing and rate limits.
+package match
+
+import (
+	"strings"
+)
+
+const (
+	errorEventTypePrefix = "ERROR#"
+	doneEventTypePrefix  = "DONE#"
+)
+
+// Reports whether eventType matches pattern.
+// "*" in pattern matches any sequence of characters, every other character matches itself.
+// Patterns of error ("ERROR#...") and done ("DONE#...") event types match only
+// error and done event types respectively, other patterns never match them.
+func EventType(pattern, eventType string) bool {
+	if pattern == eventType {
+		return true
+	}
+
+	if eventTypeClass(pattern) != eventTypeClass(eventType) {
+		return false
+	}
+
+	return matchWildcard(pattern, eventType)
+}
+
+func eventTypeClass(eventType string) string {
+	switch {
+	case strings.HasPrefix(eventType, errorEventTypePrefix):
+		return errorEventTypePrefix
+	case strings.HasPrefix(eventType, doneEventTypePrefix):
+		return doneEventTypePrefix
+	default:
+		return ""
+	}
+}
+
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+
+		s = s[i+len(part):]
+	}
+
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
+
+// Returns the most precise amongst patterns eventType matches (see EventType)
+// and false if it matches none of them.
+// Exact match always wins, otherwise pattern with more literal characters wins,
+// then pattern with less wildcards wins, then the first one in patterns wins.
+func Route(patterns []string, eventType string) (string, bool) {
+	return route(patterns, eventType, EventType)
+}
+
+// Returns the most precise amongst patterns key matches and false if it matches none of them.
+// Unlike Route "*" is the only special character, error and done prefixes have no special meaning,
+// so it suits keys that are not event types (for example query names).
+func RouteKey(patterns []string, key string) (string, bool) {
+	return route(patterns, key, matchWildcard)
+}
+
+func route(patterns []string, s string, matches func(pattern, s string) bool) (string, bool) {
+	best, found := "", false
+	for _, pattern := range patterns {
+		if pattern == s {
+			return pattern, true
+		}
+
+		if !matches(pattern, s) {
+			continue
+		}
+
+		if !found || morePrecise(pattern, best) {
+			best, found = pattern, true
+		}
+	}
+
+	return best, found
+}
+
+func morePrecise(pattern, than string) bool {
+	wildcards, thanWildcards := strings.Count(pattern, "*"), strings.Count(than, "*")
+	literals, thanLiterals := len(pattern)-wildcards, len(than)-thanWildcards
+
+	if literals != thanLiterals {
+		return literals > thanLiterals
+	}
+
+	return wildcards < thanWildcards
+}
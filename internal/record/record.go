@@ -0,0 +1,56 @@
+// Package record implements Event serialized together with its Metadata
+// shared by types that persist Events.
+package record
+
+import "github.com/andriiyaremenko/tinycqs/tracing"
+
+// E is an Event serialized together with its Metadata.
+type E struct {
+	ID            string `json:"id"`
+	CausationID   string `json:"causationId"`
+	CorrelationID string `json:"correlationId"`
+
+	EventType string `json:"type"`
+	Payload   []byte `json:"payload"`
+}
+
+// Returns E of event with metadata. Metadata fields are left empty if metadata is nil.
+// event is usually command.Event.
+func New(event interface {
+	EventType() string
+	Payload() []byte
+}, metadata tracing.Metadata) E {
+	e := E{EventType: event.EventType(), Payload: event.Payload()}
+	if metadata != nil {
+		e.ID, e.CausationID, e.CorrelationID = metadata.ID(), metadata.CausationID(), metadata.CorrelationID()
+	}
+
+	return e
+}
+
+// Returns recorded Event with its original Metadata.
+func (e E) Event() Event {
+	return Event{e}
+}
+
+// Event is recorded Event restored with its original Metadata.
+// It implements command.EventWithMetadata.
+type Event struct {
+	e E
+}
+
+func (e Event) EventType() string {
+	return e.e.EventType
+}
+
+func (e Event) Payload() []byte {
+	return e.e.Payload
+}
+
+func (e Event) Err() error {
+	return nil
+}
+
+func (e Event) Metadata() tracing.Metadata {
+	return tracing.M{EID: e.e.ID, ECausationID: e.e.CausationID, ECorrelationID: e.e.CorrelationID}
+}
@@ -0,0 +1,166 @@
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+// Returns Journal stored in append-only file at path.
+// If sync is true every recorded Event is flushed to disk before returning.
+func Open(path string, sync bool) (*Journal, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{log: log}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+
+		j.position = e.Position
+
+		return nil
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Journal records Events dispatched by command.Commands
+// and replays them to rebuild derived state.
+type Journal struct {
+	mu sync.Mutex
+
+	log      *filelog.Log
+	position int64
+}
+
+// Records event and returns its Entry.
+func (j *Journal) Record(event command.EventWithMetadata) (Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry := Entry{
+		Position: j.position + 1,
+		E:        record.New(event, event.Metadata()),
+		Time:     time.Now().UTC()}
+
+	if err := event.Err(); err != nil {
+		entry.Error = err.Error()
+	}
+
+	if err := j.log.Append(entry); err != nil {
+		return Entry{}, err
+	}
+
+	j.position = entry.Position
+
+	return entry, nil
+}
+
+// Returns command.Observer that records every Event dispatched by command.Commands.
+// Use it with command.WithObserver.
+func (j *Journal) Observer() command.Observer {
+	return func(ctx context.Context, event command.EventWithMetadata) error {
+		_, err := j.Record(event)
+
+		return err
+	}
+}
+
+// Returns path of underlying file.
+func (j *Journal) Path() string {
+	return j.log.Path()
+}
+
+// Returns position of last recorded Entry.
+func (j *Journal) Position() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.position
+}
+
+// Returns entries within r in order they were recorded.
+func (j *Journal) Entries(r Range) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := j.log.ReadAll(func(b json.RawMessage) error {
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			return err
+		}
+
+		if r.Contains(e) {
+			entries = append(entries, e)
+		}
+
+		return nil
+	})
+
+	return entries, err
+}
+
+// Replays entries within options.Range into c restricted to options.Only Handlers
+// in order they were recorded.
+// Error Events are not replayed.
+// Replayed Events are not recorded again, since command.Commands.HandleOnly does not notify observers.
+// Returns Progress after last replayed entry or error if Journal could not be read or ctx was cancelled.
+func (j *Journal) Replay(ctx context.Context, c command.Commands, options ReplayOptions) (Progress, error) {
+	entries, err := j.Entries(options.Range)
+	if err != nil {
+		return Progress{}, err
+	}
+
+	replayable := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e.Error == "" {
+			replayable = append(replayable, e)
+		}
+	}
+
+	ctx = context.WithValue(ctx, replayKey{}, true)
+	if options.DryRun {
+		ctx = command.WithDryRun(ctx)
+	}
+
+	progress := Progress{Total: len(replayable)}
+	for _, e := range replayable {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		result := c.HandleOnly(ctx, e.Event(), options.Only...)
+
+		progress.Replayed++
+		progress.Entry, progress.Result = e, result
+
+		if result.Err() != nil {
+			progress.Failed++
+		}
+
+		if options.Progress != nil {
+			options.Progress(progress)
+		}
+	}
+
+	return progress, nil
+}
+
+// Closes underlying file.
+func (j *Journal) Close() error {
+	return j.log.Close()
+}
@@ -0,0 +1,92 @@
+package journal
+
+import (
+	"context"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+// Entry is an Event recorded in Journal.
+type Entry struct {
+	// Position of entry in Journal. Starts from 1.
+	Position int64 `json:"position"`
+
+	record.E
+	// Error of error Event. Error Events are recorded but never replayed.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Returns recorded Event with its original Metadata.
+func (e Entry) Event() command.EventWithMetadata {
+	return e.E.Event()
+}
+
+// Range of Journal entries. Zero values mean no bound.
+type Range struct {
+	// First position (inclusive).
+	FromPosition int64
+	// Last position (inclusive).
+	ToPosition int64
+	// Earliest time (inclusive).
+	From time.Time
+	// Latest time (exclusive).
+	To time.Time
+}
+
+// Reports whether entry belongs to Range.
+func (r Range) Contains(entry Entry) bool {
+	if r.FromPosition > 0 && entry.Position < r.FromPosition {
+		return false
+	}
+
+	if r.ToPosition > 0 && entry.Position > r.ToPosition {
+		return false
+	}
+
+	if !r.From.IsZero() && entry.Time.Before(r.From) {
+		return false
+	}
+
+	if !r.To.IsZero() && !entry.Time.Before(r.To) {
+		return false
+	}
+
+	return true
+}
+
+// ReplayOptions configures Journal.Replay.
+type ReplayOptions struct {
+	// Entries to replay.
+	Range Range
+	// Event types (or patterns) of Handlers entries are replayed into (see command.Commands.HandleOnly).
+	Only []string
+	// Skips Handlers with side effects (see command.WithDryRun).
+	DryRun bool
+	// Optional callback called after every replayed entry.
+	Progress func(progress Progress)
+}
+
+// Progress of Journal.Replay.
+type Progress struct {
+	// Amount of entries to replay.
+	Total int
+	// Amount of entries replayed so far.
+	Replayed int
+	// Amount of replayed entries that resulted in error.
+	Failed int
+	// Last replayed entry and result of its handling.
+	Entry  Entry
+	Result command.Event
+}
+
+type replayKey struct{}
+
+// Reports whether Event is handled by Journal.Replay.
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey{}).(bool)
+
+	return replay
+}
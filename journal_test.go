@@ -0,0 +1,140 @@
+package tinycqs
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	t.Run("Journal should record dispatched events", testJournalShouldRecordEvents)
+	t.Run("Journal should replay range into selected handlers", testJournalShouldReplay)
+	t.Run("Journal should skip handlers with side effects in dry-run", testJournalShouldSkipSideEffectsInDryRun)
+}
+
+func openTestJournal(t *testing.T) *journal.Journal {
+	j, err := journal.Open(filepath.Join(tempDir(t), "journal.log"), true)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	t.Cleanup(func() { j.Close() })
+
+	return j
+}
+
+func testJournalShouldRecordEvents(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	j := openTestJournal(t)
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithObserver(j.Observer())},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error { return nil }),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "order", P: []byte("42")})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	entries, err := j.Entries(journal.Range{})
+	assert.NoError(err, "no error should be returned")
+
+	if assert.Len(entries, 2, "initial and chained events should be recorded") {
+		assert.Equal("order", entries[0].EventType)
+		assert.Equal("42", string(entries[0].Payload), "payload should be recorded")
+		assert.Equal("ship", entries[1].EventType)
+		assert.Equal(entries[0].ID, entries[1].CausationID, "causation should be recorded")
+		assert.Equal(entries[0].CorrelationID, entries[1].CorrelationID, "correlation should be recorded")
+		assert.Equal(int64(2), entries[1].Position)
+	}
+
+	reopened, err := journal.Open(j.Path(), true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer reopened.Close()
+
+	assert.Equal(int64(2), reopened.Position(), "position should be restored")
+}
+
+func testJournalShouldReplay(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	j := openTestJournal(t)
+
+	for _, eventType := range []string{"a", "b", "a", "a"} {
+		if _, err := j.Record(command.WithMetadata(command.E{Type: eventType}, nil)); err != nil {
+			assert.FailNow(err.Error())
+		}
+	}
+
+	aWasCalled, bWasCalled := &wasCalledCounter{}, &wasCalledCounter{}
+	c, _ := command.New(
+		command.HandlerFunc("a", func(context.Context, []byte) error {
+			aWasCalled.increase()
+			return nil
+		}),
+		command.HandlerFunc("b", func(context.Context, []byte) error {
+			bWasCalled.increase()
+			return nil
+		}),
+	)
+
+	reported := 0
+	progress, err := j.Replay(ctx, c, journal.ReplayOptions{
+		Range: journal.Range{FromPosition: 2},
+		Only:  []string{"a"},
+		Progress: func(p journal.Progress) {
+			reported++
+			assert.Equal(3, p.Total, "total should be reported")
+			assert.Equal(reported, p.Replayed, "progress should be reported after every entry")
+		}})
+
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(3, progress.Replayed, "all entries in range should be replayed")
+	assert.Equal(0, progress.Failed, "no entries should fail")
+	assert.Equal(2, aWasCalled.getCount(), "selected handler should be called for entries in range")
+	assert.Equal(0, bWasCalled.getCount(), "not selected handler should not be called")
+}
+
+func testJournalShouldSkipSideEffectsInDryRun(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	j := openTestJournal(t)
+
+	if _, err := j.Record(command.WithMetadata(command.E{Type: "user_created"}, nil)); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	projectionWasCalled, emailWasCalled := &wasCalledCounter{}, &wasCalledCounter{}
+	c, _ := command.New(
+		command.HandlerFunc("user_created", func(context.Context, []byte) error {
+			projectionWasCalled.increase()
+			return nil
+		}),
+		&command.BaseHandler{
+			Type:        "user_created",
+			SideEffects: true,
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				emailWasCalled.increase()
+				w.Done()
+			}},
+	)
+
+	progress, err := j.Replay(ctx, c, journal.ReplayOptions{Only: []string{"*"}, DryRun: true})
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(1, progress.Replayed, "entry should be replayed")
+	assert.NoError(progress.Result.Err(), "no error should be returned")
+	assert.Equal(1, projectionWasCalled.getCount(), "handler without side effects should be called")
+	assert.Equal(0, emailWasCalled.getCount(), "handler with side effects should be skipped")
+
+	ev := c.Handle(ctx, command.E{Type: "user_created"})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal(1, emailWasCalled.getCount(), "handler with side effects should be called outside of dry-run")
+}
@@ -10,15 +10,23 @@ import (
 
 	"github.com/andriiyaremenko/tinycqs/command"
 	"github.com/andriiyaremenko/tinycqs/query"
+	"github.com/andriiyaremenko/tinycqs/ratelimit"
 	"github.com/andriiyaremenko/tinycqs/tracing"
 )
 
 // *Handler implements http.Handler.
 // Handler uses query.Queries, command.Commands and command.CommandsWorker to process requests
+// Commands of requests with RequestID header get idempotency key derived from it (see command.WithIdempotency),
+// commands of requests without it are not idempotent.
+// Requests exceeding rate limit of their client, command or query (see ratelimit.Limiter)
+// get RateLimitExceeded error.
 type Handler struct {
 	Queries  query.Queries
 	Commands command.Commands
 	Worker   command.CommandsWorker
+	// Optional Limiter of requests per caller identity (see ClientHeader).
+	// Every request of batch takes its own token.
+	Clients *ratelimit.Limiter
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -44,8 +52,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	ctx := req.Context()
 	responses := make([]interface{}, 0, 1)
+	client := clientIdentity(req)
 
-	for _, reqModel := range reqModels {
+	for i, reqModel := range reqModels {
+		if h.Clients != nil {
+			if err := h.Clients.Allow(client); err != nil {
+				responses = append(responses, newErrorResponse(reqModel, InternalApplicationError, err))
+				continue
+			}
+		}
+
+		key := idempotencyKey(req, i, isBatch)
 		payload, err := json.Marshal(reqModel.Params)
 		if err != nil {
 			writeErrorResponse(w,
@@ -57,7 +74,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		if reqModel.ID != nil {
 			successResp, errResp := h.handleQueries(ctx, reqModel, metadata, payload)
 			if errResp != nil && errResp.Error.Code == MethodNotFound {
-				successResp, errResp = h.handleCommand(ctx, reqModel, metadata, payload)
+				successResp, errResp = h.handleCommand(ctx, reqModel, metadata, payload, key)
 			}
 
 			if errResp != nil {
@@ -69,9 +86,9 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			continue
 		}
 
-		_, errResp := h.handleCommand(ctx, reqModel, metadata, payload)
+		_, errResp := h.handleCommand(ctx, reqModel, metadata, payload, key)
 		if errResp != nil && errResp.Error.Code == MethodNotFound {
-			errResp = h.workerHandleCommand(reqModel, metadata, payload)
+			errResp = h.workerHandleCommand(ctx, reqModel, metadata, payload, key, req.Header.Get(PriorityHeader))
 		}
 
 		if errResp != nil {
@@ -159,13 +176,13 @@ func (h *Handler) MarshalJSON() ([]byte, error) {
 }
 
 func (h *Handler) handleCommand(ctx context.Context, reqModel Request,
-	metadata tracing.Metadata, payload []byte) (*SuccessResponse, *ErrorResponse) {
+	metadata tracing.Metadata, payload []byte, key string) (*SuccessResponse, *ErrorResponse) {
 	if h.Commands == nil {
 		return nil, reqModel.NewErrorResponse(MethodNotFound,
 			fmt.Sprintf("handler not found for command %s", reqModel.Method), nil)
 	}
 
-	var ev command.Event = command.E{Type: reqModel.Method, P: payload}
+	ev := command.WithIdempotencyKey(command.E{Type: reqModel.Method, P: payload}, key)
 	ev = h.Commands.Handle(ctx, command.WithMetadata(ev, metadata))
 
 	var errResponse *ErrorResponse
@@ -177,7 +194,7 @@ func (h *Handler) handleCommand(ctx context.Context, reqModel Request,
 	}
 
 	if errResponse == nil && err != nil {
-		errResponse = reqModel.NewErrorResponse(InternalApplicationError, err.Error(), nil)
+		errResponse = newErrorResponse(reqModel, InternalApplicationError, err)
 	}
 
 	if errResponse != nil {
@@ -259,7 +276,7 @@ switchLabel:
 	}
 
 	if errResponse == nil && err != nil {
-		errResponse = reqModel.NewErrorResponse(InternalApplicationError, err.Error(), nil)
+		errResponse = newErrorResponse(reqModel, InternalApplicationError, err)
 	}
 
 	if errResponse != nil {
@@ -269,8 +286,8 @@ switchLabel:
 	return reqModel.NewResponse(result), nil
 }
 
-func (h *Handler) workerHandleCommand(reqModel Request,
-	metadata tracing.Metadata, payload []byte) *ErrorResponse {
+func (h *Handler) workerHandleCommand(ctx context.Context, reqModel Request,
+	metadata tracing.Metadata, payload []byte, key, priority string) *ErrorResponse {
 	if h.Worker == nil {
 		return reqModel.NewErrorResponse(MethodNotFound,
 			fmt.Sprintf("handler not found for command %s", reqModel.Method), nil)
@@ -284,7 +301,19 @@ func (h *Handler) workerHandleCommand(reqModel Request,
 	var ev command.Event = command.E{Type: reqModel.Method, P: payload}
 	var errResponse *ErrorResponse
 
-	if err := h.Worker.Handle(command.WithMetadata(ev, metadata)); err != nil {
+	if reqModel.Priority != "" {
+		p, err := command.ParsePriority(reqModel.Priority)
+		if err != nil {
+			return reqModel.NewErrorResponse(InvalidRequest, fmt.Sprintf("request format: %s", err), nil)
+		}
+
+		ev = command.WithPriority(ev, p)
+	} else if p, err := command.ParsePriority(priority); err == nil {
+		ev = command.WithPriority(ev, p)
+	}
+
+	ev = command.WithIdempotencyKey(ev, key)
+	if err := h.Worker.HandleContext(ctx, command.WithMetadata(ev, metadata)); err != nil {
 		errResponse = reqModel.NewErrorResponse(InternalError, err.Error(), nil)
 	}
 
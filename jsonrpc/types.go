@@ -19,10 +19,22 @@ const (
 	InternalError int = -32603
 	// Internal application error.
 	InternalApplicationError int = -32000
+	// Rate limit of client, command or query is exceeded.
+	// Error.Data holds RateLimitData.
+	RateLimitExceeded int = -32029
 )
 
 const ProtocolVersion string = "2.0"
 
+// Header with priority of JSON RPC Notifications handled by command.CommandsWorker
+// (see command.ParsePriority). Request.Priority takes precedence over it.
+// Values that are not command priorities are ignored.
+const PriorityHeader string = "Event-Priority"
+
+// Header with identity of caller requests are limited by (see Handler.Clients).
+// Remote address of request is used if header is not set.
+const ClientHeader string = "Client-Id"
+
 // JSON RPC request model.
 type Request struct {
 	// JSON RPC version. Must be exactly "2.0".
@@ -33,6 +45,8 @@ type Request struct {
 	Method string `json:"method"`
 	// JSON RPC method parameters to pass to method.
 	Params map[string]interface{} `json:"params"`
+	// Optional priority of Notification handled by command.CommandsWorker (see command.ParsePriority).
+	Priority string `json:"priority,omitempty"`
 }
 
 // Returns new JSON RPC Response based on request ID and Version.
@@ -83,3 +97,9 @@ type Error struct {
 	// A Primitive or Structured value that contains additional information about the error.
 	Data interface{} `json:"data"`
 }
+
+// Data of RateLimitExceeded error.
+type RateLimitData struct {
+	// Seconds to wait before retrying request.
+	RetryAfter float64 `json:"retryAfter"`
+}
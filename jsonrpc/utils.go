@@ -3,11 +3,15 @@ package jsonrpc
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"net/http"
 
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/ratelimit"
 	"github.com/andriiyaremenko/tinycqs/tracing"
 	"github.com/google/uuid"
 )
@@ -112,3 +116,63 @@ func addMetadata(w http.ResponseWriter, req *http.Request) tracing.Metadata {
 
 	return metadata
 }
+
+// returns idempotency key of i-th request derived from RequestID header
+// or empty string if request has no RequestID header.
+// Index of request in batch is appended to the key.
+func idempotencyKey(req *http.Request, i int, isBatch bool) string {
+	idKey, _, _ := tracing.GetTracingHeaderNames(req)
+	values := req.Header[idKey]
+	if len(values) == 0 || values[0] == "" {
+		return ""
+	}
+
+	if !isBatch {
+		return values[0]
+	}
+
+	return fmt.Sprintf("%s#%d", values[0], i)
+}
+
+// returns caller identity from ClientHeader or remote address of request.
+func clientIdentity(req *http.Request) string {
+	if client := req.Header.Get(ClientHeader); client != "" {
+		return client
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+// returns Error Response with rpcCode
+// or RateLimitExceeded Error Response if err is caused by *ratelimit.ErrRateLimited.
+func newErrorResponse(reqModel Request, rpcCode int, err error) *ErrorResponse {
+	if errRateLimited := rateLimitError(err); errRateLimited != nil {
+		return reqModel.NewErrorResponse(RateLimitExceeded, err.Error(),
+			RateLimitData{RetryAfter: errRateLimited.RetryAfter.Seconds()})
+	}
+
+	return reqModel.NewErrorResponse(rpcCode, err.Error(), nil)
+}
+
+// returns *ratelimit.ErrRateLimited err is caused by or nil.
+func rateLimitError(err error) *ratelimit.ErrRateLimited {
+	errRateLimited := new(ratelimit.ErrRateLimited)
+	if errors.As(err, &errRateLimited) {
+		return errRateLimited
+	}
+
+	if aggregated, ok := err.(*command.ErrAggregatedEvent); ok {
+		for _, inner := range aggregated.Inner() {
+			if errRateLimited := rateLimitError(inner); errRateLimited != nil {
+				return errRateLimited
+			}
+		}
+	}
+
+	return nil
+}
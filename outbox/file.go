@@ -0,0 +1,148 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of records FileStore keeps in underlying file on top of pending messages
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns Store kept in append-only file at path.
+// Pending messages already stored in the file are loaded.
+// If sync is true every change is flushed to disk before returning.
+// File is compacted automatically once it holds compactAfter records more than pending messages.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r outboxRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		s.appended++
+		if r.Message != nil {
+			return s.memory.Add(context.TODO(), *r.Message)
+		}
+
+		_, err := s.memory.Delivered(context.TODO(), r.Delivered, r.Subscriber, r.Subscribers)
+
+		return err
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements Store.
+type FileStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryStore
+	// amount of records in underlying file
+	appended int
+}
+
+type outboxRecord struct {
+	Message     *Message `json:"message,omitempty"`
+	Delivered   string   `json:"delivered,omitempty"`
+	Subscriber  string   `json:"subscriber,omitempty"`
+	Subscribers int      `json:"subscribers,omitempty"`
+}
+
+func (s *FileStore) Add(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(outboxRecord{Message: &message}); err != nil {
+		return err
+	}
+
+	if err := s.memory.Add(ctx, message); err != nil {
+		return err
+	}
+
+	return s.compactIfNeeded()
+}
+
+func (s *FileStore) Delivered(ctx context.Context, id, subscriber string, subscribers int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok, _ := s.memory.Get(ctx, id); !ok {
+		return false, nil
+	}
+
+	record := outboxRecord{Delivered: id, Subscriber: subscriber, Subscribers: subscribers}
+	if err := s.log.Append(record); err != nil {
+		return false, err
+	}
+
+	ok, err := s.memory.Delivered(ctx, id, subscriber, subscribers)
+	if err != nil {
+		return ok, err
+	}
+
+	return ok, s.compactIfNeeded()
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Message, bool, error) {
+	return s.memory.Get(ctx, id)
+}
+
+func (s *FileStore) Pending(ctx context.Context) ([]Message, error) {
+	return s.memory.Pending(ctx)
+}
+
+// Rewrites underlying file keeping only pending messages.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// compacts underlying file once it holds compactAfter records more than pending messages.
+func (s *FileStore) compactIfNeeded() error {
+	if s.appended++; s.appended < compactAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	messages, _ := s.memory.Pending(context.TODO())
+	records := make([]interface{}, 0, len(messages))
+	for i := range messages {
+		records = append(records, outboxRecord{Message: &messages[i]})
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// Returns Store that keeps messages in memory.
+func NewInMemory() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{messages: make(map[string]Message)}
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	// IDs of pending messages in order they were added
+	order    []string
+	messages map[string]Message
+}
+
+func (s *memoryStore) Add(ctx context.Context, message Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.messages[message.ID]; !ok {
+		s.order = append(s.order, message.ID)
+	}
+
+	s.messages[message.ID] = message
+
+	return nil
+}
+
+func (s *memoryStore) Delivered(ctx context.Context, id, subscriber string, subscribers int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message, ok := s.messages[id]
+	if !ok {
+		return false, nil
+	}
+
+	if !message.DeliveredTo(subscriber) {
+		// copies Deliveries so Messages returned before are not changed
+		message.Deliveries = append(message.Deliveries[:len(message.Deliveries):len(message.Deliveries)], subscriber)
+	}
+
+	if len(message.Deliveries) < subscribers {
+		s.messages[id] = message
+
+		return true, nil
+	}
+
+	delete(s.messages, id)
+	for i, pending := range s.order {
+		if pending == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+
+			break
+		}
+	}
+
+	return true, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (Message, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	message, ok := s.messages[id]
+
+	return message, ok, nil
+}
+
+func (s *memoryStore) Pending(ctx context.Context) ([]Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make([]Message, 0, len(s.order))
+	for _, id := range s.order {
+		messages = append(messages, s.messages[id])
+	}
+
+	return messages, nil
+}
+
+func (s *memoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.order)
+}
@@ -0,0 +1,218 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+	"github.com/google/uuid"
+)
+
+// Returns command.Middleware that records every Event written by Handler in store before dispatching it
+// and marks handled Event delivered to Handler once it is done.
+// Message is no longer pending once it is delivered to every Handler subscribed to its type,
+// Handler it was already delivered to skips redelivered Message.
+// Events no wrapped Handler is subscribed to are not recorded.
+// If Event could not be recorded *command.ErrEvent is written instead.
+// Use it with command.WithMiddleware, one Middleware per Commands.
+func Middleware(store Store) command.Middleware {
+	o := &outbox{store: store, subscribers: make(map[string]int)}
+
+	return o.wrap
+}
+
+// Dispatches every pending Message in store to worker in order they were recorded.
+// Returns amount of dispatched messages.
+func Redeliver(ctx context.Context, store Store, worker command.CommandsWorker) (int, error) {
+	messages, err := store.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range messages {
+		if err := worker.Handle(&redelivered{EventWithMetadata: m.Event(), message: m}); err != nil {
+			return i, err
+		}
+	}
+
+	return len(messages), nil
+}
+
+// Returns command.CommandsWorker based on commands (see command.NewWorker)
+// that dispatches Messages left pending in store before accepting new Events.
+// commands are expected to use Middleware with the same store.
+func NewWorker(ctx context.Context, store Store, eventSink func(command.CommandsWorker, command.Event),
+	commands command.Commands, limit int) (command.CommandsWorker, error) {
+	worker := command.NewWorker(ctx, eventSink, commands, limit)
+	if _, err := Redeliver(ctx, store, worker); err != nil {
+		return nil, err
+	}
+
+	return worker, nil
+}
+
+// keeps track of Handlers wrapped by Middleware to deliver Messages to each of them.
+type outbox struct {
+	store Store
+
+	mu sync.RWMutex
+	// amount of wrapped Handlers per event type or pattern they are subscribed to
+	subscribers map[string]int
+}
+
+func (o *outbox) wrap(next command.Handler) command.Handler {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pattern := next.EventType()
+	subscriber := fmt.Sprintf("%s#%d", pattern, o.subscribers[pattern])
+	o.subscribers[pattern]++
+
+	return command.MiddlewareFunc(func(ctx context.Context, w command.EventWriter, event command.Event, next command.Handler) {
+		o.handle(ctx, w, event, next, pattern, subscriber)
+	})(next)
+}
+
+func (o *outbox) handle(ctx context.Context, w command.EventWriter, event command.Event, next command.Handler,
+	pattern, subscriber string) {
+	message, ok, err := o.message(ctx, event)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+		w.Done()
+
+		return
+	}
+
+	if ok && message.DeliveredTo(subscriber) {
+		w.Done()
+
+		return
+	}
+
+	ow := &outboxWriter{ctx: ctx, w: w, event: event, outbox: o, pattern: pattern, subscriber: subscriber}
+	if ok {
+		ow.message = &message
+	}
+
+	next.Handle(ctx, ow, event)
+}
+
+// returns pending Message handled event was recorded as.
+// Event handled by one of several subscribers has its own ID and is caused by recorded Message.
+// Redelivered event carries Message as it was when redelivered,
+// since other subscribers may complete its delivery before the store is asked.
+func (o *outbox) message(ctx context.Context, event command.Event) (Message, bool, error) {
+	m := command.MetadataOf(event)
+	if r := redeliveredOf(event); r != nil && r.message.EventType == event.EventType() &&
+		(r.message.ID == m.ID() || r.message.ID == m.CausationID()) {
+		return r.message, true, nil
+	}
+	if message, ok, err := o.store.Get(ctx, m.ID()); ok || err != nil {
+		return message, ok, err
+	}
+
+	message, ok, err := o.store.Get(ctx, m.CausationID())
+	if err != nil || !ok || message.EventType != event.EventType() {
+		return Message{}, false, err
+	}
+
+	return message, true, nil
+}
+
+// returns amount of wrapped Handlers subscribed to pattern.
+func (o *outbox) subscribersOf(pattern string) int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.subscribers[pattern]
+}
+
+// reports whether any wrapped Handler is subscribed to eventType.
+func (o *outbox) subscribed(eventType string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	for pattern := range o.subscribers {
+		if command.MatchEventType(pattern, eventType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// records Events before writing them and marks handled Message delivered to subscriber on Done.
+type outboxWriter struct {
+	ctx        context.Context
+	w          command.EventWriter
+	event      command.Event
+	outbox     *outbox
+	pattern    string
+	subscriber string
+	message    *Message
+
+	once sync.Once
+}
+
+func (ow *outboxWriter) Write(e command.Event) {
+	if e.Err() != nil || command.AsDoneEvent(e) != nil || !ow.outbox.subscribed(e.EventType()) {
+		ow.w.Write(e)
+
+		return
+	}
+
+	withMetadata := command.AsEventWithMetadata(e)
+	if withMetadata == nil {
+		withMetadata = command.WithMetadata(e, command.MetadataOf(ow.event).New(uuid.New().String()))
+	}
+
+	err := ow.outbox.store.Add(ow.ctx, Message{E: record.New(e, withMetadata.Metadata()), Time: time.Now().UTC()})
+
+	if err != nil {
+		ow.w.Write(command.NewErrEvent(ow.event, err))
+
+		return
+	}
+
+	ow.w.Write(withMetadata)
+}
+
+func (ow *outboxWriter) Done() {
+	ow.once.Do(func() {
+		if ow.message != nil {
+			subscribers := ow.outbox.subscribersOf(ow.pattern)
+			if _, err := ow.outbox.store.Delivered(ow.ctx, ow.message.ID, ow.subscriber, subscribers); err != nil {
+				ow.w.Write(command.NewErrEvent(ow.event, err))
+			}
+		}
+
+		ow.w.Done()
+	})
+}
+
+// Event dispatched by Redeliver with pending Message it was recorded as.
+type redelivered struct {
+	command.EventWithMetadata
+
+	message Message
+}
+
+func (r *redelivered) Event() command.Event {
+	return r.EventWithMetadata
+}
+
+// returns redelivered event wraps if there is one.
+func redeliveredOf(event command.Event) *redelivered {
+	for event != nil {
+		if r, ok := event.(*redelivered); ok {
+			return r
+		}
+
+		event = command.Unwrap(event)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+// Message is an Event written by Handler and recorded in Store before dispatching.
+type Message struct {
+	record.E
+	Time time.Time `json:"time"`
+
+	// subscribers Message was already delivered to
+	Deliveries []string `json:"deliveries,omitempty"`
+}
+
+// Reports whether Message was delivered to subscriber.
+func (m Message) DeliveredTo(subscriber string) bool {
+	for _, delivered := range m.Deliveries {
+		if delivered == subscriber {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns recorded Event with its original Metadata.
+func (m Message) Event() command.EventWithMetadata {
+	return m.E.Event()
+}
+
+// Store keeps Messages until they are delivered.
+type Store interface {
+	// Records pending message.
+	Add(ctx context.Context, message Message) error
+	// Marks message with id delivered to subscriber.
+	// Message is no longer pending once it is delivered to subscribers amount of subscribers.
+	// Reports whether pending message with id existed.
+	Delivered(ctx context.Context, id, subscriber string, subscribers int) (bool, error)
+	// Returns pending message with id and true or false if there is none.
+	Get(ctx context.Context, id string) (Message, bool, error)
+	// Returns pending messages in order they were added.
+	Pending(ctx context.Context) ([]Message, error)
+}
@@ -0,0 +1,248 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/outbox"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Run("Outbox should mark delivered events", testOutboxShouldMarkDeliveredEvents)
+	t.Run("Outbox should mark fanned out events delivered", testOutboxShouldMarkFannedOutEventsDelivered)
+	t.Run("Outbox should redeliver pending events on restart", testOutboxShouldRedeliverOnRestart)
+	t.Run("Outbox should redeliver fanned out events only to pending subscribers",
+		testOutboxShouldRedeliverToPendingSubscribers)
+	t.Run("Outbox should not record events without subscribers", testOutboxShouldNotRecordEventsWithoutSubscribers)
+	t.Run("Outbox file store should compact itself", testOutboxFileStoreShouldCompactItself)
+}
+
+// returns outbox.Message of eventType Event with id.
+func outboxMessage(id, eventType string) outbox.Message {
+	var message outbox.Message
+	message.ID, message.EventType = id, eventType
+
+	return message
+}
+
+func testOutboxShouldMarkDeliveredEvents(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := outbox.NewInMemory()
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error { return nil }),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "order"})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	pending, err := store.Pending(ctx)
+	assert.NoError(err, "no error should be returned")
+	assert.Len(pending, 0, "chained event should be delivered")
+}
+
+func testOutboxShouldMarkFannedOutEventsDelivered(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := outbox.NewInMemory()
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error { return nil }),
+		command.HandlerFunc("ship", func(context.Context, []byte) error { return nil }),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "order"})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	pending, _ := store.Pending(ctx)
+	assert.Len(pending, 0, "chained event should be delivered")
+}
+
+func testOutboxShouldRedeliverOnRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "outbox.log")
+	store, err := outbox.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	crashing, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		&command.BaseHandler{
+			Type: "ship",
+			HandleFunc: func(context.Context, command.EventWriter, command.Event) {
+				panic("process died")
+			}},
+	)
+
+	ev := crashing.Handle(ctx, command.E{Type: "order", P: []byte("42")})
+	assert.Error(ev.Err(), "error should be returned")
+	store.Close()
+
+	store, err = outbox.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	pending, _ := store.Pending(ctx)
+	if assert.Len(pending, 1, "undelivered event should survive restart") {
+		assert.Equal("ship", pending[0].EventType)
+	}
+
+	shipped := make(chan struct{}, 1)
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error {
+			shipped <- struct{}{}
+			return nil
+		}),
+	)
+
+	_, err = outbox.NewWorker(ctx, store, func(command.CommandsWorker, command.Event) {}, c, 1)
+	assert.NoError(err, "no error should be returned")
+
+	select {
+	case <-shipped:
+	case <-time.After(time.Second):
+		assert.FailNow("pending event should be redelivered")
+	}
+
+	assert.Eventually(func() bool {
+		pending, _ := store.Pending(ctx)
+		return len(pending) == 0
+	}, time.Second, time.Millisecond*10, "redelivered event should be marked delivered")
+}
+
+func testOutboxShouldRedeliverToPendingSubscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	store := outbox.NewInMemory()
+	first, second := &wasCalledCounter{}, &wasCalledCounter{}
+
+	crashing, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error {
+			first.increase()
+			return nil
+		}),
+		&command.BaseHandler{
+			Type: "ship",
+			HandleFunc: func(context.Context, command.EventWriter, command.Event) {
+				panic("process died")
+			}},
+	)
+
+	ev := crashing.Handle(ctx, command.E{Type: "order"})
+	assert.Error(ev.Err(), "error should be returned")
+
+	assert.Eventually(func() bool {
+		pending, _ := store.Pending(ctx)
+		return len(pending) == 1 && len(pending[0].Deliveries) == 1
+	}, time.Second, time.Millisecond*10, "event should stay pending until every subscriber is done")
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+		command.HandlerFunc("ship", func(context.Context, []byte) error {
+			first.increase()
+			return nil
+		}),
+		command.HandlerFunc("ship", func(context.Context, []byte) error {
+			second.increase()
+			return nil
+		}),
+	)
+
+	_, err := outbox.NewWorker(ctx, store, func(command.CommandsWorker, command.Event) {}, c, 1)
+	assert.NoError(err, "no error should be returned")
+
+	assert.Eventually(func() bool {
+		pending, _ := store.Pending(ctx)
+		return len(pending) == 0
+	}, time.Second, time.Millisecond*10, "redelivered event should be marked delivered")
+	assert.Equal(1, first.getCount(), "event should not be redelivered to subscriber that is done")
+	assert.Equal(1, second.getCount(), "event should be redelivered to pending subscriber")
+}
+
+func testOutboxShouldNotRecordEventsWithoutSubscribers(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := outbox.NewInMemory()
+
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(outbox.Middleware(store))},
+		chainingHandler("order", "ship"),
+	)
+
+	c.Handle(ctx, command.E{Type: "order"})
+
+	pending, _ := store.Pending(ctx)
+	assert.Len(pending, 0, "event without subscribers should not stay pending")
+}
+
+func testOutboxFileStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "outbox.log")
+	store, err := outbox.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.NoError(store.Add(ctx, outboxMessage("pending", "ship")))
+	_, err = store.Delivered(ctx, "pending", "first", 2)
+	assert.NoError(err, "no error should be returned")
+
+	for i := 0; i < 250; i++ {
+		id := strconv.Itoa(i)
+		assert.NoError(store.Add(ctx, outboxMessage(id, "ship")))
+		_, err := store.Delivered(ctx, id, "first", 1)
+		assert.NoError(err, "no error should be returned")
+	}
+
+	assert.NoError(store.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "delivered messages should be removed")
+
+	store, err = outbox.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	pending, _ := store.Pending(ctx)
+	if assert.Len(pending, 1, "pending message should survive compaction") {
+		assert.Equal("pending", pending[0].ID)
+		assert.True(pending[0].DeliveredTo("first"), "deliveries of pending message should survive compaction")
+	}
+}
@@ -0,0 +1,150 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of records FileStore keeps in underlying file on top of current states
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns Store kept in append-only file at path.
+// States already stored in the file are loaded.
+// If sync is true every change is flushed to disk before returning.
+// File is compacted automatically once it holds compactAfter records more than current states.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r processRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		s.appended++
+		if r.State != nil {
+			return s.memory.Save(context.TODO(), *r.State)
+		}
+
+		if r.Deleted != nil {
+			_, err := s.memory.Delete(context.TODO(), r.Deleted.Name, r.Deleted.Key)
+
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements Store.
+type FileStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryStore
+	// amount of records in underlying file
+	appended int
+}
+
+type processRecord struct {
+	State   *State        `json:"state,omitempty"`
+	Deleted *deletedState `json:"deleted,omitempty"`
+}
+
+type deletedState struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+func (s *FileStore) Save(ctx context.Context, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(processRecord{State: &state}); err != nil {
+		return err
+	}
+
+	if err := s.memory.Save(ctx, state); err != nil {
+		return err
+	}
+
+	return s.compactIfNeeded()
+}
+
+func (s *FileStore) Load(ctx context.Context, name, key string) (State, bool, error) {
+	return s.memory.Load(ctx, name, key)
+}
+
+func (s *FileStore) Delete(ctx context.Context, name, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok, _ := s.memory.Load(ctx, name, key); !ok {
+		return false, nil
+	}
+
+	if err := s.log.Append(processRecord{Deleted: &deletedState{Name: name, Key: key}}); err != nil {
+		return false, err
+	}
+
+	ok, err := s.memory.Delete(ctx, name, key)
+	if err != nil {
+		return ok, err
+	}
+
+	return ok, s.compactIfNeeded()
+}
+
+// Rewrites underlying file keeping only current states.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// compacts underlying file once it holds compactAfter records more than current states.
+func (s *FileStore) compactIfNeeded() error {
+	if s.appended++; s.appended < compactAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	states := s.memory.all()
+	records := make([]interface{}, 0, len(states))
+	for i := range states {
+		records = append(records, processRecord{State: &states[i]})
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
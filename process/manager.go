@@ -0,0 +1,402 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/scheduler"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/google/uuid"
+)
+
+// Handles event of process p.
+// Changes made to p are persisted only if no error is returned.
+type HandleFunc func(ctx context.Context, p *Process, event command.Event) error
+
+// Option configures Manager.
+type Option func(*Manager)
+
+// Sets function returning key of process event belongs to.
+// Key defaults to correlation ID of event.
+func WithKey(key func(event command.Event) (string, error)) Option {
+	return func(m *Manager) {
+		m.key = key
+	}
+}
+
+// Sets scheduler of process timeouts.
+// Scheduler should dispatch Events to Commands or CommandsWorker with Manager Handlers.
+func WithScheduler(s *scheduler.Scheduler) Option {
+	return func(m *Manager) {
+		m.scheduler = s
+	}
+}
+
+// Limits Events that start new process to eventTypes.
+// Other Events without running process are ignored.
+// Any Event starts new process by default.
+func WithStartEvents(eventTypes ...string) Option {
+	return func(m *Manager) {
+		m.start = eventTypes
+	}
+}
+
+// Returns Manager of processes named name keeping their State in store.
+func New(name string, store Store, handle HandleFunc, options ...Option) *Manager {
+	m := &Manager{
+		name:   name,
+		store:  store,
+		handle: handle,
+		key: func(event command.Event) (string, error) {
+			return command.MetadataOf(event).CorrelationID(), nil
+		},
+		locks: make(map[string]*keyLock)}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// Manager correlates Events of long-running processes and persists their State between Events.
+type Manager struct {
+	name      string
+	store     Store
+	handle    HandleFunc
+	key       func(event command.Event) (string, error)
+	scheduler *scheduler.Scheduler
+	start     []string
+
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+// Returns name of Manager.
+func (m *Manager) Name() string {
+	return m.name
+}
+
+// Returns State of running process with key and true or false if there is none.
+// State of completed process is deleted.
+func (m *Manager) State(ctx context.Context, key string) (State, bool, error) {
+	return m.store.Load(ctx, m.name, key)
+}
+
+// Returns command.Handler of eventType Events of processes.
+// Handler writes Events emitted by Process.
+func (m *Manager) Handler(eventType string) command.Handler {
+	return &command.BaseHandler{
+		Type:        eventType,
+		SideEffects: true,
+		HandleFunc:  m.handleEvent}
+}
+
+// Returns command.Handlers of eventTypes Events of processes (see Handler).
+func (m *Manager) Handlers(eventTypes ...string) []command.Handler {
+	handlers := make([]command.Handler, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		handlers = append(handlers, m.Handler(eventType))
+	}
+
+	return handlers
+}
+
+func (m *Manager) handleEvent(ctx context.Context, w command.EventWriter, event command.Event) {
+	defer w.Done()
+
+	key, err := m.key(event)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	if key == "" {
+		w.Write(command.NewErrEvent(event, fmt.Errorf("process %s: empty key", m.name)))
+
+		return
+	}
+
+	unlock := m.lock(key)
+	defer unlock()
+
+	// Events are emitted even if replaced timeouts failed to be cancelled once State was saved
+	events, err := m.run(ctx, key, event)
+	for _, e := range events {
+		w.Write(e)
+	}
+
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+	}
+}
+
+// handles event of process with key and returns Events it emitted.
+// New timeouts are scheduled before State is saved and cancelled if it fails to be saved.
+// Replaced and cancelled timeouts are cancelled only once State is saved.
+func (m *Manager) run(ctx context.Context, key string, event command.Event) ([]command.Event, error) {
+	state, ok, err := m.store.Load(ctx, m.name, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if (!ok && !m.starts(event.EventType())) || state.Completed {
+		return nil, nil
+	}
+
+	if !ok {
+		state = State{Name: m.name, Key: key}
+	}
+
+	metadata := command.MetadataOf(event)
+	for name, id := range state.Timeouts {
+		if id == metadata.ID() {
+			delete(state.Timeouts, name)
+		}
+	}
+
+	p := &Process{state: state, isNew: !ok, timeouts: make(map[string]timeout)}
+	if err := m.handle(ctx, p, event); err != nil {
+		return nil, err
+	}
+
+	scheduled, stale, err := m.schedule(ctx, p, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	p.state.Version++
+	p.state.Time = time.Now().UTC()
+	if err := m.save(ctx, p.state); err != nil {
+		return nil, m.unschedule(ctx, scheduled, err)
+	}
+
+	for _, id := range stale {
+		if _, err := m.scheduler.Cancel(ctx, id); err != nil {
+			return p.emitted, err
+		}
+	}
+
+	return p.emitted, nil
+}
+
+// saves state of running process or deletes state of completed one.
+func (m *Manager) save(ctx context.Context, state State) error {
+	if state.Completed {
+		_, err := m.store.Delete(ctx, state.Name, state.Key)
+
+		return err
+	}
+
+	return m.store.Save(ctx, state)
+}
+
+// schedules timeouts of p caused by Event with metadata and records them in State of p.
+// Returns IDs of scheduled timeouts and IDs of timeouts p replaced or cancelled.
+// If scheduling fails timeouts scheduled so far are cancelled.
+func (m *Manager) schedule(ctx context.Context, p *Process, metadata tracing.Metadata) ([]string, []string, error) {
+	if p.state.Completed {
+		p.cancelled = p.cancelled[:0]
+		for name := range p.state.Timeouts {
+			p.cancelled = append(p.cancelled, name)
+		}
+
+		p.timeouts = nil
+	}
+
+	if m.scheduler == nil {
+		if len(p.timeouts) > 0 {
+			return nil, nil, ErrNoScheduler
+		}
+
+		return nil, nil, nil
+	}
+
+	var scheduled, stale []string
+	for _, name := range p.cancelled {
+		if id, ok := p.state.Timeouts[name]; ok {
+			stale = append(stale, id)
+			delete(p.state.Timeouts, name)
+		}
+	}
+
+	for name, t := range p.timeouts {
+		event := t.event
+		if command.AsEventWithMetadata(event) == nil {
+			event = command.WithMetadata(event, metadata.New(uuid.New().String()))
+		}
+
+		id, err := m.scheduler.Schedule(ctx, event, t.due)
+		if err != nil {
+			return nil, nil, m.unschedule(ctx, scheduled, err)
+		}
+
+		scheduled = append(scheduled, id)
+		if replaced, ok := p.state.Timeouts[name]; ok {
+			stale = append(stale, replaced)
+		}
+
+		if p.state.Timeouts == nil {
+			p.state.Timeouts = make(map[string]string)
+		}
+
+		p.state.Timeouts[name] = id
+	}
+
+	return scheduled, stale, nil
+}
+
+// cancels timeouts with ids scheduled before err occurred and returns err.
+func (m *Manager) unschedule(ctx context.Context, ids []string, err error) error {
+	for _, id := range ids {
+		if _, cancelErr := m.scheduler.Cancel(ctx, id); cancelErr != nil {
+			return fmt.Errorf("%w: failed to cancel timeout %s: %v", err, id, cancelErr)
+		}
+	}
+
+	return err
+}
+
+// reports whether eventType Event starts new process.
+func (m *Manager) starts(eventType string) bool {
+	if len(m.start) == 0 {
+		return true
+	}
+
+	for _, pattern := range m.start {
+		if command.MatchEventType(pattern, eventType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// locks process with key and returns function unlocking it.
+func (m *Manager) lock(key string) func() {
+	m.mu.Lock()
+	l, ok := m.locks[key]
+	if !ok {
+		l = &keyLock{}
+		m.locks[key] = l
+	}
+
+	l.refs++
+	m.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if l.refs--; l.refs == 0 {
+			delete(m.locks, key)
+		}
+	}
+}
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Process is process handled by HandleFunc.
+type Process struct {
+	state State
+	isNew bool
+
+	emitted   []command.Event
+	timeouts  map[string]timeout
+	cancelled []string
+}
+
+type timeout struct {
+	event command.Event
+	due   time.Time
+}
+
+// Returns key of process.
+func (p *Process) Key() string {
+	return p.state.Key
+}
+
+// Returns version of process, which is amount of Events it handled.
+func (p *Process) Version() int64 {
+	return p.state.Version
+}
+
+// Reports whether process is started by handled Event.
+func (p *Process) IsNew() bool {
+	return p.isNew
+}
+
+// Decodes process state into v. v is left unchanged if state was not set.
+func (p *Process) Get(v interface{}) error {
+	if len(p.state.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(p.state.Data, v)
+}
+
+// Sets process state to JSON encoded v.
+func (p *Process) Set(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	p.state.Data = b
+
+	return nil
+}
+
+// Writes events once handled Event is processed.
+func (p *Process) Emit(events ...command.Event) {
+	p.emitted = append(p.emitted, events...)
+}
+
+// Schedules timeout named name dispatching event after delay
+// unless it is cancelled by CancelTimeout or Complete.
+// Timeout with the same name is replaced.
+// event keeps correlation ID of handled Event.
+func (p *Process) Timeout(name string, delay time.Duration, event command.Event) {
+	p.timeouts[name] = timeout{event: event, due: time.Now().Add(delay)}
+}
+
+// Cancels timeout named name.
+func (p *Process) CancelTimeout(name string) {
+	delete(p.timeouts, name)
+	p.cancelled = append(p.cancelled, name)
+}
+
+// Reports whether timeout named name is pending.
+func (p *Process) HasTimeout(name string) bool {
+	if _, ok := p.timeouts[name]; ok {
+		return true
+	}
+
+	for _, cancelled := range p.cancelled {
+		if cancelled == name {
+			return false
+		}
+	}
+
+	_, ok := p.state.Timeouts[name]
+
+	return ok
+}
+
+// Completes process: its pending timeouts are cancelled and its State is deleted,
+// so further Events are ignored unless they start new process (see WithStartEvents).
+func (p *Process) Complete() {
+	p.state.Completed = true
+}
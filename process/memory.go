@@ -0,0 +1,92 @@
+package process
+
+import (
+	"context"
+	"sync"
+)
+
+// Returns Store that keeps states in memory.
+func NewInMemory() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{states: make(map[stateKey]State)}
+}
+
+type stateKey struct {
+	name string
+	key  string
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	states map[stateKey]State
+}
+
+func (s *memoryStore) Save(ctx context.Context, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[stateKey{state.Name, state.Key}] = copyState(state)
+
+	return nil
+}
+
+func (s *memoryStore) Load(ctx context.Context, name, key string) (State, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.states[stateKey{name, key}]
+
+	return copyState(state), ok, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, name, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.states[stateKey{name, key}]; !ok {
+		return false, nil
+	}
+
+	delete(s.states, stateKey{name, key})
+
+	return true, nil
+}
+
+func (s *memoryStore) all() []State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]State, 0, len(s.states))
+	for _, state := range s.states {
+		states = append(states, state)
+	}
+
+	return states
+}
+
+func (s *memoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.states)
+}
+
+// returns state which does not share Timeouts with original.
+func copyState(state State) State {
+	if state.Timeouts == nil {
+		return state
+	}
+
+	timeouts := make(map[string]string, len(state.Timeouts))
+	for name, id := range state.Timeouts {
+		timeouts[name] = id
+	}
+
+	state.Timeouts = timeouts
+
+	return state
+}
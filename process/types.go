@@ -0,0 +1,37 @@
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Returned if Process schedules timeout of Manager without scheduler (see WithScheduler).
+var ErrNoScheduler = errors.New("process manager has no scheduler")
+
+// State is persisted state of process.
+type State struct {
+	// Name of Manager.
+	Name string `json:"name"`
+	// Key events of process are correlated by.
+	Key     string `json:"key"`
+	Version int64  `json:"version"`
+	// Process state set by Process.Set.
+	Data json.RawMessage `json:"data,omitempty"`
+	// IDs of scheduled timeouts by their names.
+	Timeouts  map[string]string `json:"timeouts,omitempty"`
+	Completed bool              `json:"completed"`
+	Time      time.Time         `json:"time"`
+}
+
+// Store keeps States of processes.
+type Store interface {
+	// Saves state replacing state with the same Name and Key.
+	Save(ctx context.Context, state State) error
+	// Returns state of process of manager name with key and true or false if there is none.
+	Load(ctx context.Context, name, key string) (State, bool, error)
+	// Removes state of process of manager name with key.
+	// Reports whether state existed.
+	Delete(ctx context.Context, name, key string) (bool, error)
+}
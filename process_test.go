@@ -0,0 +1,315 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/process"
+	"github.com/andriiyaremenko/tinycqs/scheduler"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcess(t *testing.T) {
+	t.Run("Process should correlate events by key", testProcessShouldCorrelateEventsByKey)
+	t.Run("Process should correlate events by correlation ID", testProcessShouldCorrelateByCorrelationID)
+	t.Run("Process should handle timeout if expected event does not arrive", testProcessShouldHandleTimeout)
+	t.Run("Process should keep its state in file store", testProcessShouldKeepStateInFileStore)
+	t.Run("Process file store should compact itself", testProcessFileStoreShouldCompactItself)
+	t.Run("Process should keep timeouts in line with state it failed to save", testProcessShouldKeepTimeoutsIfSaveFails)
+}
+
+type paymentProcess struct {
+	Status string `json:"status"`
+	Events int    `json:"events"`
+}
+
+// process.Store failing to save and delete states while fail is set.
+type failingProcessStore struct {
+	process.Store
+
+	fail bool
+}
+
+func (s *failingProcessStore) Save(ctx context.Context, state process.State) error {
+	if s.fail {
+		return errors.New("store failed")
+	}
+
+	return s.Store.Save(ctx, state)
+}
+
+func (s *failingProcessStore) Delete(ctx context.Context, name, key string) (bool, error) {
+	if s.fail {
+		return false, errors.New("store failed")
+	}
+
+	return s.Store.Delete(ctx, name, key)
+}
+
+// returns Manager of payment process keyed by order ID in payload
+// waiting for "payment.confirmed" for paymentTimeout after "order.placed".
+func paymentManager(store process.Store, s *scheduler.Scheduler, paymentTimeout time.Duration) *process.Manager {
+	return process.New("payment", store,
+		func(ctx context.Context, p *process.Process, event command.Event) error {
+			var state paymentProcess
+			if err := p.Get(&state); err != nil {
+				return err
+			}
+
+			state.Events++
+
+			switch event.EventType() {
+			case "order.placed":
+				state.Status = "awaiting payment"
+				p.Timeout("payment", paymentTimeout, command.E{Type: "payment.timed_out", P: event.Payload()})
+			case "payment.confirmed":
+				state.Status = "paid"
+				p.Emit(command.E{Type: "order.ship", P: event.Payload()})
+				p.Complete()
+			case "payment.timed_out":
+				state.Status = "cancelled"
+				p.Emit(command.E{Type: "order.cancel", P: event.Payload()})
+				p.Complete()
+			}
+
+			return p.Set(state)
+		},
+		process.WithKey(func(event command.Event) (string, error) { return string(event.Payload()), nil }),
+		process.WithScheduler(s),
+		process.WithStartEvents("order.placed"))
+}
+
+// returns Commands with payment process Handlers
+// and channel receiving event types of Events emitted by process.
+func paymentCommands(m *process.Manager) (command.Commands, <-chan string) {
+	emitted := make(chan string, 10)
+	record := func(ctx context.Context, w command.EventWriter, e command.Event) {
+		defer w.Done()
+
+		emitted <- e.EventType()
+	}
+
+	handlers := append(m.Handlers("order.placed", "payment.confirmed", "payment.timed_out"),
+		&command.BaseHandler{Type: "order.ship", HandleFunc: record},
+		&command.BaseHandler{Type: "order.cancel", HandleFunc: record})
+	c, _ := command.New(handlers...)
+
+	return c, emitted
+}
+
+func testProcessShouldCorrelateEventsByKey(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	m := paymentManager(process.NewInMemory(), s, time.Hour)
+	c, emitted := paymentCommands(m)
+
+	ev := c.Handle(ctx, command.E{Type: "payment.confirmed", P: []byte("unknown")})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	_, ok, _ := m.State(ctx, "unknown")
+	assert.False(ok, "only start events should start process")
+
+	ev = c.Handle(ctx, command.E{Type: "order.placed", P: []byte("42")})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	pending, _ := s.Pending(ctx)
+	assert.Len(pending, 1, "payment timeout should be scheduled")
+
+	state, ok, err := m.State(ctx, "42")
+	assert.NoError(err, "no error should be returned")
+	assert.True(ok, "process should be started")
+	assert.Contains(state.Timeouts, "payment", "payment timeout should be recorded")
+
+	ev = c.Handle(ctx, command.E{Type: "payment.confirmed", P: []byte("42")})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Equal("order.ship", <-emitted, "emitted event should be handled")
+
+	pending, _ = s.Pending(ctx)
+	assert.Len(pending, 0, "payment timeout should be cancelled")
+
+	_, ok, _ = m.State(ctx, "42")
+	assert.False(ok, "completed process should be deleted")
+
+	ev = c.Handle(ctx, command.E{Type: "payment.confirmed", P: []byte("42")})
+	assert.NoError(ev.Err(), "no error should be returned")
+	assert.Len(emitted, 0, "completed process should ignore events")
+
+	_, ok, _ = m.State(ctx, "42")
+	assert.False(ok, "completed process should not be restarted")
+}
+
+func testProcessShouldCorrelateByCorrelationID(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	handled := make(chan int64, 10)
+	m := process.New("counter", process.NewInMemory(),
+		func(ctx context.Context, p *process.Process, event command.Event) error {
+			handled <- p.Version()
+
+			return nil
+		})
+	c, _ := command.New(append(m.Handlers("first"), chainingHandler("start", "first"))...)
+
+	ev := c.Handle(ctx, command.WithMetadata(command.E{Type: "start"},
+		tracing.M{EID: "1", ECausationID: "1", ECorrelationID: "correlation"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	ev = c.Handle(ctx, command.WithMetadata(command.E{Type: "first"},
+		tracing.M{EID: "2", ECausationID: "2", ECorrelationID: "correlation"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	assert.Equal(int64(0), <-handled, "first event should start process")
+	assert.Equal(int64(1), <-handled, "events with the same correlation ID should belong to the same process")
+
+	_, ok, _ := m.State(ctx, "correlation")
+	assert.True(ok, "process should be keyed by correlation ID")
+}
+
+func testProcessShouldHandleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	m := paymentManager(process.NewInMemory(), s, time.Millisecond*50)
+	c, emitted := paymentCommands(m)
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, 1)
+
+	go s.Run(ctx, scheduler.Worker(w))
+
+	assert.NoError(w.Handle(command.E{Type: "order.placed", P: []byte("42")}), "no error should be returned")
+
+	select {
+	case eventType := <-emitted:
+		assert.Equal("order.cancel", eventType, "order should be cancelled on timeout")
+	case <-time.After(time.Second):
+		assert.FailNow("timeout should be handled")
+	}
+
+	_, ok, _ := m.State(ctx, "42")
+	assert.False(ok, "completed process should be deleted")
+
+	pending, _ := s.Pending(ctx)
+	assert.Len(pending, 0, "fired timeout should be removed")
+}
+
+func testProcessShouldKeepStateInFileStore(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "process.log")
+	store, err := process.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	c, _ := paymentCommands(paymentManager(store, scheduler.New(scheduler.NewInMemory()), time.Hour))
+	assert.NoError(c.Handle(ctx, command.E{Type: "order.placed", P: []byte("42")}).Err(), "no error should be returned")
+	assert.NoError(c.Handle(ctx, command.E{Type: "order.placed", P: []byte("43")}).Err(), "no error should be returned")
+	store.Delete(ctx, "payment", "43")
+	assert.NoError(store.Close(), "no error should be returned")
+
+	store, err = process.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	assert.NoError(store.Compact(), "no error should be returned")
+
+	m := paymentManager(store, scheduler.New(scheduler.NewInMemory()), time.Hour)
+	state, _, _ := m.State(ctx, "42")
+	assert.JSONEq(`{"status": "awaiting payment", "events": 1}`, string(state.Data), "process state should be restored")
+
+	_, ok, _ := m.State(ctx, "43")
+	assert.False(ok, "deleted process should not be restored")
+
+	c, emitted := paymentCommands(m)
+	assert.NoError(c.Handle(ctx, command.E{Type: "payment.confirmed", P: []byte("42")}).Err(),
+		"no error should be returned")
+	assert.Equal("order.ship", <-emitted, "process should continue after restart")
+}
+
+func testProcessFileStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "process.log")
+	store, err := process.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	c, emitted := paymentCommands(paymentManager(store, scheduler.New(scheduler.NewInMemory()), time.Hour))
+	assert.NoError(c.Handle(ctx, command.E{Type: "order.placed", P: []byte("running")}).Err(),
+		"no error should be returned")
+
+	for i := 0; i < 150; i++ {
+		key := []byte(strconv.Itoa(i))
+		assert.NoError(c.Handle(ctx, command.E{Type: "order.placed", P: key}).Err(), "no error should be returned")
+		assert.NoError(c.Handle(ctx, command.E{Type: "payment.confirmed", P: key}).Err(),
+			"no error should be returned")
+		<-emitted
+	}
+
+	assert.NoError(store.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "completed processes should be removed")
+
+	store, err = process.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	_, ok, _ := store.Load(ctx, "payment", "running")
+	assert.True(ok, "running process should survive compaction")
+
+	_, ok, _ = store.Load(ctx, "payment", "0")
+	assert.False(ok, "completed process should not be restored")
+}
+
+func testProcessShouldKeepTimeoutsIfSaveFails(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := &failingProcessStore{Store: process.NewInMemory(), fail: true}
+	s := scheduler.New(scheduler.NewInMemory())
+	m := paymentManager(store, s, time.Hour)
+	c, emitted := paymentCommands(m)
+
+	ev := c.Handle(ctx, command.E{Type: "order.placed", P: []byte("42")})
+	assert.Error(ev.Err(), "error should be returned")
+
+	pending, _ := s.Pending(ctx)
+	assert.Len(pending, 0, "timeout of process which was not saved should be cancelled")
+
+	store.fail = false
+	ev = c.Handle(ctx, command.E{Type: "order.placed", P: []byte("42")})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	store.fail = true
+	ev = c.Handle(ctx, command.E{Type: "payment.confirmed", P: []byte("42")})
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Len(emitted, 0, "events of process which was not saved should not be emitted")
+
+	pending, _ = s.Pending(ctx)
+	state, _, _ := m.State(ctx, "42")
+	if assert.Len(pending, 1, "timeout of process which was not saved should stay scheduled") {
+		assert.Equal(pending[0].ID, state.Timeouts["payment"], "saved state should own scheduled timeout")
+	}
+}
@@ -0,0 +1,151 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of checkpoints FileCheckpointStore appends before it compacts underlying file.
+// Every checkpoint holds full copy of ReadModel, so file is kept small.
+const compactAfter = 100
+
+// Returns CheckpointStore that keeps checkpoints in memory.
+func NewInMemoryCheckpointStore() CheckpointStore {
+	return newMemoryCheckpointStore()
+}
+
+func newMemoryCheckpointStore() *memoryCheckpointStore {
+	return &memoryCheckpointStore{checkpoints: make(map[string]Checkpoint)}
+}
+
+type memoryCheckpointStore struct {
+	mu sync.RWMutex
+
+	checkpoints map[string]Checkpoint
+}
+
+func (s *memoryCheckpointStore) Save(ctx context.Context, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints[checkpoint.Name] = checkpoint
+
+	return nil
+}
+
+func (s *memoryCheckpointStore) Load(ctx context.Context, name string) (Checkpoint, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	checkpoint, ok := s.checkpoints[name]
+
+	return checkpoint, ok, nil
+}
+
+func (s *memoryCheckpointStore) all() []Checkpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	checkpoints := make([]Checkpoint, 0, len(s.checkpoints))
+	for _, checkpoint := range s.checkpoints {
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	return checkpoints
+}
+
+// Returns CheckpointStore kept in append-only file at path.
+// Checkpoints already stored in the file are loaded.
+// If sync is true every Save is flushed to disk before returning.
+// File is compacted automatically every compactAfter saves.
+func NewFileCheckpointStore(path string, sync bool) (*FileCheckpointStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileCheckpointStore{log: log, memory: newMemoryCheckpointStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var checkpoint Checkpoint
+		if err := json.Unmarshal(b, &checkpoint); err != nil {
+			return err
+		}
+
+		s.appended++
+
+		return s.memory.Save(context.TODO(), checkpoint)
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileCheckpointStore implements CheckpointStore.
+type FileCheckpointStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryCheckpointStore
+	// amount of checkpoints appended since file was compacted
+	appended int
+}
+
+func (s *FileCheckpointStore) Save(ctx context.Context, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(checkpoint); err != nil {
+		return err
+	}
+
+	if err := s.memory.Save(ctx, checkpoint); err != nil {
+		return err
+	}
+
+	if s.appended++; s.appended < compactAfter {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileCheckpointStore) Load(ctx context.Context, name string) (Checkpoint, bool, error) {
+	return s.memory.Load(ctx, name)
+}
+
+// Rewrites underlying file keeping only latest checkpoint of every Projection.
+func (s *FileCheckpointStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+func (s *FileCheckpointStore) compact() error {
+	checkpoints := s.memory.all()
+	records := make([]interface{}, 0, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		records = append(records, checkpoint)
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = 0
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileCheckpointStore) Close() error {
+	return s.log.Close()
+}
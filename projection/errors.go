@@ -0,0 +1,14 @@
+package projection
+
+import "fmt"
+
+// error type returned if ReadModel has no value with key.
+type ErrKeyNotFound struct {
+	Projection string
+	Key        string
+}
+
+// Implementation of error.
+func (err *ErrKeyNotFound) Error() string {
+	return fmt.Sprintf("projection %s has no value with key %s", err.Projection, err.Key)
+}
@@ -0,0 +1,105 @@
+package projection
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// Returns new empty ReadModel.
+func NewReadModel() *ReadModel {
+	return &ReadModel{values: make(map[string]json.RawMessage)}
+}
+
+// ReadModel is a set of json values built by Projection.
+// It is safe for concurrent use.
+type ReadModel struct {
+	mu     sync.RWMutex
+	values map[string]json.RawMessage
+}
+
+// Unmarshals value with key into v.
+// Returns false if there is no value with key.
+func (m *ReadModel) Get(key string, v interface{}) (bool, error) {
+	value, ok := m.Raw(key)
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(value, v)
+}
+
+// Returns json value with key and true or false if there is none.
+func (m *ReadModel) Raw(key string) (json.RawMessage, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.values[key]
+
+	return value, ok
+}
+
+// Marshals v and stores it with key.
+func (m *ReadModel) Set(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values[key] = value
+
+	return nil
+}
+
+// Deletes value with key.
+func (m *ReadModel) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.values, key)
+}
+
+// Returns sorted keys of all values.
+func (m *ReadModel) Keys() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Marshals all values as json object.
+func (m *ReadModel) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.copy())
+}
+
+func (m *ReadModel) copy() map[string]json.RawMessage {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	values := make(map[string]json.RawMessage, len(m.values))
+	for key, value := range m.values {
+		values[key] = value
+	}
+
+	return values
+}
+
+func (m *ReadModel) restore(values map[string]json.RawMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.values = make(map[string]json.RawMessage, len(values))
+	for key, value := range values {
+		m.values[key] = value
+	}
+}
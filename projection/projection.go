@@ -0,0 +1,208 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/andriiyaremenko/tinycqs/query"
+)
+
+// Amount of records read from eventstore.EventStore at once by CatchUp.
+const catchUpBatch = 100
+
+// Returns new Projection with name that folds Events of eventTypes into its ReadModel using project.
+// eventTypes can be patterns (see command.MatchEventType).
+// If no eventTypes are passed every Event is projected.
+// Error events are never projected.
+func New(name string, project Projector, eventTypes ...string) *Projection {
+	return &Projection{name: name, project: project, eventTypes: eventTypes, model: NewReadModel()}
+}
+
+// Returns new Projection restored from latest Checkpoint in checkpoints or error.
+// Projection saves Checkpoint to checkpoints after every Event projected by Observer
+// and after every batch of records projected by CatchUp.
+func NewWithCheckpoints(ctx context.Context, checkpoints CheckpointStore,
+	name string, project Projector, eventTypes ...string) (*Projection, error) {
+	p := New(name, project, eventTypes...)
+	p.checkpoints = checkpoints
+
+	checkpoint, ok, err := checkpoints.Load(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		p.position = checkpoint.Position
+		p.model.restore(checkpoint.Values)
+	}
+
+	return p, nil
+}
+
+// Projection builds ReadModel from Events flowing through command.Commands
+// (see Observer) or stored in eventstore.EventStore (see CatchUp).
+// Use only one of the sources for a Projection, otherwise Events are projected twice.
+type Projection struct {
+	name        string
+	project     Projector
+	eventTypes  []string
+	checkpoints CheckpointStore
+
+	mu       sync.Mutex
+	position int64
+	model    *ReadModel
+}
+
+// Returns name of Projection.
+func (p *Projection) Name() string {
+	return p.name
+}
+
+// Returns ReadModel of Projection.
+func (p *Projection) Model() *ReadModel {
+	return p.model
+}
+
+// Returns position of last Event projected from eventstore.EventStore.
+func (p *Projection) Position() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.position
+}
+
+// Returns command.Observer that projects every Event dispatched by command.Commands.
+// Use it with command.WithObserver.
+// Projection error fails the Event.
+func (p *Projection) Observer() command.Observer {
+	return func(ctx context.Context, event command.EventWithMetadata) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		projected, err := p.apply(ctx, event)
+		if err != nil || !projected {
+			return err
+		}
+
+		return p.save(ctx)
+	}
+}
+
+// Projects records of store appended after last projected position.
+func (p *Projection) CatchUp(ctx context.Context, store eventstore.EventStore) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		records, err := store.ReadAll(ctx, p.position+1, catchUpBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			if _, err := p.apply(ctx, r.Event()); err != nil {
+				return err
+			}
+
+			p.position = r.Position
+		}
+
+		if len(records) > 0 {
+			if err := p.save(ctx); err != nil {
+				return err
+			}
+		}
+
+		if len(records) < catchUpBatch {
+			return nil
+		}
+	}
+}
+
+// Returns query.Handler with QueryName equal to Projection name serving its ReadModel.
+// Handler accepts Query payload. Empty payload returns all values.
+func (p *Projection) QueryHandler() query.Handler {
+	return query.HandlerFunc(p.name, func(ctx context.Context, payload []byte) ([]byte, error) {
+		var q Query
+		if len(payload) > 0 {
+			if err := json.Unmarshal(payload, &q); err != nil {
+				return nil, err
+			}
+		}
+
+		if q.Key == "" {
+			return json.Marshal(p.model)
+		}
+
+		value, ok := p.model.Raw(q.Key)
+		if !ok {
+			return nil, &ErrKeyNotFound{Projection: p.name, Key: q.Key}
+		}
+
+		return value, nil
+	})
+}
+
+// projects event if Projection is subscribed to its type.
+// Reports whether event was projected.
+func (p *Projection) apply(ctx context.Context, event command.EventWithMetadata) (bool, error) {
+	if event.Err() != nil || !p.matches(event.EventType()) {
+		return false, nil
+	}
+
+	return true, p.project(ctx, p.model, event)
+}
+
+// saves Checkpoint of Projection if checkpoints are enabled.
+func (p *Projection) save(ctx context.Context) error {
+	if p.checkpoints == nil {
+		return nil
+	}
+
+	return p.checkpoints.Save(ctx, Checkpoint{Name: p.name, Position: p.position, Values: p.model.copy()})
+}
+
+func (p *Projection) matches(eventType string) bool {
+	if len(p.eventTypes) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.eventTypes {
+		if command.MatchEventType(pattern, eventType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns command.Observer that projects every Event into each of projections.
+func Observer(projections ...*Projection) command.Observer {
+	observers := make([]command.Observer, 0, len(projections))
+	for _, p := range projections {
+		observers = append(observers, p.Observer())
+	}
+
+	return func(ctx context.Context, event command.EventWithMetadata) error {
+		for _, observe := range observers {
+			if err := observe(ctx, event); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Returns query.Handlers of projections to pass to query.New.
+func QueryHandlers(projections ...*Projection) []query.Handler {
+	handlers := make([]query.Handler, 0, len(projections))
+	for _, p := range projections {
+		handlers = append(handlers, p.QueryHandler())
+	}
+
+	return handlers
+}
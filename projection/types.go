@@ -0,0 +1,36 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// Projector folds Event into ReadModel.
+type Projector func(ctx context.Context, model *ReadModel, event command.EventWithMetadata) error
+
+// Checkpoint is persisted state of Projection.
+type Checkpoint struct {
+	// Name of Projection.
+	Name string `json:"name"`
+	// Position of last Event projected from eventstore.EventStore.
+	Position int64 `json:"position"`
+	// Values of ReadModel.
+	Values map[string]json.RawMessage `json:"values"`
+}
+
+// CheckpointStore keeps latest Checkpoint of every Projection.
+type CheckpointStore interface {
+	// Saves checkpoint replacing older checkpoint of the same Projection.
+	Save(ctx context.Context, checkpoint Checkpoint) error
+	// Returns latest checkpoint of Projection with name and true or false if there is none.
+	Load(ctx context.Context, name string) (Checkpoint, bool, error)
+}
+
+// Query payload accepted by Projection query.Handler.
+type Query struct {
+	// Key of ReadModel value to return.
+	// If empty all values of ReadModel are returned.
+	Key string `json:"key"`
+}
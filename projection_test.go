@@ -0,0 +1,185 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/andriiyaremenko/tinycqs/projection"
+	"github.com/andriiyaremenko/tinycqs/query"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjection(t *testing.T) {
+	t.Run("Projection should build read model from Commands events", testProjectionShouldObserveCommands)
+	t.Run("Projection should catch up with event store", testProjectionShouldCatchUp)
+	t.Run("Projection should resume from checkpoint", testProjectionShouldResumeFromCheckpoint)
+	t.Run("File checkpoint store should compact itself", testCheckpointStoreShouldCompactItself)
+}
+
+// counts events of every type.
+func countEvents(ctx context.Context, model *projection.ReadModel, event command.EventWithMetadata) error {
+	var count int
+	if _, err := model.Get(event.EventType(), &count); err != nil {
+		return err
+	}
+
+	return model.Set(event.EventType(), count+1)
+}
+
+func testProjectionShouldObserveCommands(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+
+	p := projection.New("counts", countEvents, "user.*")
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithObserver(p.Observer())},
+		command.HandlerFunc("create_user", func(context.Context, []byte) error { return nil }),
+		&command.BaseHandler{
+			Type: "register_user",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				w.Write(command.E{Type: "user.created"})
+				w.Write(command.E{Type: "user.created"})
+				w.Done()
+			}},
+		command.HandlerFunc("user.created", func(context.Context, []byte) error { return nil }),
+	)
+
+	ev := c.Handle(ctx, command.E{Type: "register_user"})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	q, _ := query.New(projection.QueryHandlers(p)...)
+
+	var count int
+	result := <-q.Handle(ctx, "counts", []byte(`{"key": "user.created"}`))
+	assert.NoError(result.UnmarshalJSONBody(&count), "no error should be returned")
+	assert.Equal(2, count, "only subscribed events should be projected")
+
+	var all map[string]int
+	result = <-q.Handle(ctx, "counts", nil)
+	assert.NoError(result.UnmarshalJSONBody(&all), "no error should be returned")
+	assert.Equal(map[string]int{"user.created": 2}, all, "all values should be returned")
+
+	result = <-q.Handle(ctx, "counts", []byte(`{"key": "user.deleted"}`))
+	assert.IsType(&projection.ErrKeyNotFound{}, result.Err(), "error should be of type *projection.ErrKeyNotFound")
+}
+
+func testProjectionShouldCatchUp(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+
+	for i := 0; i < 150; i++ {
+		_, err := store.Append(ctx, "s-"+strconv.Itoa(i%3), eventstore.AnyVersion, command.E{Type: "tick"})
+		if err != nil {
+			assert.FailNow(err.Error())
+		}
+	}
+
+	p := projection.New("ticks", countEvents)
+	assert.NoError(p.CatchUp(ctx, store), "no error should be returned")
+	assert.Equal(int64(150), p.Position(), "all records should be projected")
+
+	_, err := store.Append(ctx, "s-0", eventstore.AnyVersion, command.E{Type: "tick"})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.NoError(p.CatchUp(ctx, store), "no error should be returned")
+
+	var count int
+	_, err = p.Model().Get("tick", &count)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(151, count, "every record should be projected once")
+}
+
+func testProjectionShouldResumeFromCheckpoint(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	path := filepath.Join(tempDir(t), "checkpoints.log")
+
+	_, err := store.Append(ctx, "s", eventstore.NoStream, command.E{Type: "tick"}, command.E{Type: "tick"})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	checkpoints, err := projection.NewFileCheckpointStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	p, err := projection.NewWithCheckpoints(ctx, checkpoints, "ticks", countEvents)
+	assert.NoError(err, "no error should be returned")
+	assert.NoError(p.CatchUp(ctx, store), "no error should be returned")
+	checkpoints.Close()
+
+	_, err = store.Append(ctx, "s", 2, command.E{Type: "tick"})
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	checkpoints, err = projection.NewFileCheckpointStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer checkpoints.Close()
+
+	p, err = projection.NewWithCheckpoints(ctx, checkpoints, "ticks", countEvents)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(2), p.Position(), "position should be restored")
+	assert.NoError(p.CatchUp(ctx, store), "no error should be returned")
+
+	var count int
+	_, err = p.Model().Get("tick", &count)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(3, count, "read model should be restored and updated")
+}
+
+func testCheckpointStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "checkpoints.log")
+	checkpoints, err := projection.NewFileCheckpointStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	p, err := projection.NewWithCheckpoints(ctx, checkpoints, "ticks", countEvents)
+	assert.NoError(err, "no error should be returned")
+
+	observe := p.Observer()
+	for i := 0; i < 250; i++ {
+		assert.NoError(observe(ctx, command.WithMetadata(command.E{Type: "tick"}, nil)))
+	}
+
+	assert.NoError(checkpoints.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 100, "stale checkpoints should be removed")
+
+	checkpoints, err = projection.NewFileCheckpointStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer checkpoints.Close()
+
+	p, err = projection.NewWithCheckpoints(ctx, checkpoints, "ticks", countEvents)
+	assert.NoError(err, "no error should be returned")
+
+	var count int
+	_, err = p.Model().Get("tick", &count)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(250, count, "latest checkpoint should be restored")
+}
@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// Returns command.Handler that passes Events to h while bucket of their event type has tokens.
+// *command.ErrEvent caused by *ErrRateLimited is written for Events exceeding the limit.
+func (l *Limiter) Command(h command.Handler) command.Handler {
+	return &commandHandler{HandlerWrapper: command.HandlerWrapper{Handler: h}, limiter: l}
+}
+
+type commandHandler struct {
+	command.HandlerWrapper
+
+	limiter *Limiter
+}
+
+func (ch *commandHandler) Handle(ctx context.Context, w command.EventWriter, event command.Event) {
+	if err := ch.limiter.allowEventType(event.EventType()); err != nil {
+		w.Write(command.NewErrEvent(event, err))
+		w.Done()
+
+		return
+	}
+
+	ch.Handler.Handle(ctx, w, event)
+}
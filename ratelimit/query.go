@@ -0,0 +1,35 @@
+package ratelimit
+
+import (
+	"context"
+
+	"github.com/andriiyaremenko/tinycqs/query"
+)
+
+// Returns query.Handler that passes queries to h while bucket of its query name has tokens.
+// query.Result with *ErrRateLimited error is returned for queries exceeding the limit.
+func (l *Limiter) Query(h query.Handler) query.Handler {
+	return &queryHandler{Handler: h, limiter: l}
+}
+
+type queryHandler struct {
+	query.Handler
+
+	limiter *Limiter
+}
+
+func (qh *queryHandler) Handle(ctx context.Context, w query.ResultWriter, payload []byte) <-chan query.Result {
+	err := qh.limiter.Allow(qh.QueryName())
+	if err == nil {
+		return qh.Handler.Handle(ctx, w, payload)
+	}
+
+	r := w.GetReader()
+
+	go func() {
+		w.Write(query.Q{Name: qh.QueryName(), Error: err})
+		w.Done()
+	}()
+
+	return r.Read()
+}
@@ -0,0 +1,203 @@
+// Package ratelimit implements token bucket rate limiting of command and query Handlers and JSON RPC clients.
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/internal/match"
+)
+
+// buckets of keys idle for longer than sweepInterval are removed once they are full.
+const sweepInterval = time.Minute
+
+// Limit of token bucket.
+// Zero Limit does not limit calls.
+type Limit struct {
+	// Amount of tokens added to bucket per second.
+	Rate float64
+	// Maximum amount of tokens in bucket, i.e. amount of calls allowed in a burst. Defaults to 1.
+	Burst int
+}
+
+// Returns Limit allowing n calls per interval in a burst of n calls.
+func Every(interval time.Duration, n int) Limit {
+	if interval <= 0 || n < 1 {
+		return Limit{}
+	}
+
+	return Limit{Rate: float64(n) / interval.Seconds(), Burst: n}
+}
+
+// error type returned for calls exceeding Limit.
+type ErrRateLimited struct {
+	// Key of bucket call was taken from (event type, query name or client identity).
+	Key string
+	// Time to wait before bucket has token for the call.
+	RetryAfter time.Duration
+}
+
+// Implementation of error.
+func (err *ErrRateLimited) Error() string {
+	return fmt.Sprintf("rate limit of %s is exceeded, retry after %s", err.Key, err.RetryAfter)
+}
+
+// Option of Limiter.
+type Option func(*Limiter)
+
+// Sets limit of keys matching pattern. "*" in pattern matches any sequence of characters.
+// Event types of Events limited by Limiter.Command are matched as in command.MatchEventType:
+// error and done event types match only error and done patterns respectively.
+// Query names and client identities are plain keys, so their patterns have no such classes.
+// Every matching key gets its own bucket.
+// Exact match always wins, otherwise the most precise matching pattern is applied:
+// pattern with more literal characters, then with less wildcards, then registered first.
+func WithLimit(pattern string, limit Limit) Option {
+	return func(l *Limiter) {
+		if _, ok := l.limits[pattern]; !ok {
+			l.patterns = append(l.patterns, pattern)
+		}
+
+		l.limits[pattern] = limit
+	}
+}
+
+// Sets limit of keys that match no pattern set by WithLimit.
+// Such keys are not limited without it.
+func WithDefault(limit Limit) Option {
+	return func(l *Limiter) {
+		l.fallback = limit
+	}
+}
+
+// Returns Limiter with options.
+func New(options ...Option) *Limiter {
+	l := &Limiter{limits: make(map[string]Limit), buckets: make(map[string]*bucket), swept: time.Now()}
+	for _, apply := range options {
+		apply(l)
+	}
+
+	return l
+}
+
+// Limiter keeps token bucket for every key it limits.
+// Single Limiter can wrap several Handlers, in which case they share buckets of equal keys.
+type Limiter struct {
+	mu sync.Mutex
+
+	limits   map[string]Limit
+	patterns []string
+	fallback Limit
+
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+// Takes token from bucket of key.
+// Returns *ErrRateLimited if bucket is empty.
+// key is plain key (see WithLimit), use it for query names and caller identities.
+func (l *Limiter) Allow(key string) error {
+	return l.take(key, l.limit(match.RouteKey, key))
+}
+
+// takes token from bucket of eventType,
+// error and done event types are limited only by their own patterns (see command.MatchEventType).
+func (l *Limiter) allowEventType(eventType string) error {
+	return l.take(eventType, l.limit(match.Route, eventType))
+}
+
+// takes token from bucket of key with limit.
+func (l *Limiter) take(key string, limit Limit) error {
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = newBucket(limit, now)
+		l.buckets[key] = b
+	}
+
+	if retryAfter, ok := b.take(now); !ok {
+		return &ErrRateLimited{Key: key, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// returns Limit of the most precise pattern key is routed to.
+func (l *Limiter) limit(route func(patterns []string, key string) (string, bool), key string) Limit {
+	if pattern, ok := route(l.patterns, key); ok {
+		return l.limits[pattern]
+	}
+
+	return l.fallback
+}
+
+// removes full buckets once in sweepInterval.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if b.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.swept = now
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	return &bucket{limit: limit, tokens: float64(limit.Burst), updated: now}
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// takes token and reports whether it was available,
+// otherwise returns time to wait for the next token.
+func (b *bucket) take(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+
+		return 0, true
+	}
+
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second)), false
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.updated) {
+		b.tokens += now.Sub(b.updated).Seconds() * b.limit.Rate
+		b.updated = now
+	}
+
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+}
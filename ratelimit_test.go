@@ -0,0 +1,205 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/jsonrpc"
+	"github.com/andriiyaremenko/tinycqs/query"
+	"github.com/andriiyaremenko/tinycqs/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	t.Run("Limiter should reject calls once bucket is empty", testLimiterShouldRejectCallsOnceBucketIsEmpty)
+	t.Run("Limiter should apply limits by key pattern", testLimiterShouldApplyLimitsByPattern)
+	t.Run("Limiter should limit commands per event type", testLimiterShouldLimitCommands)
+	t.Run("Limiter should match only event types against event type classes",
+		testLimiterShouldMatchOnlyEventTypesAgainstClasses)
+	t.Run("Limiter should limit queries per query name", testLimiterShouldLimitQueries)
+	t.Run("JSON RPC Handler should limit requests per client", testHandlerShouldLimitRequestsPerClient)
+	t.Run("JSON RPC Handler should map rate limited commands to error code", testHandlerShouldMapRateLimitedCommands)
+}
+
+func testLimiterShouldRejectCallsOnceBucketIsEmpty(t *testing.T) {
+	assert := assert.New(t)
+	l := ratelimit.New(ratelimit.WithDefault(ratelimit.Every(time.Millisecond*50, 2)))
+
+	assert.NoError(l.Allow("key"), "call within burst should be allowed")
+	assert.NoError(l.Allow("key"), "call within burst should be allowed")
+
+	err := l.Allow("key")
+	errRateLimited := new(ratelimit.ErrRateLimited)
+	assert.True(errors.As(err, &errRateLimited), "ErrRateLimited should be returned")
+	assert.Equal("key", errRateLimited.Key, "error should have key")
+	assert.True(errRateLimited.RetryAfter > 0 && errRateLimited.RetryAfter <= time.Millisecond*25,
+		"error should have time to wait for next token")
+	assert.NoError(l.Allow("other"), "other key should have its own bucket")
+
+	time.Sleep(errRateLimited.RetryAfter + time.Millisecond*5)
+	assert.NoError(l.Allow("key"), "call should be allowed once token is added")
+}
+
+func testLimiterShouldApplyLimitsByPattern(t *testing.T) {
+	assert := assert.New(t)
+	l := ratelimit.New(
+		ratelimit.WithLimit("report.*", ratelimit.Every(time.Hour, 1)),
+		ratelimit.WithLimit("report.daily", ratelimit.Every(time.Hour, 2)),
+		ratelimit.WithLimit("report.weekly.*", ratelimit.Every(time.Hour, 2)))
+
+	assert.NoError(l.Allow("report.monthly"), "call within burst should be allowed")
+	assert.Error(l.Allow("report.monthly"), "call exceeding pattern limit should be rejected")
+	assert.NoError(l.Allow("report.yearly"), "every matching key should have its own bucket")
+	assert.NoError(l.Allow("report.daily"), "call within burst should be allowed")
+	assert.NoError(l.Allow("report.daily"), "exact limit should win over pattern")
+	assert.Error(l.Allow("report.daily"), "call exceeding exact limit should be rejected")
+	assert.NoError(l.Allow("report.weekly.eu"), "call within burst should be allowed")
+	assert.NoError(l.Allow("report.weekly.eu"), "the most precise pattern should win")
+	assert.Error(l.Allow("report.weekly.eu"), "call exceeding the most precise pattern limit should be rejected")
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(l.Allow("user.created"), "keys without limit should not be limited")
+	}
+}
+
+func testLimiterShouldLimitCommands(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	l := ratelimit.New(ratelimit.WithLimit("test", ratelimit.Every(time.Hour, 1)))
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(l.Command(countingHandler("test", handlerWasCalled)))
+
+	assert.NoError(c.Handle(ctx, command.E{Type: "test"}).Err(), "no error should be returned")
+
+	ev := c.Handle(ctx, command.E{Type: "test"})
+	errRateLimited := new(ratelimit.ErrRateLimited)
+	inner := ev.Err().(*command.ErrAggregatedEvent).Inner()
+	assert.True(errors.As(inner[0], &errRateLimited), "ErrRateLimited should be returned")
+	assert.Equal("test", errRateLimited.Key, "error should have event type")
+	assert.Equal(1, handlerWasCalled.getCount(), "handler should not be called once limit is exceeded")
+}
+
+func testLimiterShouldMatchOnlyEventTypesAgainstClasses(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	l := ratelimit.New(ratelimit.WithLimit("*", ratelimit.Every(time.Hour, 1)))
+
+	assert.NoError(l.Allow("ERROR#client"), "call within burst should be allowed")
+	assert.Error(l.Allow("ERROR#client"), "plain key with error prefix should be limited by wildcard")
+	assert.NoError(l.Allow("DONE#query"), "call within burst should be allowed")
+	assert.Error(l.Allow("DONE#query"), "plain key with done prefix should be limited by wildcard")
+
+	handlerWasCalled := &wasCalledCounter{}
+	c, _ := command.New(l.Command(countingHandler("DONE#test", handlerWasCalled)))
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(c.Handle(ctx, command.E{Type: "DONE#test"}).Err(), "no error should be returned")
+	}
+
+	assert.Equal(3, handlerWasCalled.getCount(), "done event type should not be limited by wildcard")
+}
+
+func testLimiterShouldLimitQueries(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	l := ratelimit.New(ratelimit.WithLimit("products", ratelimit.Every(time.Hour, 1)))
+	q, _ := query.New(l.Query(query.HandlerFunc("products", func(context.Context, []byte) ([]byte, error) {
+		return []byte(`[]`), nil
+	})))
+
+	for r := range q.Handle(ctx, "products", nil) {
+		assert.NoError(r.Err(), "no error should be returned")
+	}
+
+	for r := range q.Handle(ctx, "products", nil) {
+		errRateLimited := new(ratelimit.ErrRateLimited)
+		assert.True(errors.As(r.Err(), &errRateLimited), "ErrRateLimited should be returned")
+		assert.Equal("products", r.QueryName(), "result should have query name")
+	}
+}
+
+// sends JSON RPC request on behalf of client and returns its status code and error response.
+func sendAsClient(t *testing.T, url, body, client string) (int, *jsonrpc.ErrorResponse) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(jsonrpc.ClientHeader, client)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		return resp.StatusCode, nil
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		assert.FailNow(t, err.Error())
+	}
+
+	response := new(jsonrpc.ErrorResponse)
+	if err := json.Unmarshal(b, response); err != nil {
+		assert.FailNowf(t, "failed to read response", "%s: %s", err.Error(), string(b))
+	}
+
+	return resp.StatusCode, response
+}
+
+func testHandlerShouldLimitRequestsPerClient(t *testing.T) {
+	assert := assert.New(t)
+	c, _ := command.New(command.HandlerFunc("test", func(context.Context, []byte) error { return nil }))
+	ts := httptest.NewServer(&jsonrpc.Handler{
+		Commands: c,
+		Clients:  ratelimit.New(ratelimit.WithDefault(ratelimit.Every(time.Hour, 2)))})
+
+	defer ts.Close()
+
+	code, _ := sendAsClient(t, ts.URL, requestBody, "client")
+	assert.Equal(http.StatusOK, code, "request within limit should be handled")
+
+	code, _ = sendAsClient(t, ts.URL, requestBody, "client")
+	assert.Equal(http.StatusOK, code, "request within limit should be handled")
+
+	code, response := sendAsClient(t, ts.URL, requestBody, "client")
+	assert.Equal(http.StatusBadRequest, code, "request exceeding limit should be rejected")
+	assert.Equal(jsonrpc.RateLimitExceeded, response.Error.Code, "error code should be RateLimitExceeded")
+
+	data, _ := response.Error.Data.(map[string]interface{})
+	retryAfter, _ := data["retryAfter"].(float64)
+	assert.True(retryAfter > 0, "error data should have retry-after hint")
+
+	code, _ = sendAsClient(t, ts.URL, requestBody, "other client")
+	assert.Equal(http.StatusOK, code, "other client should have its own limit")
+}
+
+func testHandlerShouldMapRateLimitedCommands(t *testing.T) {
+	assert := assert.New(t)
+	l := ratelimit.New(ratelimit.WithLimit("test", ratelimit.Every(time.Hour, 1)))
+	c, _ := command.New(l.Command(command.HandlerFunc("test", func(context.Context, []byte) error { return nil })))
+	ts := httptest.NewServer(jsonrpc.Commands(c))
+
+	defer ts.Close()
+
+	code, _ := sendAsClient(t, ts.URL, requestBody, "client")
+	assert.Equal(http.StatusOK, code, "request within limit should be handled")
+
+	code, response := sendAsClient(t, ts.URL, requestBody, "other client")
+	assert.Equal(http.StatusBadRequest, code, "request exceeding limit should be rejected")
+	assert.Equal(jsonrpc.RateLimitExceeded, response.Error.Code, "error code should be RateLimitExceeded")
+	assert.NotNil(response.Error.Data, "error data should have retry-after hint")
+}
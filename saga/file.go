@@ -0,0 +1,141 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of records FileStore keeps in underlying file on top of current records
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns Store kept in append-only file at path.
+// Records already stored in the file are loaded.
+// If sync is true every change is flushed to disk before returning.
+// File is compacted automatically once it holds compactAfter records more than current records.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r sagaRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		s.appended++
+		if r.Record != nil {
+			return s.memory.Save(context.TODO(), *r.Record)
+		}
+
+		_, err := s.memory.Delete(context.TODO(), r.Deleted)
+
+		return err
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements Store.
+type FileStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryStore
+	// amount of records in underlying file
+	appended int
+}
+
+type sagaRecord struct {
+	Record  *Record `json:"record,omitempty"`
+	Deleted string  `json:"deleted,omitempty"`
+}
+
+func (s *FileStore) Save(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(sagaRecord{Record: &record}); err != nil {
+		return err
+	}
+
+	if err := s.memory.Save(ctx, record); err != nil {
+		return err
+	}
+
+	return s.compactIfNeeded()
+}
+
+func (s *FileStore) Load(ctx context.Context, correlationID string) (Record, bool, error) {
+	return s.memory.Load(ctx, correlationID)
+}
+
+func (s *FileStore) Delete(ctx context.Context, correlationID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok, _ := s.memory.Load(ctx, correlationID); !ok {
+		return false, nil
+	}
+
+	if err := s.log.Append(sagaRecord{Deleted: correlationID}); err != nil {
+		return false, err
+	}
+
+	ok, err := s.memory.Delete(ctx, correlationID)
+	if err != nil {
+		return ok, err
+	}
+
+	return ok, s.compactIfNeeded()
+}
+
+// Rewrites underlying file keeping only latest record of every saga.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// compacts underlying file once it holds compactAfter records more than current records.
+func (s *FileStore) compactIfNeeded() error {
+	if s.appended++; s.appended < compactAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	records := s.memory.all()
+	entries := make([]interface{}, 0, len(records))
+	for i := range records {
+		entries = append(entries, sagaRecord{Record: &records[i]})
+	}
+
+	if err := s.log.Rewrite(entries...); err != nil {
+		return err
+	}
+
+	s.appended = len(entries)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,80 @@
+package saga
+
+import (
+	"context"
+	"sync"
+)
+
+// Returns Store that keeps records in memory.
+func NewInMemory() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: make(map[string]Record)}
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	records map[string]Record
+}
+
+func (s *memoryStore) Save(ctx context.Context, record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	steps := make([]StepRecord, len(record.Steps))
+	copy(steps, record.Steps)
+	record.Steps = steps
+
+	s.records[record.CorrelationID] = record
+
+	return nil
+}
+
+func (s *memoryStore) Load(ctx context.Context, correlationID string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[correlationID]
+	if ok {
+		steps := make([]StepRecord, len(record.Steps))
+		copy(steps, record.Steps)
+		record.Steps = steps
+	}
+
+	return record, ok, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, correlationID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[correlationID]; !ok {
+		return false, nil
+	}
+
+	delete(s.records, correlationID)
+
+	return true, nil
+}
+
+func (s *memoryStore) all() []Record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func (s *memoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.records)
+}
@@ -0,0 +1,307 @@
+package saga
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/google/uuid"
+)
+
+// Returns Saga of steps keeping its Records in store.
+func New(store Store, steps ...Step) *Saga {
+	s := &Saga{store: store, steps: make(map[string]Step, len(steps))}
+	for _, step := range steps {
+		s.steps[step.EventType] = step
+	}
+
+	return s
+}
+
+// Saga runs chain of step Events and compensates completed steps if any Event of chain fails.
+// Saga is tracked by correlation ID of Event that started it.
+type Saga struct {
+	mu sync.Mutex
+
+	store Store
+	steps map[string]Step
+}
+
+// Returns command.Middleware that records completed steps of running sagas.
+// Step is completed once its Handler is done without writing error Event.
+// Use it with command.WithMiddleware on Commands passed to Saga.Handle.
+func (s *Saga) Middleware() command.Middleware {
+	return command.MiddlewareFunc(func(ctx context.Context, w command.EventWriter, event command.Event, next command.Handler) {
+		step, ok := s.steps[event.EventType()]
+		if !ok {
+			next.Handle(ctx, w, event)
+
+			return
+		}
+
+		next.Handle(ctx, &stepWriter{ctx: ctx, w: w, event: event, step: step, saga: s}, event)
+	})
+}
+
+// Handles event with c as saga.
+// If resulting Event is error completed steps are compensated in reverse order.
+// Compensations are not cancelled with ctx, so failed saga is compensated even if ctx is done.
+// Record of saga is kept in Store until saga is completed or compensated.
+// Returns *Result with final state of saga.
+func (s *Saga) Handle(ctx context.Context, c command.Commands, event command.Event) command.Event {
+	withMetadata := command.AsEventWithMetadata(event)
+	if withMetadata == nil || withMetadata.Metadata() == nil {
+		id := uuid.New().String()
+		withMetadata = command.WithMetadata(event, tracing.M{EID: id, ECorrelationID: id, ECausationID: id})
+	}
+
+	metadata := withMetadata.Metadata()
+	record := Record{CorrelationID: metadata.CorrelationID(), State: Running, Time: time.Now().UTC()}
+	if err := s.store.Save(ctx, record); err != nil {
+		return command.NewErrEvent(event, err)
+	}
+
+	result := c.Handle(ctx, withMetadata)
+
+	ctx = detached{ctx}
+	record, err := s.finish(ctx, metadata.CorrelationID(), result)
+	if err != nil {
+		return command.NewErrEvent(event, err)
+	}
+
+	if record.State == Completed {
+		return &Result{event: result, record: record}
+	}
+
+	var errs []error
+	record.State = Compensated
+	for i := len(record.Steps) - 1; i >= 0; i-- {
+		step := &record.Steps[i]
+		compensation := command.WithMetadata(
+			command.E{Type: step.CompensationType, P: step.CompensationPayload},
+			metadata.New(uuid.New().String()))
+
+		if err := c.Handle(ctx, compensation).Err(); err != nil {
+			step.CompensationError = err.Error()
+			record.State = CompensationFailed
+			errs = append(errs, err)
+
+			continue
+		}
+
+		step.Compensated = true
+	}
+
+	record.Time = time.Now().UTC()
+	if err := s.save(ctx, record); err != nil {
+		errs = append(errs, err)
+	}
+
+	return &Result{event: result, record: record, errs: errs}
+}
+
+// saves record of unfinished saga or deletes record of finished one.
+// Record of saga which failed to compensate is kept.
+func (s *Saga) save(ctx context.Context, record Record) error {
+	if record.State == Completed || record.State == Compensated {
+		_, err := s.store.Delete(ctx, record.CorrelationID)
+
+		return err
+	}
+
+	return s.store.Save(ctx, record)
+}
+
+// stops recording steps of saga with correlationID and saves its state according to result.
+// Failed saga is saved as Compensating until its compensations are done.
+// Record of completed saga is deleted.
+func (s *Saga) finish(ctx context.Context, correlationID string, result command.Event) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, _, err := s.store.Load(ctx, correlationID)
+	if err != nil {
+		return record, err
+	}
+
+	record.Time = time.Now().UTC()
+	record.State = Completed
+	if err := result.Err(); err != nil {
+		record.Error = err.Error()
+		record.State = Compensating
+	}
+
+	return record, s.save(ctx, record)
+}
+
+// Returns Record of saga with correlationID and true or false if there is none.
+// Records of completed and compensated sagas are deleted (see Result.Record).
+func (s *Saga) Record(ctx context.Context, correlationID string) (Record, bool, error) {
+	return s.store.Load(ctx, correlationID)
+}
+
+// records completed step of running saga.
+func (s *Saga) complete(ctx context.Context, step Step, event command.Event) error {
+	metadata := command.MetadataOf(event)
+	id := dispatchedID(event)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok, err := s.store.Load(ctx, metadata.CorrelationID())
+	if err != nil || !ok || record.State != Running {
+		return err
+	}
+
+	// every subscriber of step Event completes it, but it is compensated once
+	for _, recorded := range record.Steps {
+		if recorded.ID == id {
+			return nil
+		}
+	}
+
+	compensation := step.Compensation(event)
+	record.Steps = append(record.Steps, StepRecord{
+		ID:                  id,
+		EventType:           event.EventType(),
+		CompensationType:    compensation.EventType(),
+		CompensationPayload: compensation.Payload()})
+
+	return s.store.Save(ctx, record)
+}
+
+// Result is Event returned by Saga.Handle.
+// *Result implements command.Event.
+type Result struct {
+	event  command.Event
+	record Record
+	errs   []error
+}
+
+// Returns event type of result of Commands.
+func (r *Result) EventType() string {
+	return r.event.EventType()
+}
+
+// Returns payload of result of Commands.
+func (r *Result) Payload() []byte {
+	return r.event.Payload()
+}
+
+// Returns nil if saga is completed.
+// Returns error of failed step if saga is compensated.
+// Returns *command.ErrAggregatedEvent with error of failed step and compensation errors otherwise.
+func (r *Result) Err() error {
+	if len(r.errs) == 0 {
+		return r.event.Err()
+	}
+
+	aggregated := command.NewErrAggregatedEvent(r.event)
+	if err := r.event.Err(); err != nil {
+		aggregated.Append(err)
+	}
+
+	aggregated.Append(r.errs...)
+
+	return aggregated
+}
+
+// Returns final state of saga.
+func (r *Result) State() State {
+	return r.record.State
+}
+
+// Returns final Record of saga.
+func (r *Result) Record() Record {
+	return r.record
+}
+
+// Returns result of Commands.
+func (r *Result) Event() command.Event {
+	return r.event
+}
+
+// Returns *Result if event is or wraps it. nil otherwise.
+func AsResult(event command.Event) *Result {
+	for e := event; e != nil; e = command.Unwrap(e) {
+		if result, ok := e.(*Result); ok {
+			return result
+		}
+	}
+
+	return nil
+}
+
+// records step once its Handler is done without writing error Event.
+type stepWriter struct {
+	ctx   context.Context
+	w     command.EventWriter
+	event command.Event
+	step  Step
+	saga  *Saga
+
+	mu     sync.Mutex
+	failed bool
+	once   sync.Once
+}
+
+func (sw *stepWriter) Write(e command.Event) {
+	if e.Err() != nil {
+		sw.mu.Lock()
+		sw.failed = true
+		sw.mu.Unlock()
+	}
+
+	sw.w.Write(e)
+}
+
+func (sw *stepWriter) Done() {
+	sw.once.Do(func() {
+		sw.mu.Lock()
+		failed := sw.failed
+		sw.mu.Unlock()
+
+		if !failed {
+			if err := sw.saga.complete(sw.ctx, sw.step, sw.event); err != nil {
+				sw.w.Write(command.NewErrEvent(sw.event, err))
+			}
+		}
+
+		sw.w.Done()
+	})
+}
+
+// context keeping values of its parent but not its deadline and cancellation.
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// returns ID of Event dispatched to Handlers.
+// Event handled by one of several subscribers has its own ID and wraps dispatched Event it is caused by.
+func dispatchedID(event command.Event) string {
+	metadata := command.MetadataOf(event)
+	if inner := command.Unwrap(command.AsEventWithMetadata(event)); inner != nil &&
+		metadata.CausationID() != "" && command.MetadataOf(inner).ID() == metadata.CausationID() {
+		return metadata.CausationID()
+	}
+
+	return metadata.ID()
+}
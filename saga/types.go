@@ -0,0 +1,74 @@
+package saga
+
+import (
+	"context"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// State of saga.
+type State string
+
+const (
+	// Saga steps are being handled.
+	Running State = "running"
+	// All saga steps were handled successfully.
+	Completed State = "completed"
+	// Saga step failed and completed steps are being compensated.
+	Compensating State = "compensating"
+	// Saga step failed and all completed steps were compensated.
+	Compensated State = "compensated"
+	// Saga step failed and some of completed steps could not be compensated.
+	CompensationFailed State = "compensation-failed"
+)
+
+// Step of saga.
+type Step struct {
+	// Type of step Event.
+	EventType string
+	// Returns Event compensating step Event which was handled successfully.
+	Compensation func(event command.Event) command.Event
+}
+
+// Returns Step of eventType compensated by compensationType Event with the same payload.
+func Compensate(eventType, compensationType string) Step {
+	return Step{
+		EventType: eventType,
+		Compensation: func(event command.Event) command.Event {
+			return command.E{Type: compensationType, P: event.Payload()}
+		}}
+}
+
+// StepRecord is completed saga step.
+type StepRecord struct {
+	ID        string `json:"id"`
+	EventType string `json:"type"`
+
+	CompensationType    string `json:"compensationType"`
+	CompensationPayload []byte `json:"compensationPayload"`
+	Compensated         bool   `json:"compensated"`
+	// Error of compensation if it failed.
+	CompensationError string `json:"compensationError,omitempty"`
+}
+
+// Record is state of saga tracked by correlation ID.
+type Record struct {
+	CorrelationID string       `json:"correlationId"`
+	State         State        `json:"state"`
+	Steps         []StepRecord `json:"steps"`
+	// Error failed saga step.
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Store keeps saga Records.
+type Store interface {
+	// Saves record replacing record with the same CorrelationID.
+	Save(ctx context.Context, record Record) error
+	// Returns record with correlationID and true or false if there is none.
+	Load(ctx context.Context, correlationID string) (Record, bool, error)
+	// Removes record with correlationID.
+	// Reports whether record existed.
+	Delete(ctx context.Context, correlationID string) (bool, error)
+}
@@ -0,0 +1,328 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/saga"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaga(t *testing.T) {
+	t.Run("Saga should complete when all steps succeed", testSagaShouldComplete)
+	t.Run("Saga should compensate completed steps in reverse order", testSagaShouldCompensateInReverseOrder)
+	t.Run("Saga should report failed compensations", testSagaShouldReportFailedCompensations)
+	t.Run("Saga should keep its record in file store", testSagaShouldKeepRecordInFileStore)
+	t.Run("Saga file store should compact itself", testSagaFileStoreShouldCompactItself)
+	t.Run("Saga should compensate after its context is done", testSagaShouldCompensateAfterContextIsDone)
+	t.Run("Saga should compensate step with several subscribers once", testSagaShouldCompensateFanOutStepOnce)
+}
+
+// records event types of handled Events.
+type handledEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *handledEvents) handler(eventType string, err error) command.Handler {
+	return &command.BaseHandler{
+		Type: eventType,
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			h.mu.Lock()
+			h.events = append(h.events, e.EventType()+":"+string(e.Payload()))
+			h.mu.Unlock()
+
+			if err != nil {
+				w.Write(command.NewErrEvent(e, err))
+			}
+		}}
+}
+
+func (h *handledEvents) get() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]string(nil), h.events...)
+}
+
+// returns Commands of reserve → charge → ship saga where ship fails with shipErr
+// and refund fails with refundErr.
+func orderSaga(s *saga.Saga, handled *handledEvents, shipErr, refundErr error) command.Commands {
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(s.Middleware())},
+		&command.BaseHandler{
+			Type: "reserve",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				w.Write(command.E{Type: "charge", P: e.Payload()})
+			}},
+		&command.BaseHandler{
+			Type: "charge",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				w.Write(command.E{Type: "ship", P: e.Payload()})
+			}},
+		handled.handler("ship", shipErr),
+		handled.handler("release", nil),
+		handled.handler("refund", refundErr),
+	)
+
+	return c
+}
+
+func orderSteps() []saga.Step {
+	return []saga.Step{
+		saga.Compensate("reserve", "release"),
+		saga.Compensate("charge", "refund"),
+		saga.Compensate("ship", "return")}
+}
+
+func testSagaShouldComplete(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	s := saga.New(saga.NewInMemory(), orderSteps()...)
+	handled := &handledEvents{}
+	c := orderSaga(s, handled, nil, nil)
+
+	ev := s.Handle(ctx, c, command.WithMetadata(command.E{Type: "reserve", P: []byte("42")},
+		tracing.M{EID: "order", ECausationID: "order", ECorrelationID: "order"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	result := saga.AsResult(ev)
+	if assert.NotNil(result, "saga result should be returned") {
+		assert.Equal(saga.Completed, result.State(), "saga should be completed")
+		assert.Len(result.Record().Steps, 3, "all steps should be recorded")
+	}
+
+	_, ok, err := s.Record(ctx, "order")
+	assert.NoError(err, "no error should be returned")
+	assert.False(ok, "record of completed saga should be deleted")
+	assert.Equal([]string{"ship:42"}, handled.get(), "no compensations should be handled")
+}
+
+func testSagaShouldCompensateInReverseOrder(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	s := saga.New(saga.NewInMemory(), orderSteps()...)
+	handled := &handledEvents{}
+	c := orderSaga(s, handled, errors.New("out of couriers"), nil)
+
+	ev := s.Handle(ctx, c, command.E{Type: "reserve", P: []byte("42")})
+	assert.Error(ev.Err(), "error of failed step should be returned")
+
+	result := saga.AsResult(ev)
+	if !assert.NotNil(result, "saga result should be returned") {
+		return
+	}
+
+	assert.Equal(saga.Compensated, result.State(), "saga should be compensated")
+	assert.Equal([]string{"ship:42", "refund:42", "release:42"}, handled.get(),
+		"completed steps should be compensated in reverse order")
+
+	record := result.Record()
+	assert.Len(record.Steps, 2, "failed step should not be recorded")
+	assert.NotEmpty(record.Error, "error of failed step should be recorded")
+
+	for _, step := range record.Steps {
+		assert.True(step.Compensated, "completed step should be compensated")
+	}
+}
+
+func testSagaShouldReportFailedCompensations(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	s := saga.New(saga.NewInMemory(), orderSteps()...)
+	handled := &handledEvents{}
+	c := orderSaga(s, handled, errors.New("out of couriers"), errors.New("card expired"))
+
+	ev := s.Handle(ctx, c, command.E{Type: "reserve", P: []byte("42")})
+	assert.Error(ev.Err(), "error should be returned")
+	assert.Contains(ev.Err().Error(), "card expired", "compensation error should be returned")
+
+	result := saga.AsResult(ev)
+	if !assert.NotNil(result, "saga result should be returned") {
+		return
+	}
+
+	assert.Equal(saga.CompensationFailed, result.State(), "saga compensation should fail")
+	assert.Equal([]string{"ship:42", "refund:42", "release:42"}, handled.get(),
+		"remaining steps should be compensated")
+
+	steps := result.Record().Steps
+	if assert.Len(steps, 2, "completed steps should be recorded") {
+		assert.True(steps[0].Compensated, "reserve should be compensated")
+		assert.False(steps[1].Compensated, "charge should not be compensated")
+		assert.NotEmpty(steps[1].CompensationError, "compensation error should be recorded")
+	}
+}
+
+func testSagaShouldKeepRecordInFileStore(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "saga.log")
+	store, err := saga.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	s := saga.New(store, orderSteps()...)
+	c := orderSaga(s, &handledEvents{}, errors.New("out of couriers"), errors.New("card expired"))
+	s.Handle(ctx, c, command.WithMetadata(command.E{Type: "reserve"},
+		tracing.M{EID: "order", ECausationID: "order", ECorrelationID: "order"}))
+	s.Handle(ctx, orderSaga(s, &handledEvents{}, nil, nil), command.WithMetadata(command.E{Type: "reserve"},
+		tracing.M{EID: "completed", ECausationID: "completed", ECorrelationID: "completed"}))
+	assert.NoError(store.Close(), "no error should be returned")
+
+	store, err = saga.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	assert.NoError(store.Compact(), "no error should be returned")
+
+	record, ok, err := store.Load(ctx, "order")
+	assert.NoError(err, "no error should be returned")
+	assert.True(ok, "saga record should be loaded")
+	assert.Equal(saga.CompensationFailed, record.State, "final state should be loaded")
+	assert.Len(record.Steps, 2, "completed steps should be loaded")
+
+	_, ok, _ = store.Load(ctx, "completed")
+	assert.False(ok, "record of completed saga should not be loaded")
+}
+
+func testSagaFileStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "saga.log")
+	store, err := saga.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.NoError(store.Save(ctx, saga.Record{CorrelationID: "running", State: saga.Running}))
+
+	s := saga.New(store, orderSteps()...)
+	c := orderSaga(s, &handledEvents{}, nil, nil)
+	for i := 0; i < 50; i++ {
+		assert.NoError(s.Handle(ctx, c, command.E{Type: "reserve"}).Err(), "no error should be returned")
+	}
+
+	assert.NoError(store.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "records of finished sagas should be removed")
+
+	store, err = saga.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	_, ok, _ := store.Load(ctx, "running")
+	assert.True(ok, "record of running saga should survive compaction")
+}
+
+func testSagaShouldCompensateAfterContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := saga.New(saga.NewInMemory(), orderSteps()...)
+	var compensationErr error
+	var compensationState saga.State
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(s.Middleware())},
+		&command.BaseHandler{
+			Type: "reserve",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				w.Write(command.E{Type: "charge", P: e.Payload()})
+			}},
+		&command.BaseHandler{
+			Type: "charge",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				cancel()
+				w.Write(command.NewErrEvent(e, ctx.Err()))
+			}},
+		&command.BaseHandler{
+			Type: "release",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				compensationErr = ctx.Err()
+				record, _, _ := s.Record(ctx, "order")
+				compensationState = record.State
+			}},
+	)
+
+	ev := s.Handle(ctx, c, command.WithMetadata(command.E{Type: "reserve", P: []byte("42")},
+		tracing.M{EID: "order", ECausationID: "order", ECorrelationID: "order"}))
+	assert.Error(ev.Err(), "error of failed step should be returned")
+
+	result := saga.AsResult(ev)
+	if assert.NotNil(result, "saga result should be returned") {
+		assert.Equal(saga.Compensated, result.State(), "saga should be compensated")
+	}
+
+	assert.NoError(compensationErr, "compensation should not be cancelled")
+	assert.Equal(saga.Compensating, compensationState, "saga should be compensating until compensations are done")
+
+	_, ok, err := s.Record(context.TODO(), "order")
+	assert.NoError(err, "no error should be returned")
+	assert.False(ok, "record of compensated saga should be deleted")
+}
+
+func testSagaShouldCompensateFanOutStepOnce(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	s := saga.New(saga.NewInMemory(), orderSteps()...)
+	handled := &handledEvents{}
+	c, _ := command.NewWithOptions(
+		[]command.Option{command.WithMiddleware(s.Middleware())},
+		handled.handler("reserve", nil),
+		&command.BaseHandler{
+			Type: "reserve",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				w.Write(command.E{Type: "ship", P: e.Payload()})
+			}},
+		handled.handler("ship", errors.New("out of couriers")),
+		handled.handler("release", nil),
+	)
+
+	ev := s.Handle(ctx, c, command.E{Type: "reserve", P: []byte("42")})
+	assert.Error(ev.Err(), "error of failed step should be returned")
+
+	result := saga.AsResult(ev)
+	if !assert.NotNil(result, "saga result should be returned") {
+		return
+	}
+
+	assert.Equal(saga.Compensated, result.State(), "saga should be compensated")
+	assert.Len(result.Record().Steps, 1, "step should be recorded once")
+	assert.ElementsMatch([]string{"reserve:42", "ship:42", "release:42"}, handled.get(),
+		"step should be compensated once")
+}
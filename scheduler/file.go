@@ -0,0 +1,145 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of records FileStore keeps in underlying file on top of pending entries
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns Store kept in append-only file at path.
+// Pending entries already stored in the file are loaded.
+// If sync is true every change is flushed to disk before returning.
+// File is compacted automatically once it holds compactAfter records more than pending entries.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var r schedulerRecord
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+
+		s.appended++
+		if r.Entry != nil {
+			return s.memory.Add(context.TODO(), *r.Entry)
+		}
+
+		_, err := s.memory.Remove(context.TODO(), r.Removed)
+
+		return err
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements Store.
+type FileStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryStore
+	// amount of records in underlying file
+	appended int
+}
+
+type schedulerRecord struct {
+	Entry   *Entry `json:"entry,omitempty"`
+	Removed string `json:"removed,omitempty"`
+}
+
+func (s *FileStore) Add(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(schedulerRecord{Entry: &entry}); err != nil {
+		return err
+	}
+
+	if err := s.memory.Add(ctx, entry); err != nil {
+		return err
+	}
+
+	return s.compactIfNeeded()
+}
+
+func (s *FileStore) Remove(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok, _ := s.memory.Get(ctx, id); !ok {
+		return false, nil
+	}
+
+	if err := s.log.Append(schedulerRecord{Removed: id}); err != nil {
+		return false, err
+	}
+
+	ok, err := s.memory.Remove(ctx, id)
+	if err != nil {
+		return ok, err
+	}
+
+	return ok, s.compactIfNeeded()
+}
+
+func (s *FileStore) Get(ctx context.Context, id string) (Entry, bool, error) {
+	return s.memory.Get(ctx, id)
+}
+
+func (s *FileStore) Pending(ctx context.Context) ([]Entry, error) {
+	return s.memory.Pending(ctx)
+}
+
+// Rewrites underlying file keeping only pending entries.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+// compacts underlying file once it holds compactAfter records more than pending entries.
+func (s *FileStore) compactIfNeeded() error {
+	if s.appended++; s.appended < compactAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	entries, _ := s.memory.Pending(context.TODO())
+	records := make([]interface{}, 0, len(entries))
+	for i := range entries {
+		records = append(records, schedulerRecord{Entry: &entries[i]})
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
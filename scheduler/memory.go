@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Returns Store that keeps entries in memory.
+func NewInMemory() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: make(map[string]Entry)}
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	// IDs of pending entries in order they were added
+	order   []string
+	entries map[string]Entry
+}
+
+func (s *memoryStore) Add(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[entry.ID]; !ok {
+		s.order = append(s.order, entry.ID)
+	}
+
+	s.entries[entry.ID] = entry
+
+	return nil
+}
+
+func (s *memoryStore) Remove(ctx context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return false, nil
+	}
+
+	delete(s.entries, id)
+	for i, pending := range s.order {
+		if pending == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+
+			break
+		}
+	}
+
+	return true, nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (Entry, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.entries[id]
+
+	return entry, ok, nil
+}
+
+func (s *memoryStore) Pending(ctx context.Context) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, 0, len(s.order))
+	for _, id := range s.order {
+		entries = append(entries, s.entries[id])
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Due.Before(entries[j].Due) })
+
+	return entries, nil
+}
+
+func (s *memoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.order)
+}
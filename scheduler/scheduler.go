@@ -0,0 +1,348 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/google/uuid"
+)
+
+// Option configures Scheduler.
+type Option func(*Scheduler)
+
+// Sets delay before Event which could not be dispatched is dispatched again.
+// Defaults to one second.
+func WithRetryInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		s.retryInterval = interval
+	}
+}
+
+// Sets eventSink receiving *command.ErrEvent for every Event which could not be dispatched.
+func WithErrorSink(eventSink func(command.Event)) Option {
+	return func(s *Scheduler) {
+		s.eventSink = eventSink
+	}
+}
+
+// Returns Dispatch that handles Events with c and passes results to eventSink.
+// Every Event is handled in its own goroutine, so slow chain does not delay other due Events.
+// Use Worker to limit amount of Events handled concurrently.
+func Commands(c command.Commands, eventSink func(command.Event)) Dispatch {
+	return func(ctx context.Context, event command.Event) error {
+		go func() {
+			eventSink(c.Handle(ctx, event))
+		}()
+
+		return nil
+	}
+}
+
+// Returns Dispatch that puts Events to w.
+func Worker(w command.CommandsWorker) Dispatch {
+	return func(ctx context.Context, event command.Event) error {
+		return w.HandleContext(ctx, event)
+	}
+}
+
+// Returns Scheduler keeping Events in store.
+// Events are dispatched when they are due only while Scheduler.Run is running.
+func New(store Store, options ...Option) *Scheduler {
+	s := &Scheduler{
+		store:         store,
+		retryInterval: time.Second,
+		eventSink:     func(command.Event) {},
+		wake:          make(chan struct{}, 1)}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// Scheduler delivers Events at their due time.
+type Scheduler struct {
+	store         Store
+	retryInterval time.Duration
+	eventSink     func(command.Event)
+
+	// signals Run that entries were changed
+	wake chan struct{}
+}
+
+// Schedules event to be dispatched at due.
+// Returns ID of scheduled entry, which is ID of event Metadata if it has one.
+func (s *Scheduler) Schedule(ctx context.Context, event command.Event, due time.Time) (string, error) {
+	entry := newEntry(event, due)
+	if err := s.store.Add(ctx, entry); err != nil {
+		return "", err
+	}
+
+	s.notify()
+
+	return entry.ID, nil
+}
+
+// Schedules event to be dispatched after delay.
+// Returns ID of scheduled entry.
+func (s *Scheduler) ScheduleAfter(ctx context.Context, event command.Event, delay time.Duration) (string, error) {
+	return s.Schedule(ctx, event, time.Now().Add(delay))
+}
+
+// Cancels entry with id.
+// Reports whether entry with id was pending.
+func (s *Scheduler) Cancel(ctx context.Context, id string) (bool, error) {
+	ok, err := s.store.Remove(ctx, id)
+	if ok {
+		s.notify()
+	}
+
+	return ok, err
+}
+
+// Returns pending entries ordered by due time.
+func (s *Scheduler) Pending(ctx context.Context) ([]Entry, error) {
+	return s.store.Pending(ctx)
+}
+
+// Passes due Events to dispatch until ctx is done.
+// Entry is removed from Store once its Event is dispatched,
+// so Events left pending after restart are dispatched again.
+// Returns ctx error or Store error.
+func (s *Scheduler) Run(ctx context.Context, dispatch Dispatch) error {
+	timer := time.NewTimer(0)
+
+	defer timer.Stop()
+
+	for {
+		wait, err := s.dispatchDue(ctx, dispatch)
+		if err != nil {
+			return err
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		var next <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			next = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.wake:
+		case <-next:
+		}
+	}
+}
+
+// Returns command.Handler of ScheduleEventType Events.
+// Handler schedules Events with type following ScheduleEventTypePrefix
+// and payload of Request or Events returned by At and After.
+// It writes *command.DoneEvent with Scheduled payload.
+func (s *Scheduler) Handler() command.Handler {
+	return &command.BaseHandler{
+		Type:        ScheduleEventType("*"),
+		SideEffects: true,
+		HandleFunc:  s.handle}
+}
+
+func (s *Scheduler) handle(ctx context.Context, w command.EventWriter, event command.Event) {
+	defer w.Done()
+
+	scheduled, due, err := unwrapScheduled(event, time.Now())
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	if command.AsEventWithMetadata(scheduled) == nil {
+		scheduled = command.WithMetadata(scheduled, command.MetadataOf(event).New(uuid.New().String()))
+	}
+
+	id, err := s.Schedule(ctx, scheduled, due)
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	b, err := json.Marshal(Scheduled{ID: id, Due: due})
+	if err != nil {
+		w.Write(command.NewErrEvent(event, err))
+
+		return
+	}
+
+	w.Write(command.Done(command.E{Type: event.EventType(), P: b}))
+}
+
+// dispatches due entries and returns time until next entry is due or -1 if there are no entries.
+func (s *Scheduler) dispatchDue(ctx context.Context, dispatch Dispatch) (time.Duration, error) {
+	entries, err := s.store.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := time.Duration(-1)
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+
+		now := time.Now()
+		if entry.Due.After(now) {
+			if until := entry.Due.Sub(now); wait < 0 || until < wait {
+				wait = until
+			}
+
+			continue
+		}
+
+		if err := dispatch(ctx, entry.Event()); err != nil {
+			s.eventSink(command.NewErrEvent(entry.Event(), err))
+
+			if _, ok, _ := s.store.Get(ctx, entry.ID); !ok {
+				continue
+			}
+
+			entry.Due = now.Add(s.retryInterval)
+			if err := s.store.Add(ctx, entry); err != nil {
+				return 0, err
+			}
+
+			if wait < 0 || s.retryInterval < wait {
+				wait = s.retryInterval
+			}
+
+			continue
+		}
+
+		if _, err := s.store.Remove(ctx, entry.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return wait, nil
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Returns Event that schedules event to be dispatched at due when handled by Scheduler.Handler.
+func At(event command.Event, due time.Time) command.Event {
+	return &scheduledEvent{event: event, due: due}
+}
+
+// Returns Event that schedules event to be dispatched after delay when handled by Scheduler.Handler.
+// Delay is counted from the moment Event is handled.
+func After(event command.Event, delay time.Duration) command.Event {
+	return &scheduledEvent{event: event, delay: delay}
+}
+
+type scheduledEvent struct {
+	event command.Event
+	due   time.Time
+	delay time.Duration
+}
+
+func (e *scheduledEvent) EventType() string {
+	return ScheduleEventType(e.event.EventType())
+}
+
+func (e *scheduledEvent) Payload() []byte {
+	payload := json.RawMessage(e.event.Payload())
+	if !json.Valid(payload) {
+		payload, _ = json.Marshal(string(e.event.Payload()))
+	}
+
+	request := Request{Due: e.due, Payload: payload}
+	if e.due.IsZero() {
+		request.Delay = e.delay.String()
+	}
+
+	b, _ := json.Marshal(request)
+
+	return b
+}
+
+func (e *scheduledEvent) Err() error {
+	return e.event.Err()
+}
+
+func (e *scheduledEvent) Event() command.Event {
+	return e.event
+}
+
+// returns Event scheduled by event and its due time.
+func unwrapScheduled(event command.Event, now time.Time) (command.Event, time.Time, error) {
+	for e := event; e != nil; e = command.Unwrap(e) {
+		if scheduled, ok := e.(*scheduledEvent); ok {
+			if scheduled.due.IsZero() {
+				return scheduled.event, now.Add(scheduled.delay), nil
+			}
+
+			return scheduled.event, scheduled.due, nil
+		}
+	}
+
+	eventType := strings.TrimPrefix(event.EventType(), ScheduleEventTypePrefix)
+	if eventType == "" || eventType == event.EventType() {
+		return nil, time.Time{}, fmt.Errorf("scheduler: event type %q does not name scheduled event", event.EventType())
+	}
+
+	var request Request
+	if err := json.Unmarshal(event.Payload(), &request); err != nil {
+		return nil, time.Time{}, fmt.Errorf("scheduler: request format: %w", err)
+	}
+
+	scheduled := command.E{Type: eventType, P: request.Payload}
+	if !request.Due.IsZero() {
+		return scheduled, request.Due, nil
+	}
+
+	if request.Delay == "" {
+		return scheduled, now, nil
+	}
+
+	delay, err := time.ParseDuration(request.Delay)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("scheduler: request format: %w", err)
+	}
+
+	return scheduled, now.Add(delay), nil
+}
+
+// returns new Entry of event due at due.
+func newEntry(event command.Event, due time.Time) Entry {
+	m := command.MetadataOf(event)
+	if m.ID() == "" {
+		id := uuid.New().String()
+		m = tracing.M{EID: id, ECausationID: id, ECorrelationID: id}
+	}
+
+	entry := Entry{E: record.New(event, m), Due: due, Time: time.Now().UTC()}
+	if priority := command.PriorityOf(event); priority != command.PriorityNormal {
+		entry.Priority = &priority
+	}
+
+	return entry
+}
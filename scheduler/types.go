@@ -0,0 +1,66 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/internal/record"
+)
+
+// Prefix of event types handled by Scheduler.Handler.
+const ScheduleEventTypePrefix = "SCHEDULE#"
+
+// Returns event type of Event that schedules eventType Event.
+func ScheduleEventType(eventType string) string { return ScheduleEventTypePrefix + eventType }
+
+// Entry is an Event scheduled for delivery at Due.
+type Entry struct {
+	record.E
+	// Priority of Event if it is not command.PriorityNormal.
+	Priority *command.Priority `json:"priority,omitempty"`
+
+	Due  time.Time `json:"due"`
+	Time time.Time `json:"time"`
+}
+
+// Returns scheduled Event with its original Metadata.
+func (e Entry) Event() command.EventWithMetadata {
+	recorded := e.E.Event()
+	if e.Priority == nil {
+		return recorded
+	}
+
+	return command.WithMetadata(command.WithPriority(recorded, *e.Priority), recorded.Metadata())
+}
+
+// Store keeps Entries until they are delivered or cancelled.
+type Store interface {
+	// Records entry. Entry with the same ID is replaced.
+	Add(ctx context.Context, entry Entry) error
+	// Removes entry with id.
+	// Reports whether entry with id existed.
+	Remove(ctx context.Context, id string) (bool, error)
+	// Returns entry with id and true or false if there is none.
+	Get(ctx context.Context, id string) (Entry, bool, error)
+	// Returns entries ordered by Due, entries due at the same time are in order they were added.
+	Pending(ctx context.Context) ([]Entry, error)
+}
+
+// Delivers due Event.
+type Dispatch func(ctx context.Context, event command.Event) error
+
+// Request is payload of ScheduleEventType Events.
+// Event is due at Due or after Delay (see time.ParseDuration) if Due is not set.
+type Request struct {
+	Due     time.Time       `json:"due,omitempty"`
+	Delay   string          `json:"delay,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Payload of Done Event written by Scheduler.Handler.
+type Scheduled struct {
+	ID  string    `json:"id"`
+	Due time.Time `json:"due"`
+}
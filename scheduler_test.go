@@ -0,0 +1,364 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/scheduler"
+	"github.com/andriiyaremenko/tinycqs/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduler(t *testing.T) {
+	t.Run("Scheduler should dispatch events when they are due", testSchedulerShouldDispatchDueEvents)
+	t.Run("Scheduler should cancel scheduled events", testSchedulerShouldCancelEvents)
+	t.Run("Scheduler should schedule events written by handlers", testSchedulerShouldScheduleEventsWrittenByHandlers)
+	t.Run("Scheduler should schedule events requested by clients", testSchedulerShouldScheduleRequestedEvents)
+	t.Run("Scheduler should retry events which could not be dispatched", testSchedulerShouldRetryFailedDispatch)
+	t.Run("Scheduler should dispatch pending events on restart", testSchedulerShouldDispatchOnRestart)
+	t.Run("Scheduler file store should compact itself", testSchedulerFileStoreShouldCompactItself)
+	t.Run("Scheduler should not wait for slow chain to dispatch other events",
+		testSchedulerShouldNotWaitForSlowChain)
+}
+
+// returns Dispatch sending types of dispatched Events to returned channel.
+func recordingDispatch() (scheduler.Dispatch, <-chan string) {
+	dispatched := make(chan string, 100)
+
+	return func(ctx context.Context, event command.Event) error {
+		dispatched <- event.EventType()
+
+		return nil
+	}, dispatched
+}
+
+// returns scheduler.Entry of eventType Event with id due at due.
+func schedulerEntry(id, eventType string, due time.Time) scheduler.Entry {
+	entry := scheduler.Entry{Due: due}
+	entry.ID, entry.EventType = id, eventType
+
+	return entry
+}
+
+func testSchedulerShouldDispatchDueEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	dispatch, dispatched := recordingDispatch()
+
+	go s.Run(ctx, dispatch)
+
+	start := time.Now()
+	_, err := s.ScheduleAfter(ctx, command.E{Type: "later"}, time.Millisecond*100)
+	assert.NoError(err, "no error should be returned")
+	_, err = s.ScheduleAfter(ctx, command.E{Type: "sooner"}, time.Millisecond*50)
+	assert.NoError(err, "no error should be returned")
+
+	assert.Equal("sooner", <-dispatched, "sooner event should be dispatched first")
+	assert.Equal("later", <-dispatched, "later event should be dispatched second")
+	assert.True(time.Since(start) >= time.Millisecond*100, "events should not be dispatched before they are due")
+
+	assert.Eventually(func() bool {
+		pending, _ := s.Pending(ctx)
+
+		return len(pending) == 0
+	}, time.Second, time.Millisecond*10, "dispatched events should not be pending")
+}
+
+func testSchedulerShouldCancelEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	dispatch, dispatched := recordingDispatch()
+
+	go s.Run(ctx, dispatch)
+
+	id, err := s.ScheduleAfter(ctx, command.E{Type: "cancelled"}, time.Millisecond*50)
+	assert.NoError(err, "no error should be returned")
+	_, err = s.ScheduleAfter(ctx, command.E{Type: "kept"}, time.Millisecond*100)
+	assert.NoError(err, "no error should be returned")
+
+	pending, err := s.Pending(ctx)
+	assert.NoError(err, "no error should be returned")
+	assert.Len(pending, 2, "both events should be pending")
+	assert.Equal(id, pending[0].ID, "pending events should be ordered by due time")
+
+	ok, err := s.Cancel(ctx, id)
+	assert.NoError(err, "no error should be returned")
+	assert.True(ok, "pending event should be cancelled")
+
+	ok, _ = s.Cancel(ctx, id)
+	assert.False(ok, "cancelled event should not be pending")
+
+	assert.Equal("kept", <-dispatched, "only kept event should be dispatched")
+}
+
+func testSchedulerShouldScheduleEventsWrittenByHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	reminded := make(chan string, 1)
+	c, _ := command.New(
+		s.Handler(),
+		&command.BaseHandler{
+			Type: "order",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				w.Write(scheduler.After(command.E{Type: "remind", P: e.Payload()}, time.Millisecond*50))
+			}},
+		&command.BaseHandler{
+			Type: "remind",
+			HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+				defer w.Done()
+
+				reminded <- string(e.Payload())
+			}},
+	)
+
+	go s.Run(ctx, scheduler.Commands(c, func(command.Event) {}))
+
+	ev := c.Handle(ctx, command.WithMetadata(command.E{Type: "order", P: []byte("42")},
+		tracing.M{EID: "order-1", ECausationID: "order-1", ECorrelationID: "order-1"}))
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	pending, _ := s.Pending(ctx)
+	if assert.Len(pending, 1, "reminder should be pending") {
+		assert.Equal("order-1", pending[0].CorrelationID, "reminder should be correlated with order")
+	}
+
+	select {
+	case payload := <-reminded:
+		assert.Equal("42", payload, "reminder should keep its payload")
+	case <-time.After(time.Second):
+		assert.Fail("reminder should be dispatched")
+	}
+}
+
+func testSchedulerShouldScheduleRequestedEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	s := scheduler.New(scheduler.NewInMemory())
+	c, _ := command.New(s.Handler())
+
+	ev := c.Handle(ctx, command.E{
+		Type: scheduler.ScheduleEventType("remind"),
+		P:    []byte(`{"delay": "1h", "payload": {"order": 42}}`)})
+	assert.NoError(ev.Err(), "no error should be returned")
+
+	var result struct {
+		Payload []struct {
+			Payload scheduler.Scheduled `json:"payload"`
+		} `json:"payload"`
+	}
+
+	if err := json.Unmarshal(ev.Payload(), &result); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.Len(result.Payload, 1, "result should contain scheduled entry")
+
+	pending, _ := s.Pending(ctx)
+	if assert.Len(pending, 1, "requested event should be pending") {
+		assert.Equal(result.Payload[0].Payload.ID, pending[0].ID, "result should contain ID of scheduled entry")
+		assert.Equal("remind", pending[0].EventType, "requested event type should be scheduled")
+		assert.JSONEq(`{"order": 42}`, string(pending[0].Payload), "requested payload should be scheduled")
+		assert.WithinDuration(time.Now().Add(time.Hour), pending[0].Due, time.Minute, "event should be due after delay")
+	}
+
+	ev = c.Handle(ctx, command.E{Type: scheduler.ScheduleEventType("remind"), P: []byte(`{"delay": "soon"}`)})
+	assert.Error(ev.Err(), "invalid request should return error")
+}
+
+func testSchedulerShouldRetryFailedDispatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	failures := make(chan command.Event, 10)
+	s := scheduler.New(scheduler.NewInMemory(),
+		scheduler.WithRetryInterval(time.Millisecond*20),
+		scheduler.WithErrorSink(func(e command.Event) { failures <- e }))
+	attempts := 0
+	dispatched := make(chan struct{})
+
+	go s.Run(ctx, func(ctx context.Context, event command.Event) error {
+		if attempts++; attempts < 3 {
+			return errors.New("worker is busy")
+		}
+
+		close(dispatched)
+
+		return nil
+	})
+
+	_, err := s.ScheduleAfter(ctx, command.E{Type: "test"}, 0)
+	assert.NoError(err, "no error should be returned")
+
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		assert.FailNow("event should be dispatched again")
+	}
+
+	assert.Len(failures, 2, "every failed dispatch should be reported")
+}
+
+func testSchedulerShouldDispatchOnRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "scheduler.log")
+	store, err := scheduler.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	s := scheduler.New(store)
+	_, err = s.ScheduleAfter(ctx, command.WithPriority(command.E{Type: "kept", P: []byte("42")}, command.PriorityHigh),
+		time.Millisecond*50)
+	assert.NoError(err, "no error should be returned")
+	id, _ := s.ScheduleAfter(ctx, command.E{Type: "cancelled"}, time.Millisecond*50)
+	s.Cancel(ctx, id)
+	assert.NoError(store.Close(), "no error should be returned")
+
+	store, err = scheduler.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	assert.NoError(store.Compact(), "no error should be returned")
+
+	dispatched := make(chan command.Event, 10)
+	c, _ := command.New(&command.BaseHandler{
+		Type: "kept",
+		HandleFunc: func(ctx context.Context, w command.EventWriter, e command.Event) {
+			defer w.Done()
+
+			dispatched <- e
+		}})
+	w := command.NewWorker(ctx, func(command.CommandsWorker, command.Event) {}, c, 1)
+
+	go scheduler.New(store).Run(ctx, scheduler.Worker(w))
+
+	select {
+	case e := <-dispatched:
+		assert.Equal("42", string(e.Payload()), "payload should be restored")
+		assert.Equal(command.PriorityHigh, command.PriorityOf(e), "priority should be restored")
+	case <-time.After(time.Second):
+		assert.FailNow("pending event should be dispatched after restart")
+	}
+
+	assert.Eventually(func() bool {
+		pending, _ := store.Pending(ctx)
+
+		return len(pending) == 0
+	}, time.Second, time.Millisecond*10, "dispatched event should not be pending")
+}
+
+func testSchedulerFileStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "scheduler.log")
+	store, err := scheduler.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	due := time.Now().Add(time.Hour).UTC()
+	for i := 0; i < 250; i++ {
+		// every retry re-appends the whole entry
+		assert.NoError(store.Add(ctx, schedulerEntry("retried", "remind", due)))
+	}
+
+	for i := 0; i < 250; i++ {
+		id := strconv.Itoa(i)
+		assert.NoError(store.Add(ctx, schedulerEntry(id, "remind", due)))
+		_, err := store.Remove(ctx, id)
+		assert.NoError(err, "no error should be returned")
+	}
+
+	assert.NoError(store.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "stale entries should be removed")
+
+	store, err = scheduler.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	pending, _ := store.Pending(ctx)
+	if assert.Len(pending, 1, "pending entry should survive compaction") {
+		assert.Equal("retried", pending[0].ID)
+	}
+}
+
+func testSchedulerShouldNotWaitForSlowChain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	defer cancel()
+
+	assert := assert.New(t)
+	release := make(chan struct{})
+
+	defer close(release)
+
+	fast := make(chan struct{})
+	c, _ := command.New(
+		command.HandlerFunc("slow", func(context.Context, []byte) error {
+			<-release
+			return nil
+		}),
+		command.HandlerFunc("fast", func(context.Context, []byte) error {
+			close(fast)
+			return nil
+		}),
+	)
+
+	s := scheduler.New(scheduler.NewInMemory())
+	now := time.Now()
+	_, err := s.Schedule(ctx, command.E{Type: "slow"}, now.Add(-time.Millisecond))
+	assert.NoError(err, "no error should be returned")
+	_, err = s.Schedule(ctx, command.E{Type: "fast"}, now)
+	assert.NoError(err, "no error should be returned")
+
+	go s.Run(ctx, scheduler.Commands(c, func(command.Event) {}))
+
+	select {
+	case <-fast:
+	case <-time.After(time.Second):
+		assert.FailNow("due event should not wait for slow chain")
+	}
+}
@@ -0,0 +1,106 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/andriiyaremenko/tinycqs/internal/filelog"
+)
+
+// Amount of snapshots FileStore keeps in underlying file on top of latest ones
+// before it compacts the file.
+const compactAfter = 100
+
+// Returns Store kept in append-only file at path.
+// Snapshots already stored in the file are loaded.
+// If sync is true every Save is flushed to disk before returning.
+// File is compacted automatically once it holds compactAfter snapshots more than latest ones.
+func NewFileStore(path string, sync bool) (*FileStore, error) {
+	log, err := filelog.Open(path, sync)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{log: log, memory: newMemoryStore()}
+	err = log.ReadAll(func(b json.RawMessage) error {
+		var snapshot Snapshot
+		if err := json.Unmarshal(b, &snapshot); err != nil {
+			return err
+		}
+
+		s.appended++
+
+		return s.memory.Save(context.TODO(), snapshot)
+	})
+
+	if err != nil {
+		log.Close()
+
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// *FileStore implements Store.
+type FileStore struct {
+	mu sync.Mutex
+
+	log    *filelog.Log
+	memory *memoryStore
+	// amount of snapshots in underlying file
+	appended int
+}
+
+func (s *FileStore) Save(ctx context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Append(snapshot); err != nil {
+		return err
+	}
+
+	if err := s.memory.Save(ctx, snapshot); err != nil {
+		return err
+	}
+
+	if s.appended++; s.appended < compactAfter+s.memory.len() {
+		return nil
+	}
+
+	return s.compact()
+}
+
+func (s *FileStore) Load(ctx context.Context, id string) (Snapshot, bool, error) {
+	return s.memory.Load(ctx, id)
+}
+
+// Rewrites underlying file keeping only latest snapshot of every entity.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.compact()
+}
+
+func (s *FileStore) compact() error {
+	snapshots := s.memory.all()
+	records := make([]interface{}, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		records = append(records, snapshot)
+	}
+
+	if err := s.log.Rewrite(records...); err != nil {
+		return err
+	}
+
+	s.appended = len(records)
+
+	return nil
+}
+
+// Closes underlying file.
+func (s *FileStore) Close() error {
+	return s.log.Close()
+}
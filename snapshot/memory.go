@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"context"
+	"sync"
+)
+
+// Returns Store that keeps snapshots in memory.
+func NewInMemory() Store {
+	return newMemoryStore()
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{snapshots: make(map[string]Snapshot)}
+}
+
+type memoryStore struct {
+	mu sync.RWMutex
+
+	snapshots map[string]Snapshot
+}
+
+func (s *memoryStore) Save(ctx context.Context, snapshot Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if current, ok := s.snapshots[snapshot.ID]; ok && current.Version > snapshot.Version {
+		return nil
+	}
+
+	s.snapshots[snapshot.ID] = snapshot
+
+	return nil
+}
+
+func (s *memoryStore) Load(ctx context.Context, id string) (Snapshot, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[id]
+
+	return snapshot, ok, nil
+}
+
+func (s *memoryStore) all() []Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshots := make([]Snapshot, 0, len(s.snapshots))
+	for _, snapshot := range s.snapshots {
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots
+}
+
+func (s *memoryStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.snapshots)
+}
@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+)
+
+// Repository restores Snapshotters from latest Snapshot and Events appended after it.
+// Can be used in command.Handler to restore entity state before deciding.
+type Repository struct {
+	Snapshots Store
+	Events    eventstore.EventStore
+	Policy    Policy
+}
+
+// Restores state of entity with id from latest snapshot
+// and Events of stream appended after snapshot was taken.
+// Returns stream version state was restored to.
+func (r *Repository) Load(ctx context.Context, streamID, id string, state Snapshotter) (int64, error) {
+	version := eventstore.NoStream
+
+	snapshot, ok, err := r.Snapshots.Load(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+
+	if ok {
+		err := state.UnmarshalSnapshot(snapshot.SchemaVersion, snapshot.Data)
+		if err != nil && !errors.Is(err, ErrIncompatibleSnapshot) {
+			return 0, err
+		}
+
+		if err == nil {
+			version = snapshot.Version
+		}
+	}
+
+	records, err := r.Events.ReadStream(ctx, streamID, version+1, eventstore.Forward, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range records {
+		if err := state.Apply(record.Event()); err != nil {
+			return 0, err
+		}
+
+		version = record.Version
+	}
+
+	return version, nil
+}
+
+// Takes snapshot of state at version if Policy requires it after stream grew from version from.
+func (r *Repository) Update(ctx context.Context, id string, from, version int64, state Snapshotter) error {
+	if !r.Policy.ShouldSnapshot(from, version) {
+		return nil
+	}
+
+	return r.Snapshot(ctx, id, version, state)
+}
+
+// Takes snapshot of state at version.
+func (r *Repository) Snapshot(ctx context.Context, id string, version int64, state Snapshotter) error {
+	schemaVersion, data, err := state.MarshalSnapshot()
+	if err != nil {
+		return err
+	}
+
+	return r.Snapshots.Save(ctx, Snapshot{
+		ID:            id,
+		Version:       version,
+		SchemaVersion: schemaVersion,
+		Data:          data,
+		Time:          time.Now().UTC()})
+}
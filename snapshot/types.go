@@ -0,0 +1,59 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/andriiyaremenko/tinycqs/command"
+)
+
+// Returned by Snapshotter.UnmarshalSnapshot if snapshot was serialized with unsupported schema.
+// Snapshot is ignored and state is restored from all Events instead.
+var ErrIncompatibleSnapshot = errors.New("snapshot schema is not supported")
+
+// Snapshot is serialized state of entity at particular stream version.
+type Snapshot struct {
+	// Entity ID.
+	ID string `json:"id"`
+	// Version of entity stream snapshot was taken at.
+	Version int64 `json:"version"`
+	// Version of Data serialization format.
+	SchemaVersion int       `json:"schemaVersion"`
+	Data          []byte    `json:"data"`
+	Time          time.Time `json:"time"`
+}
+
+// Store keeps latest Snapshot of every entity.
+type Store interface {
+	// Saves snapshot replacing older snapshots of the same entity.
+	Save(ctx context.Context, snapshot Snapshot) error
+	// Returns latest snapshot of entity with id and true or false if there is none.
+	Load(ctx context.Context, id string) (Snapshot, bool, error)
+}
+
+// Snapshotter is state that can be restored from Snapshot.
+type Snapshotter interface {
+	// Applies Event to state.
+	Apply(event command.Event) error
+	// Returns serialized state and version of serialization format.
+	MarshalSnapshot() (schemaVersion int, data []byte, err error)
+	// Restores state from data serialized with schemaVersion format.
+	// Returns ErrIncompatibleSnapshot without modifying state if schemaVersion is not supported.
+	UnmarshalSnapshot(schemaVersion int, data []byte) error
+}
+
+// Policy decides when snapshot should be taken.
+type Policy struct {
+	// Snapshot is taken every Every Events. 0 means snapshots are taken only on demand.
+	Every int64
+}
+
+// Reports whether snapshot should be taken after stream grew from version from to version to.
+func (p Policy) ShouldSnapshot(from, to int64) bool {
+	if p.Every < 1 {
+		return false
+	}
+
+	return to/p.Every > from/p.Every
+}
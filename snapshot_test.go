@@ -0,0 +1,319 @@
+package tinycqs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/andriiyaremenko/tinycqs/aggregate"
+	"github.com/andriiyaremenko/tinycqs/command"
+	"github.com/andriiyaremenko/tinycqs/eventstore"
+	"github.com/andriiyaremenko/tinycqs/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Run("Snapshot policy should take snapshot every N events", testSnapshotPolicy)
+	t.Run("Repository should fold only events newer than snapshot", testSnapshotRepositoryShouldFoldNewerEvents)
+	t.Run("Repository should ignore incompatible snapshot", testSnapshotRepositoryShouldIgnoreIncompatibleSnapshot)
+	t.Run("File store should keep latest snapshots", testSnapshotFileStore)
+	t.Run("File store should compact itself", testSnapshotFileStoreShouldCompactItself)
+	t.Run("Aggregate handler should take and restore snapshots", testSnapshotAggregateHandler)
+	t.Run("Aggregate handler should not fail command on snapshot error", testSnapshotErrorShouldNotFailCommand)
+	t.Run("Aggregate handlers should key snapshots by stream ID", testSnapshotsShouldBeKeyedByStreamID)
+}
+
+// snapshot.Store failing to save snapshots.
+type failingSnapshots struct {
+	snapshot.Store
+}
+
+func (failingSnapshots) Save(context.Context, snapshot.Snapshot) error {
+	return errors.New("disk is full")
+}
+
+// returns aggregate.Handler of snapshotAccount taking snapshot after every event.
+func snapshotAccountHandler(store eventstore.EventStore, snapshots snapshot.Store, prefix string) *aggregate.Handler {
+	handler := testAccountHandler(store, "withdraw", withdraw)
+	handler.StreamPrefix = prefix
+	handler.New = func(id string) aggregate.Aggregate {
+		return &snapshotAccount{testAccount{id: id}}
+	}
+	handler.Snapshots = snapshots
+	handler.SnapshotPolicy = snapshot.Policy{Every: 1}
+
+	return handler
+}
+
+type snapshotAccount struct {
+	testAccount
+}
+
+func (a *snapshotAccount) MarshalSnapshot() (int, []byte, error) {
+	return 1, []byte(strconv.Itoa(a.balance)), nil
+}
+
+func (a *snapshotAccount) UnmarshalSnapshot(schemaVersion int, data []byte) error {
+	if schemaVersion != 1 {
+		return snapshot.ErrIncompatibleSnapshot
+	}
+
+	balance, err := strconv.Atoi(string(data))
+	if err != nil {
+		return err
+	}
+
+	a.balance = balance
+
+	return nil
+}
+
+func deposits(amounts ...int) []command.Event {
+	events := make([]command.Event, 0, len(amounts))
+	for _, amount := range amounts {
+		events = append(events, command.E{Type: "deposited", P: []byte(strconv.Itoa(amount))})
+	}
+
+	return events
+}
+
+func testSnapshotPolicy(t *testing.T) {
+	assert := assert.New(t)
+	policy := snapshot.Policy{Every: 3}
+
+	assert.False(policy.ShouldSnapshot(0, 2), "snapshot should not be taken before 3 events")
+	assert.True(policy.ShouldSnapshot(2, 3), "snapshot should be taken at 3 events")
+	assert.True(policy.ShouldSnapshot(1, 7), "snapshot should be taken when multiple of 3 is crossed")
+	assert.False(policy.ShouldSnapshot(3, 5), "snapshot should not be taken between multiples of 3")
+	assert.False(snapshot.Policy{}.ShouldSnapshot(0, 100), "snapshot should be taken only on demand")
+}
+
+func testSnapshotRepositoryShouldFoldNewerEvents(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	repository := &snapshot.Repository{Snapshots: snapshot.NewInMemory(), Events: store}
+
+	_, err := store.Append(ctx, "account-1", eventstore.NoStream, deposits(10, 20, 30)...)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	account := &snapshotAccount{}
+	version, err := repository.Load(ctx, "account-1", "1", account)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(3), version)
+
+	err = repository.Snapshot(ctx, "1", version, account)
+	assert.NoError(err, "no error should be returned")
+
+	_, err = store.Append(ctx, "account-1", version, deposits(40)...)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	account = &snapshotAccount{}
+	version, err = repository.Load(ctx, "account-1", "1", account)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(4), version)
+	assert.Equal(100, account.balance, "balance should be restored")
+	assert.Equal(1, account.applied, "only events newer than snapshot should be applied")
+}
+
+func testSnapshotRepositoryShouldIgnoreIncompatibleSnapshot(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	snapshots := snapshot.NewInMemory()
+	repository := &snapshot.Repository{Snapshots: snapshots, Events: store}
+
+	_, err := store.Append(ctx, "account-1", eventstore.NoStream, deposits(10, 20)...)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	err = snapshots.Save(ctx, snapshot.Snapshot{ID: "1", Version: 2, SchemaVersion: 0, Data: []byte("999")})
+	assert.NoError(err, "no error should be returned")
+
+	account := &snapshotAccount{}
+	version, err := repository.Load(ctx, "account-1", "1", account)
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(2), version)
+	assert.Equal(30, account.balance, "balance should be restored from all events")
+	assert.Equal(2, account.applied, "all events should be applied")
+}
+
+func testSnapshotFileStore(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "snapshots.log")
+	store, err := snapshot.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.NoError(store.Save(ctx, snapshot.Snapshot{ID: "1", Version: 2, SchemaVersion: 1, Data: []byte("20")}))
+	assert.NoError(store.Save(ctx, snapshot.Snapshot{ID: "1", Version: 4, SchemaVersion: 1, Data: []byte("40")}))
+	assert.NoError(store.Save(ctx, snapshot.Snapshot{ID: "2", Version: 1, SchemaVersion: 1, Data: []byte("10")}))
+	assert.NoError(store.Compact())
+	store.Close()
+
+	store, err = snapshot.NewFileStore(path, true)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	s, ok, err := store.Load(ctx, "1")
+	assert.NoError(err, "no error should be returned")
+	assert.True(ok, "snapshot should be found")
+	assert.Equal(int64(4), s.Version, "latest snapshot should be loaded")
+	assert.Equal("40", string(s.Data))
+
+	_, ok, _ = store.Load(ctx, "3")
+	assert.False(ok, "snapshot should not be found")
+}
+
+func testSnapshotFileStoreShouldCompactItself(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	path := filepath.Join(tempDir(t), "snapshots.log")
+	store, err := snapshot.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	for version := int64(1); version <= 250; version++ {
+		data := []byte(strconv.FormatInt(version*10, 10))
+		assert.NoError(store.Save(ctx, snapshot.Snapshot{ID: "1", Version: version, SchemaVersion: 1, Data: data}))
+	}
+
+	assert.NoError(store.Close())
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	assert.True(bytes.Count(b, []byte("\n")) <= 101, "outdated snapshots should be removed")
+
+	store, err = snapshot.NewFileStore(path, false)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	defer store.Close()
+
+	s, ok, _ := store.Load(ctx, "1")
+	assert.True(ok, "snapshot should be found")
+	assert.Equal(int64(250), s.Version, "latest snapshot should survive compaction")
+}
+
+func testSnapshotAggregateHandler(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	snapshots := snapshot.NewInMemory()
+
+	_, err := store.Append(ctx, "account-1", eventstore.NoStream, deposits(100)...)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	handler := testAccountHandler(store, "withdraw", withdraw)
+	handler.New = func(id string) aggregate.Aggregate {
+		return &snapshotAccount{testAccount{id: id}}
+	}
+	handler.Snapshots = snapshots
+	handler.SnapshotPolicy = snapshot.Policy{Every: 2}
+
+	c, _ := command.New(handler, command.HandlerFunc("withdrawn", func(context.Context, []byte) error { return nil }))
+	for i := 0; i < 4; i++ {
+		ev := c.Handle(ctx, command.E{Type: "withdraw", P: []byte(`{"account": "1", "amount": 10}`)})
+		assert.NoError(ev.Err(), "no error should be returned")
+	}
+
+	s, ok, _ := snapshots.Load(ctx, "account-1")
+	if assert.True(ok, "snapshot should be taken") {
+		assert.Equal(int64(4), s.Version, "snapshot should be taken every 2 events")
+		assert.Equal("70", string(s.Data))
+	}
+
+	account, version, err := handler.Load(ctx, "1")
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(int64(5), version)
+	assert.Equal(60, account.(*snapshotAccount).balance, "balance should be restored")
+	assert.Equal(1, account.(*snapshotAccount).applied, "only events newer than snapshot should be applied")
+
+	assert.NoError(handler.Snapshot(ctx, "1"), "snapshot should be taken on demand")
+
+	s, _, _ = snapshots.Load(ctx, "account-1")
+	assert.Equal(int64(5), s.Version, "snapshot should be taken on demand")
+}
+
+func testSnapshotErrorShouldNotFailCommand(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+
+	_, err := store.Append(ctx, "account-1", eventstore.NoStream, deposits(100)...)
+	if err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	var snapshotErrors []string
+	handler := snapshotAccountHandler(store, failingSnapshots{snapshot.NewInMemory()}, "account-")
+	handler.OnSnapshotError = func(id string, err error) {
+		snapshotErrors = append(snapshotErrors, id+": "+err.Error())
+	}
+
+	withdrawnWasCalled := &wasCalledCounter{}
+	c, _ := command.New(handler, command.HandlerFunc("withdrawn", func(context.Context, []byte) error {
+		withdrawnWasCalled.increase()
+		return nil
+	}))
+
+	ev := c.Handle(ctx, command.E{Type: "withdraw", P: []byte(`{"account": "1", "amount": 10}`)})
+	assert.NoError(ev.Err(), "snapshot error should not fail command")
+	assert.Equal(1, withdrawnWasCalled.getCount(), "stored events should be chained")
+	assert.Equal([]string{"1: disk is full"}, snapshotErrors, "snapshot error should be reported")
+}
+
+func testSnapshotsShouldBeKeyedByStreamID(t *testing.T) {
+	ctx := context.TODO()
+	assert := assert.New(t)
+	store := eventstore.NewInMemory()
+	snapshots := snapshot.NewInMemory()
+
+	if _, err := store.Append(ctx, "account-1", eventstore.NoStream, deposits(100)...); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	if _, err := store.Append(ctx, "savings-1", eventstore.NoStream, deposits(500)...); err != nil {
+		assert.FailNow(err.Error())
+	}
+
+	accounts := snapshotAccountHandler(store, snapshots, "account-")
+	savings := snapshotAccountHandler(store, snapshots, "savings-")
+	savings.Type = "withdraw_savings"
+
+	c, _ := command.New(accounts, savings,
+		command.HandlerFunc("withdrawn", func(context.Context, []byte) error { return nil }))
+	for _, eventType := range []string{"withdraw", "withdraw_savings"} {
+		ev := c.Handle(ctx, command.E{Type: eventType, P: []byte(`{"account": "1", "amount": 10}`)})
+		assert.NoError(ev.Err(), "no error should be returned")
+	}
+
+	account, _, err := accounts.Load(ctx, "1")
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(90, account.(*snapshotAccount).balance, "balance should be restored from own snapshot")
+
+	account, _, err = savings.Load(ctx, "1")
+	assert.NoError(err, "no error should be returned")
+	assert.Equal(490, account.(*snapshotAccount).balance, "balance should be restored from own snapshot")
+}
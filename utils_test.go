@@ -1,7 +1,10 @@
 package tinycqs
 
 import (
+	"io/ioutil"
+	"os"
 	"sync"
+	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -45,3 +48,15 @@ func (ahc *activeHandlersCounter) decrease() {
 
 	ahc.count--
 }
+
+// returns temporary directory removed once test is finished.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "tinycqs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return dir
+}